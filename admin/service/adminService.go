@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	admin "tx-demo/admin/proto"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+)
+
+const (
+	defaultListUsersPageSize = 20
+	maxListUsersPageSize     = 100
+)
+
+type AdminServiceServer struct {
+	admin.UnimplementedAdminServiceServer
+	logger      *zap.Logger
+	jwt         *pkg.JWT
+	userRepo    repository.UserRepository
+	tokenRepo   repository.TokenRepository
+	auditRepo   repository.AuditRepository
+	sessionRepo repository.SessionRepository
+	opentracing opentracing.Tracer
+	rdb         *redis.Client
+}
+
+func NewAdminServiceServer(logger *zap.Logger, jwt *pkg.JWT, userRepo repository.UserRepository, tokenRepo repository.TokenRepository, auditRepo repository.AuditRepository, sessionRepo repository.SessionRepository, opentracing opentracing.Tracer, rdb *redis.Client) AdminServiceServer {
+	return AdminServiceServer{
+		logger:      logger,
+		jwt:         jwt,
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		auditRepo:   auditRepo,
+		sessionRepo: sessionRepo,
+		opentracing: opentracing,
+		rdb:         rdb,
+	}
+}
+
+// ListUsers 按主键游标分页查询用户
+func (s AdminServiceServer) ListUsers(ctx context.Context, req *admin.ListUsersRequest) (*admin.ListUsersResponse, error) {
+	s.logger.Info("ListUsers called", zap.String("username_prefix", req.UsernamePrefix), zap.String("page_token", req.PageToken))
+
+	// 1.校验管理员身份和分页参数
+	operator, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultListUsersPageSize
+	}
+	if pageSize > maxListUsersPageSize {
+		pageSize = maxListUsersPageSize
+	}
+	var afterID int64
+	if req.PageToken != "" {
+		afterID, err = strconv.ParseInt(req.PageToken, 10, 64)
+		if err != nil || afterID < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, pkg.ErrInvalidPageToken)
+		}
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "AdminService.ListUsers")
+	span.SetTag("operatorId", operator.UserID)
+	defer span.Finish()
+
+	// 3.多查一条用于判断是否还有下一页
+	filter := repository.UserFilter{UsernamePrefix: req.UsernamePrefix}
+	if req.CreatedAfter != nil {
+		filter.CreatedAfter = req.CreatedAfter.AsTime()
+	}
+	if req.CreatedBefore != nil {
+		filter.CreatedBefore = req.CreatedBefore.AsTime()
+	}
+	users, err := s.userRepo.ListUsers(ctx, filter, afterID, pageSize+1)
+	if err != nil {
+		s.logger.Error("Failed to list users", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	resp := &admin.ListUsersResponse{}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		resp.NextPageToken = strconv.FormatInt(users[pageSize-1].ID, 10)
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, toAdminUser(user))
+	}
+	return resp, nil
+}
+
+// GetUser 查询单个用户
+func (s AdminServiceServer) GetUser(ctx context.Context, req *admin.GetUserRequest) (*admin.AdminUser, error) {
+	s.logger.Info("GetUser called", zap.String("user_id", req.UserId))
+
+	operator, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "AdminService.GetUser")
+	span.SetTag("operatorId", operator.UserID)
+	span.SetTag("userId", req.UserId)
+	defer span.Finish()
+
+	user, err := s.findUser(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return toAdminUser(user), nil
+}
+
+// DisableUser 禁用用户，并吊销其已签发的令牌和登录会话
+func (s AdminServiceServer) DisableUser(ctx context.Context, req *admin.UserActionRequest) (*admin.AdminUser, error) {
+	return s.setDisabled(ctx, req, true)
+}
+
+// EnableUser 启用用户
+func (s AdminServiceServer) EnableUser(ctx context.Context, req *admin.UserActionRequest) (*admin.AdminUser, error) {
+	return s.setDisabled(ctx, req, false)
+}
+
+func (s AdminServiceServer) setDisabled(ctx context.Context, req *admin.UserActionRequest, disabled bool) (*admin.AdminUser, error) {
+	action, spanName := model.AuditActionEnableUser, "AdminService.EnableUser"
+	if disabled {
+		action, spanName = model.AuditActionDisableUser, "AdminService.DisableUser"
+	}
+	s.logger.Info(spanName+" called", zap.String("user_id", req.UserId))
+
+	// 1.校验管理员身份
+	operator, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, spanName)
+	span.SetTag("operatorId", operator.UserID)
+	span.SetTag("userId", req.UserId)
+	defer span.Finish()
+
+	// 3.更新状态，禁用时同时强制下线
+	user, err := s.findUser(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.UpdateDisabled(ctx, user.UserID, disabled); err != nil {
+		s.logger.Error("Failed to update user status", zap.Error(err))
+		s.audit(ctx, operator, action, user.UserID, model.AuditOutcomeFailure, req.Reason)
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if disabled {
+		if err := s.logout(ctx, user.UserID); err != nil {
+			s.audit(ctx, operator, action, user.UserID, model.AuditOutcomeFailure, req.Reason)
+			return nil, err
+		}
+	}
+	user.Disabled = disabled
+
+	s.audit(ctx, operator, action, user.UserID, model.AuditOutcomeSuccess, req.Reason)
+	s.logger.Info("User status updated", zap.String("operator_id", operator.UserID), zap.String("user_id", user.UserID), zap.Bool("disabled", disabled))
+	return toAdminUser(user), nil
+}
+
+// ForceLogout 强制下线，吊销用户已签发的全部令牌和登录会话
+func (s AdminServiceServer) ForceLogout(ctx context.Context, req *admin.UserActionRequest) (*emptypb.Empty, error) {
+	s.logger.Info("ForceLogout called", zap.String("user_id", req.UserId))
+
+	operator, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "AdminService.ForceLogout")
+	span.SetTag("operatorId", operator.UserID)
+	span.SetTag("userId", req.UserId)
+	defer span.Finish()
+
+	user, err := s.findUser(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.logout(ctx, user.UserID); err != nil {
+		s.audit(ctx, operator, model.AuditActionForceLogout, user.UserID, model.AuditOutcomeFailure, req.Reason)
+		return nil, err
+	}
+
+	s.audit(ctx, operator, model.AuditActionForceLogout, user.UserID, model.AuditOutcomeSuccess, req.Reason)
+	s.logger.Info("User forced to logout", zap.String("operator_id", operator.UserID), zap.String("user_id", user.UserID))
+	return &emptypb.Empty{}, nil
+}
+
+// authorize 校验登录状态，并要求当前用户为未禁用的管理员
+func (s AdminServiceServer) authorize(ctx context.Context) (*model.User, error) {
+	claims, err := pkg.AuthenticateToken(ctx, *s.jwt, s.tokenRepo, s.sessionRepo)
+	switch {
+	case err == nil:
+	case errors.Is(err, pkg.ErrAuthMissingToken), errors.Is(err, pkg.ErrAuthInvalidToken):
+		s.logger.Info("Authentication failed", zap.Error(err))
+		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrUnauthorized)
+	case errors.Is(err, pkg.ErrAuthTokenRevoked), errors.Is(err, pkg.ErrAuthSessionRevoked):
+		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrTokenRevoked)
+	default:
+		s.logger.Error("Failed to authenticate token", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	operator, err := s.userRepo.FindByUserID(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Errorf(codes.Unauthenticated, pkg.ErrUnauthorized)
+		}
+		s.logger.Error("Failed to query operator", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if operator.Role != model.RoleAdmin || operator.Disabled {
+		s.logger.Warn("Admin access denied", zap.String("user_id", operator.UserID))
+		s.audit(ctx, operator, model.AuditActionAdminAccess, "", model.AuditOutcomeDenied, "")
+		return nil, status.Errorf(codes.PermissionDenied, pkg.ErrPermissionDenied)
+	}
+	return operator, nil
+}
+
+// logout 吊销用户已签发的全部令牌和登录会话，会话列表中不再显示被强制下线的设备
+func (s AdminServiceServer) logout(ctx context.Context, userID string) error {
+	if err := s.tokenRepo.RevokeUserTokens(ctx, userID); err != nil {
+		s.logger.Error("Failed to revoke tokens", zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if _, err := s.sessionRepo.RevokeUserSessions(ctx, userID); err != nil {
+		s.logger.Error("Failed to revoke sessions", zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	return nil
+}
+
+// findUser 查询被操作的用户
+func (s AdminServiceServer) findUser(ctx context.Context, userID string) (*model.User, error) {
+	if userID == "" {
+		return nil, status.Errorf(codes.InvalidArgument, pkg.ErrInvalidUserID)
+	}
+	user, err := s.userRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Errorf(codes.NotFound, pkg.ErrUserNotFound)
+		}
+		s.logger.Error("Failed to query user", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	return user, nil
+}
+
+// audit 记录管理操作，写入失败只记录日志，不影响操作结果
+func (s AdminServiceServer) audit(ctx context.Context, operator *model.User, action, targetID, outcome, detail string) {
+	event := &model.AuditEvent{
+		ActorID:  operator.UserID,
+		Action:   action,
+		TargetID: targetID,
+		Outcome:  outcome,
+		IP:       pkg.ClientIP(ctx),
+		TraceID:  pkg.TraceIDFromContext(ctx),
+		Detail:   detail,
+	}
+	if err := s.auditRepo.CreateEvent(ctx, event); err != nil {
+		s.logger.Error("Failed to write audit event", zap.String("action", action), zap.Error(err))
+	}
+}
+
+func toAdminUser(user *model.User) *admin.AdminUser {
+	return &admin.AdminUser{
+		UserId:          user.UserID,
+		Username:        user.Username,
+		Like:            user.Like,
+		Role:            user.Role,
+		Disabled:        user.Disabled,
+		EmbeddingStatus: user.EmbeddingStatus,
+		CreateAt:        timestamppb.New(user.CreatedAt),
+		UpdateAt:        timestamppb.New(user.UpdatedAt),
+	}
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	admin "tx-demo/admin/proto"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+)
+
+// testEnv 通过 bufconn 在内存中启动的 AdminService，包含一个管理员 root 和 5 个普通用户 u1..u5
+type testEnv struct {
+	client   admin.AdminServiceClient
+	users    *fakeUserRepository
+	tokens   repository.TokenRepository
+	audits   *fakeAuditRepository
+	sessions *fakeSessionRepository
+	jwt      *pkg.JWT
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	conf := viper.New()
+	conf.Set("security.jwt.key", "test-key")
+
+	users := []*model.User{{ID: 1, UserID: "root", Username: "root", Role: model.RoleAdmin}}
+	for i := 1; i <= 5; i++ {
+		users = append(users, &model.User{ID: int64(i + 1), UserID: fmt.Sprintf("u%d", i), Username: fmt.Sprintf("u%d", i), Role: model.RoleUser})
+	}
+	env := &testEnv{
+		users:    newFakeUserRepository(users...),
+		tokens:   repository.NewTokenRepository(repository.NewRepository(nil, rdb)),
+		audits:   &fakeAuditRepository{},
+		sessions: &fakeSessionRepository{},
+		jwt:      pkg.NewJwt(conf),
+	}
+	svc := NewAdminServiceServer(zap.NewNop(), env.jwt, env.users, env.tokens, env.audits, env.sessions, opentracing.NoopTracer{}, rdb)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	admin.RegisterAdminServiceServer(server, &svc)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	env.client = admin.NewAdminServiceClient(conn)
+	return env
+}
+
+// login 为用户创建会话并签发令牌，返回携带令牌的 ctx 和会话ID
+func (e *testEnv) login(t *testing.T, userID string) (context.Context, string) {
+	t.Helper()
+	sessionID := uuid.NewString()
+	_ = e.sessions.CreateSession(context.Background(), &model.Session{
+		SessionID: sessionID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(pkg.TokenExpiration),
+	})
+	token, _, err := pkg.GenerateJWT(userID, sessionID, *e.jwt)
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "token", token), sessionID
+}
+
+func assertCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("error code = %v, want %v (err = %v)", got, want, err)
+	}
+}
+
+func TestAdminService_Authorize(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.client.ListUsers(context.Background(), &admin.ListUsersRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+	bad := metadata.AppendToOutgoingContext(context.Background(), "token", "not-a-jwt")
+	_, err = env.client.ListUsers(bad, &admin.ListUsersRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// 普通用户访问管理接口被拒绝并记录审计事件
+	ctx, _ := env.login(t, "u1")
+	_, err = env.client.ListUsers(ctx, &admin.ListUsersRequest{})
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = env.client.ForceLogout(ctx, &admin.UserActionRequest{UserId: "u2"})
+	assertCode(t, err, codes.PermissionDenied)
+	if n := env.audits.count(model.AuditActionAdminAccess, model.AuditOutcomeDenied); n != 2 {
+		t.Errorf("denied audit events = %d, want 2", n)
+	}
+
+	// 被禁用的管理员同样被拒绝
+	rootCtx, _ := env.login(t, "root")
+	_ = env.users.UpdateDisabled(context.Background(), "root", true)
+	_, err = env.client.ListUsers(rootCtx, &admin.ListUsersRequest{})
+	assertCode(t, err, codes.PermissionDenied)
+}
+
+func TestAdminService_ListUsers(t *testing.T) {
+	env := newTestEnv(t)
+	ctx, _ := env.login(t, "root")
+
+	var (
+		got       []string
+		pageToken string
+		pages     int
+	)
+	for {
+		resp, err := env.client.ListUsers(ctx, &admin.ListUsersRequest{PageSize: 4, PageToken: pageToken})
+		if err != nil {
+			t.Fatalf("ListUsers() error = %v", err)
+		}
+		pages++
+		for _, user := range resp.Users {
+			got = append(got, user.UserId)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+	if pages != 2 || fmt.Sprint(got) != "[root u1 u2 u3 u4 u5]" {
+		t.Errorf("ListUsers() pages = %d, users = %v", pages, got)
+	}
+
+	// 页大小为 0 或超过上限时使用默认值和上限
+	for _, pageSize := range []int32{0, -1, maxListUsersPageSize + 1} {
+		resp, err := env.client.ListUsers(ctx, &admin.ListUsersRequest{PageSize: pageSize})
+		if err != nil {
+			t.Fatalf("ListUsers(page_size=%d) error = %v", pageSize, err)
+		}
+		if len(resp.Users) != 6 || resp.NextPageToken != "" {
+			t.Errorf("ListUsers(page_size=%d) = %d users, next %q", pageSize, len(resp.Users), resp.NextPageToken)
+		}
+	}
+
+	resp, err := env.client.ListUsers(ctx, &admin.ListUsersRequest{UsernamePrefix: "u", PageToken: "3"})
+	if err != nil {
+		t.Fatalf("ListUsers() error = %v", err)
+	}
+	if len(resp.Users) != 3 || resp.Users[0].UserId != "u3" {
+		t.Errorf("ListUsers(after 3) = %v", resp.Users)
+	}
+
+	for _, token := range []string{"abc", "-1", "1.5"} {
+		_, err := env.client.ListUsers(ctx, &admin.ListUsersRequest{PageToken: token})
+		assertCode(t, err, codes.InvalidArgument)
+	}
+}
+
+func TestAdminService_DisableUser(t *testing.T) {
+	env := newTestEnv(t)
+	rootCtx, _ := env.login(t, "root")
+	before := time.Now().UnixMilli()
+	userCtx, _ := env.login(t, "u1")
+	env.login(t, "u1")
+	time.Sleep(2 * time.Millisecond)
+
+	user, err := env.client.DisableUser(rootCtx, &admin.UserActionRequest{UserId: "u1", Reason: "spam"})
+	if err != nil {
+		t.Fatalf("DisableUser() error = %v", err)
+	}
+	if !user.Disabled {
+		t.Errorf("DisableUser() disabled = false")
+	}
+
+	// 令牌和会话都被吊销
+	revoked, err := env.tokens.IsRevoked(context.Background(), "u1", before)
+	if err != nil || !revoked {
+		t.Errorf("IsRevoked() = %v, %v, want true", revoked, err)
+	}
+	_, err = env.client.GetUser(userCtx, &admin.GetUserRequest{UserId: "u1"})
+	assertCode(t, err, codes.Unauthenticated)
+	if sessions, _ := env.sessions.ListActiveSessions(context.Background(), "u1"); len(sessions) != 0 {
+		t.Errorf("active sessions = %d, want 0", len(sessions))
+	}
+	if n := env.audits.count(model.AuditActionDisableUser, model.AuditOutcomeSuccess); n != 1 {
+		t.Errorf("disable audit events = %d, want 1", n)
+	}
+
+	_, err = env.client.DisableUser(rootCtx, &admin.UserActionRequest{UserId: "missing"})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestAdminService_ForceLogout(t *testing.T) {
+	env := newTestEnv(t)
+	rootCtx, _ := env.login(t, "root")
+	userCtx, _ := env.login(t, "u1")
+	env.login(t, "u2")
+
+	if _, err := env.client.ForceLogout(rootCtx, &admin.UserActionRequest{UserId: "u1"}); err != nil {
+		t.Fatalf("ForceLogout() error = %v", err)
+	}
+	if sessions, _ := env.sessions.ListActiveSessions(context.Background(), "u1"); len(sessions) != 0 {
+		t.Errorf("u1 active sessions = %d, want 0", len(sessions))
+	}
+	if sessions, _ := env.sessions.ListActiveSessions(context.Background(), "u2"); len(sessions) != 1 {
+		t.Errorf("u2 active sessions = %d, want 1", len(sessions))
+	}
+	// 被下线用户的令牌不能再访问
+	_, err := env.client.ListUsers(userCtx, &admin.ListUsersRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	admin "tx-demo/admin/proto"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+)
+
+const (
+	defaultListAuditEventsPageSize = 50
+	maxListAuditEventsPageSize     = 200
+)
+
+// ListAuditEvents 按时间倒序分页查询审计事件
+func (s AdminServiceServer) ListAuditEvents(ctx context.Context, req *admin.ListAuditEventsRequest) (*admin.ListAuditEventsResponse, error) {
+	s.logger.Info("ListAuditEvents called", zap.String("action", req.Action), zap.String("page_token", req.PageToken))
+
+	// 1.校验管理员身份和分页参数
+	operator, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultListAuditEventsPageSize
+	}
+	if pageSize > maxListAuditEventsPageSize {
+		pageSize = maxListAuditEventsPageSize
+	}
+	var beforeID int64
+	if req.PageToken != "" {
+		beforeID, err = strconv.ParseInt(req.PageToken, 10, 64)
+		if err != nil || beforeID <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, pkg.ErrInvalidPageToken)
+		}
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "AdminService.ListAuditEvents")
+	span.SetTag("operatorId", operator.UserID)
+	defer span.Finish()
+
+	// 3.多查一条用于判断是否还有下一页
+	filter := repository.AuditFilter{
+		ActorID:  req.ActorId,
+		TargetID: req.TargetId,
+		Action:   req.Action,
+		Outcome:  req.Outcome,
+	}
+	if req.Since != nil {
+		filter.Since = req.Since.AsTime()
+	}
+	if req.Until != nil {
+		filter.Until = req.Until.AsTime()
+	}
+	events, err := s.auditRepo.ListEvents(ctx, filter, beforeID, pageSize+1)
+	if err != nil {
+		s.logger.Error("Failed to list audit events", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	resp := &admin.ListAuditEventsResponse{}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		resp.NextPageToken = strconv.FormatInt(events[pageSize-1].ID, 10)
+	}
+	for _, event := range events {
+		resp.Events = append(resp.Events, toAuditEvent(event))
+	}
+	return resp, nil
+}
+
+func toAuditEvent(event *model.AuditEvent) *admin.AuditEvent {
+	return &admin.AuditEvent{
+		Id:       event.ID,
+		ActorId:  event.ActorID,
+		Action:   event.Action,
+		TargetId: event.TargetID,
+		Outcome:  event.Outcome,
+		Ip:       event.IP,
+		TraceId:  event.TraceID,
+		Detail:   event.Detail,
+		CreateAt: timestamppb.New(event.CreatedAt),
+	}
+}
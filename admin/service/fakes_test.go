@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"tx-demo/model"
+	"tx-demo/repository"
+)
+
+// fakeUserRepository 内存实现的 UserRepository，只实现管理后台用到的方法
+type fakeUserRepository struct {
+	repository.UserRepository
+
+	mu    sync.Mutex
+	users map[string]*model.User
+}
+
+func newFakeUserRepository(users ...*model.User) *fakeUserRepository {
+	f := &fakeUserRepository{users: make(map[string]*model.User)}
+	for _, user := range users {
+		f.users[user.UserID] = user
+	}
+	return f
+}
+
+func (f *fakeUserRepository) FindByUserID(ctx context.Context, userID string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUserRepository) ListUsers(ctx context.Context, filter repository.UserFilter, afterID int64, limit int) ([]*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var users []*model.User
+	for _, user := range f.users {
+		if user.ID > afterID && strings.HasPrefix(user.Username, filter.UsernamePrefix) {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (f *fakeUserRepository) UpdateDisabled(ctx context.Context, userID string, disabled bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[userID]; ok {
+		user.Disabled = disabled
+	}
+	return nil
+}
+
+// fakeAuditRepository 记录写入的审计事件
+type fakeAuditRepository struct {
+	repository.AuditRepository
+
+	mu     sync.Mutex
+	events []*model.AuditEvent
+}
+
+func (f *fakeAuditRepository) CreateEvent(ctx context.Context, event *model.AuditEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	event.ID = int64(len(f.events) + 1)
+	f.events = append(f.events, event)
+	return nil
+}
+
+func (f *fakeAuditRepository) count(action, outcome string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	for _, event := range f.events {
+		if event.Action == action && event.Outcome == outcome {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeSessionRepository 内存实现的 SessionRepository
+type fakeSessionRepository struct {
+	mu       sync.Mutex
+	sessions []*model.Session
+}
+
+func (f *fakeSessionRepository) CreateSession(ctx context.Context, session *model.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions = append(f.sessions, session)
+	return nil
+}
+
+func (f *fakeSessionRepository) TouchSession(ctx context.Context, sessionID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, session := range f.sessions {
+		if session.SessionID == sessionID && session.RevokedAt == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeSessionRepository) ListActiveSessions(ctx context.Context, userID string) ([]*model.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var sessions []*model.Session
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (f *fakeSessionRepository) RevokeSession(ctx context.Context, userID string, sessionID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, session := range f.sessions {
+		if session.SessionID == sessionID && session.UserID == userID && session.RevokedAt == nil {
+			now := time.Now()
+			session.RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeSessionRepository) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var revoked int
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+	"tx-demo/user/worker"
+)
+
+// runBackfill 重新计算已有用户喜好和兴趣标签的嵌入向量，切换嵌入模型或维度后使用
+//
+//	go run . backfill -batch 50 -rate 20 -status pending,failed
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	batchSize := fs.Int("batch", 50, "每批处理的用户数")
+	rate := fs.Float64("rate", 10, "每秒最多嵌入的文本条数，0 表示不限速")
+	statuses := fs.String("status", "", "只处理这些嵌入状态的用户，逗号分隔，为空表示全部")
+	reset := fs.Bool("reset", false, "忽略已有断点，从头开始")
+	_ = fs.Parse(args)
+
+	config := worker.BackfillConfig{
+		BatchSize: *batchSize,
+		Rate:      *rate,
+		Reset:     *reset,
+	}
+	if *statuses != "" {
+		config.Statuses = strings.Split(*statuses, ",")
+	}
+
+	var (
+		backfill *worker.Backfill
+		logger   *zap.Logger
+	)
+	app := fx.New(
+		fx.NopLogger,
+		fx.Supply(config),
+		fx.Provide(
+			repository.NewRepository,
+			repository.NewDB,
+			repository.NewRedis,
+			repository.NewUserRepository,
+			repository.NewInterestRepository,
+			repository.NewTransaction,
+			pkg.NewViper,
+			pkg.NewLogger,
+			pkg.NewEmbedder,
+			worker.NewBackfill,
+		),
+		fx.Populate(&backfill, &logger),
+	)
+	if err := app.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to initialize backfill:", err)
+		os.Exit(1)
+	}
+
+	// 收到中断信号后停止，下次运行从断点继续
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := backfill.Run(ctx); err != nil {
+		logger.Fatal("backfill failed", zap.Error(err))
+	}
+}
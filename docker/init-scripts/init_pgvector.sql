@@ -11,6 +11,20 @@ CREATE TABLE IF NOT EXISTS users (
     -- like 是关键字因此打上双引号
      "like" varchar(255),
      like_embedding vector(1024),
+    -- 嵌入向量由后台异步计算：pending / ready / failed
+     embedding_status varchar(20) NOT NULL DEFAULT 'pending',
+    -- 角色：user / admin
+     role varchar(20) NOT NULL DEFAULT 'user',
+    -- 禁用后无法登录
+     disabled boolean NOT NULL DEFAULT false,
+    -- 联系方式，修改后需要重新验证
+     email varchar(255),
+     phone varchar(32),
+     email_verified boolean NOT NULL DEFAULT false,
+     phone_verified boolean NOT NULL DEFAULT false,
+    -- 两步验证密钥（AES-GCM 加密），确认绑定后 totp_enabled 为 true
+     totp_secret varchar(255),
+     totp_enabled boolean NOT NULL DEFAULT false,
      created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
      updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
     -- 添加软删除字段
@@ -20,4 +34,73 @@ CREATE TABLE IF NOT EXISTS users (
 -- 为deleted_at字段添加索引，提高查询性能
 CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
 -- 为username字段添加索引，提高查询性能
-CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
+-- 喜好向量的 HNSW 近似最近邻索引，相似用户查询按余弦距离排序时使用
+CREATE INDEX IF NOT EXISTS idx_users_like_embedding ON users USING hnsw (like_embedding vector_cosine_ops);
+
+-- 创建用户兴趣表，每个兴趣标签一条嵌入向量
+CREATE TABLE IF NOT EXISTS user_interests (
+     id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+     user_id varchar(36) NOT NULL,
+     tag varchar(100) NOT NULL,
+     embedding vector(1024),
+     created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     CONSTRAINT idx_user_interests_user_tag UNIQUE (user_id, tag)
+);
+
+-- 兴趣向量的 HNSW 近似最近邻索引
+CREATE INDEX IF NOT EXISTS idx_user_interests_embedding ON user_interests USING hnsw (embedding vector_cosine_ops);
+
+-- 创建审计事件表，只追加不修改
+CREATE TABLE IF NOT EXISTS audit_events (
+     id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+     actor_id varchar(36),
+     action varchar(50) NOT NULL,
+     target_id varchar(36),
+     outcome varchar(20) NOT NULL,
+     ip varchar(64),
+     trace_id varchar(64),
+     detail text,
+     created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events(target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+
+-- 创建登录会话表，访问令牌与会话绑定
+CREATE TABLE IF NOT EXISTS sessions (
+     id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+     session_id varchar(36) NOT NULL UNIQUE,
+     user_id varchar(36) NOT NULL,
+     device varchar(100),
+     user_agent varchar(255),
+     ip varchar(64),
+     created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     last_seen_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     expires_at timestamp NOT NULL,
+    -- 为空表示未吊销
+     revoked_at timestamp NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+-- 创建两步验证恢复码表，只保存哈希
+CREATE TABLE IF NOT EXISTS recovery_codes (
+     id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+     user_id varchar(36) NOT NULL,
+     code_hash varchar(64) NOT NULL,
+     created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- 为空表示未使用
+     used_at timestamp NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+-- 创建栅栏令牌表，拒绝携带过期锁令牌的写入
+CREATE TABLE IF NOT EXISTS fencing_tokens (
+     resource varchar(255) PRIMARY KEY,
+     token bigint NOT NULL,
+     updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -8,6 +8,9 @@ import (
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	admin "tx-demo/admin/proto"
+	adminService "tx-demo/admin/service"
 	"tx-demo/pkg"
 	"tx-demo/repository"
 	systemService "tx-demo/system/service"
@@ -18,6 +21,7 @@ import (
 	"google.golang.org/grpc"
 	user "tx-demo/user/proto"
 	userService "tx-demo/user/service"
+	"tx-demo/user/worker"
 
 	"github.com/opentracing/opentracing-go"
 )
@@ -26,6 +30,12 @@ func main() {
 	//加载环境变量
 	_ = godotenv.Load("./.env")
 
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		runBackfill(os.Args[2:])
+		return
+	}
+
 	fx.New(
 		fx.WithLogger(func() fxevent.Logger {
 			logger, _ := zap.NewDevelopment()
@@ -37,20 +47,38 @@ func main() {
 			repository.NewDB,
 			// Redis
 			repository.NewRedis,
+			// 多节点加锁
+			repository.NewLockNodes,
 			repository.NewUserRepository,
+			repository.NewFeedCache,
+			repository.NewInterestRepository,
+			repository.NewTokenRepository,
+			repository.NewAuditRepository,
+			repository.NewSessionRepository,
+			repository.NewVerificationRepository,
+			repository.NewTwoFactorRepository,
+			repository.NewFencingRepository,
 			repository.NewTransaction,
 			userService.NewUserServiceServer,
 			systemService.NewSystemServiceServer,
+			adminService.NewAdminServiceServer,
 
 			pkg.NewRedisLock,
 			pkg.NewViper,
 			pkg.NewJwt,
+			pkg.NewEmbedder,
+			pkg.NewSender,
+			pkg.NewCipher,
+			pkg.NewLeaderElector,
+			pkg.NewRateLimitInterceptor,
+			worker.NewEmbeddingQueue,
+			worker.NewEmbeddingWorker,
 			NewGRPCServer,
 			NewConfig,
 			pkg.NewLogger,
 			pkg.NewJaegerTracer,
 		),
-		fx.Invoke(StartServer, StartPprofServer), // 调用 StartPprofServer 启动 pprof 服务器
+		fx.Invoke(StartServer, StartPprofServer, worker.StartEmbeddingWorker, pkg.StartLeaderElector), // 调用 StartPprofServer 启动 pprof 服务器
 	).Run()
 }
 
@@ -66,11 +94,14 @@ func NewConfig(conf *viper.Viper) *Config {
 	}
 }
 
-func NewGRPCServer(logger *zap.Logger, userSvc userService.UserServiceServer, tracer opentracing.Tracer) *grpc.Server {
+func NewGRPCServer(logger *zap.Logger, userSvc userService.UserServiceServer, adminSvc adminService.AdminServiceServer, tracer opentracing.Tracer, rateLimiter *pkg.RateLimitInterceptor) *grpc.Server {
 	server := grpc.NewServer(
-		grpc.UnaryInterceptor(pkg.JaegerServerInterceptor(tracer)),
+		// 先创建 span，被限流的调用也能在链路中看到
+		grpc.ChainUnaryInterceptor(pkg.JaegerServerInterceptor(tracer), rateLimiter.Unary()),
+		grpc.ChainStreamInterceptor(rateLimiter.Stream()),
 	)
 	user.RegisterUserServiceServer(server, &userSvc)
+	admin.RegisterAdminServiceServer(server, &adminSvc)
 	logger.Info("gRPC server created")
 	return server
 }
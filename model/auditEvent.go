@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// AuditEvent 审计事件，只追加不修改
+type AuditEvent struct {
+	ID int64 `gorm:"primaryKey;autoIncrement:true"`
+	// 操作者用户ID，未登录时为空
+	ActorID   string    `gorm:"type:varchar(36);index"`
+	Action    string    `gorm:"type:varchar(50);notNull;index"`
+	TargetID  string    `gorm:"type:varchar(36);index"`
+	Outcome   string    `gorm:"type:varchar(20);notNull"`
+	IP        string    `gorm:"type:varchar(64)"`
+	TraceID   string    `gorm:"type:varchar(64)"`
+	Detail    string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP;index"`
+}
+
+// 审计事件结果
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeDenied  = "denied"
+)
+
+// 审计事件类型
+const (
+	AuditActionRegister      = "user.register"
+	AuditActionLogin         = "user.login"
+	AuditActionRevokeSession = "user.revoke_session"
+	AuditActionUpdateContact = "user.update_contact"
+	AuditActionVerifyContact = "user.verify_contact"
+	AuditActionResetRequest  = "user.password_reset_request"
+	AuditActionResetPassword = "user.password_reset"
+	AuditActionEnrollTOTP    = "user.totp_enroll"
+	AuditActionEnableTOTP    = "user.totp_enable"
+	AuditActionTokenInvalid  = "auth.token_invalid"
+	AuditActionTokenRevoked  = "auth.token_revoked"
+
+	AuditActionAdminAccess = "admin.access"
+	AuditActionDisableUser = "admin.disable_user"
+	AuditActionEnableUser  = "admin.enable_user"
+	AuditActionForceLogout = "admin.force_logout"
+)
+
+// TableName 指定默认表名
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// FencingToken 受锁保护资源最近一次写入携带的栅栏令牌
+type FencingToken struct {
+	Resource  string    `gorm:"type:varchar(255);primaryKey"`
+	Token     int64     `gorm:"notNull"`
+	UpdatedAt time.Time `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
+}
+
+// TableName 指定默认表名
+func (FencingToken) TableName() string {
+	return "fencing_tokens"
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// RecoveryCode 两步验证的一次性恢复码，只保存哈希
+type RecoveryCode struct {
+	ID        int64      `gorm:"primaryKey;autoIncrement:true"`
+	UserID    string     `gorm:"type:varchar(36);notNull;index"`
+	CodeHash  string     `gorm:"type:varchar(64);notNull"`
+	CreatedAt time.Time  `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
+	UsedAt    *time.Time `gorm:"type:timestamp"` // 为空表示未使用
+}
+
+// TableName 指定默认表名
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
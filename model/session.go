@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// Session 登录会话，每次登录生成一条，访问令牌与会话绑定
+type Session struct {
+	ID        int64  `gorm:"primaryKey;autoIncrement:true"`
+	SessionID string `gorm:"type:varchar(36);notNull;unique"`
+	UserID    string `gorm:"type:varchar(36);notNull;index"`
+	// 客户端通过 metadata 上报的设备名
+	Device     string     `gorm:"type:varchar(100)"`
+	UserAgent  string     `gorm:"type:varchar(255)"`
+	IP         string     `gorm:"type:varchar(64)"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
+	LastSeenAt time.Time  `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
+	ExpiresAt  time.Time  `gorm:"type:timestamp;notNull"`
+	RevokedAt  *time.Time `gorm:"type:timestamp"` // 为空表示未吊销
+}
+
+// TableName 指定默认表名
+func (Session) TableName() string {
+	return "sessions"
+}
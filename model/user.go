@@ -3,22 +3,45 @@ package model
 import (
 	"gorm.io/gorm"
 	"time"
+	"tx-demo/pkg"
 )
 
 // User 定义用户模型
 type User struct {
 	gorm.Model
-	ID            int64          `gorm:"primaryKey;autoIncrement:true"`
-	UserID        string         `gorm:"type:varchar(36);notNull;unique"`
-	Username      string         `gorm:"type:varchar(100);notNull;unique"`
-	Password      string         `gorm:"type:varchar(255);notNull"`
-	Like          string         `gorm:"type:varchar(255);column:like"` // 使用 column 标签指定列名
-	LikeEmbedding string         `gorm:"type:vector(1536)"`
-	CreatedAt     time.Time      `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
-	UpdatedAt     time.Time      `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
-	DeletedAt     gorm.DeletedAt `gorm:"index"` // 软删除字段
+	ID              int64          `gorm:"primaryKey;autoIncrement:true"`
+	UserID          string         `gorm:"type:varchar(36);notNull;unique"`
+	Username        string         `gorm:"type:varchar(100);notNull;unique"`
+	Password        string         `gorm:"type:varchar(255);notNull"`
+	Like            string         `gorm:"type:varchar(255);column:like"` // 使用 column 标签指定列名
+	LikeEmbedding   pkg.Vector     `gorm:"type:vector(1024)"`
+	EmbeddingStatus string         `gorm:"type:varchar(20);notNull;default:pending"` // 嵌入向量由后台异步计算
+	Role            string         `gorm:"type:varchar(20);notNull;default:user"`
+	Disabled        bool           `gorm:"notNull;default:false"` // 禁用后无法登录
+	Email           string         `gorm:"type:varchar(255)"`
+	Phone           string         `gorm:"type:varchar(32)"`
+	EmailVerified   bool           `gorm:"notNull;default:false"` // 修改邮箱后需要重新验证
+	PhoneVerified   bool           `gorm:"notNull;default:false"`
+	TOTPSecret      string         `gorm:"type:varchar(255);column:totp_secret"`      // AES-GCM 加密后的密钥
+	TOTPEnabled     bool           `gorm:"notNull;default:false;column:totp_enabled"` // 确认绑定后登录需要动态口令
+	CreatedAt       time.Time      `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
+	UpdatedAt       time.Time      `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
+	DeletedAt       gorm.DeletedAt `gorm:"index"` // 软删除字段
 }
 
+// 嵌入向量的计算状态
+const (
+	EmbeddingStatusPending = "pending"
+	EmbeddingStatusReady   = "ready"
+	EmbeddingStatusFailed  = "failed"
+)
+
+// 用户角色
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // TableName 指定默认表名
 func (User) TableName() string {
 	return "users"
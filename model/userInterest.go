@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+	"tx-demo/pkg"
+)
+
+// UserInterest 用户的兴趣标签，每个标签对应一条嵌入向量
+type UserInterest struct {
+	ID        int64      `gorm:"primaryKey;autoIncrement:true"`
+	UserID    string     `gorm:"type:varchar(36);notNull;uniqueIndex:idx_user_interests_user_tag"`
+	Tag       string     `gorm:"type:varchar(100);notNull;uniqueIndex:idx_user_interests_user_tag"`
+	Embedding pkg.Vector `gorm:"type:vector(1024)"`
+	CreatedAt time.Time  `gorm:"type:timestamp;notNull;default:CURRENT_TIMESTAMP"`
+}
+
+// TableName 指定默认表名
+func (UserInterest) TableName() string {
+	return "user_interests"
+}
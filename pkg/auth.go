@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// 令牌校验不通过的原因，AuthenticateToken 返回的错误可用 errors.Is 判断
+var (
+	ErrAuthMissingToken   = errors.New("missing token")
+	ErrAuthInvalidToken   = errors.New("invalid token")
+	ErrAuthTokenRevoked   = errors.New("token revoked")
+	ErrAuthSessionRevoked = errors.New("session revoked")
+)
+
+// TokenRevocations 查询用户令牌是否已被吊销（强制下线、禁用、重置密码后）
+type TokenRevocations interface {
+	IsRevoked(ctx context.Context, userID string, issuedAtMs int64) (bool, error)
+}
+
+// SessionToucher 校验会话是否有效并刷新最后活跃时间
+type SessionToucher interface {
+	TouchSession(ctx context.Context, sessionID string) (bool, error)
+}
+
+// AuthenticateToken 取出 ctx 中的令牌，依次校验签名和有效期、是否被吊销、绑定的会话是否有效
+// 令牌被吊销或会话失效时同时返回已解析的声明，便于调用方记录审计日志；
+// 返回的错误不是 ErrAuth* 时表示查询吊销状态或会话失败
+func AuthenticateToken(ctx context.Context, j JWT, revocations TokenRevocations, sessions SessionToucher) (*Claims, error) {
+	token, ok := TokenFromContext(ctx)
+	if !ok {
+		return nil, ErrAuthMissingToken
+	}
+	claims, err := ParseJWTClaims(token, j)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthInvalidToken, err)
+	}
+
+	revoked, err := revocations.IsRevoked(ctx, claims.Subject, claims.IssuedAtMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return claims, ErrAuthTokenRevoked
+	}
+
+	active, err := sessions.TouchSession(ctx, claims.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check session: %w", err)
+	}
+	if !active {
+		return claims, ErrAuthSessionRevoked
+	}
+	return claims, nil
+}
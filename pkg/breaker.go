@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断器处于打开状态，请求被直接拒绝
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	// BreakerClosed 关闭：请求正常通过
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 打开：请求直接失败
+	BreakerOpen
+	// BreakerHalfOpen 半开：放行少量探测请求
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig 熔断器配置
+type BreakerConfig struct {
+	// 连续失败多少次后打开
+	FailureThreshold int
+	// 打开后多久进入半开
+	OpenTimeout time.Duration
+	// 半开状态下同时放行的探测请求数
+	HalfOpenMaxRequests int
+}
+
+// DefaultBreakerConfig 默认配置
+var DefaultBreakerConfig = BreakerConfig{
+	FailureThreshold:    5,
+	OpenTimeout:         30 * time.Second,
+	HalfOpenMaxRequests: 1,
+}
+
+// breakerMetrics 熔断器指标，通过 pprof 服务的 /debug/vars 暴露
+var breakerMetrics = expvar.NewMap("circuit_breaker")
+
+// CircuitBreaker 基于连续失败次数的熔断器
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	name     string
+	config   BreakerConfig
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	inFlight int
+	now      func() time.Time
+}
+
+// NewCircuitBreaker 创建熔断器，name 用于区分指标
+func NewCircuitBreaker(name string, config BreakerConfig) *CircuitBreaker {
+	b := &CircuitBreaker{
+		name:   name,
+		config: config,
+		state:  BreakerClosed,
+		now:    time.Now,
+	}
+	b.setStateMetric()
+	return b
+}
+
+// Allow 判断请求是否可以通过，返回 ErrCircuitOpen 表示被拒绝
+// 放行的请求必须调用 Success 或 Failure 报告结果
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if b.now().Sub(b.openedAt) < b.config.OpenTimeout {
+			breakerMetrics.Add(b.name+".rejected", 1)
+			return ErrCircuitOpen
+		}
+		b.transition(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.inFlight >= b.config.HalfOpenMaxRequests {
+			breakerMetrics.Add(b.name+".rejected", 1)
+			return ErrCircuitOpen
+		}
+		b.inFlight++
+	}
+	return nil
+}
+
+// Success 报告一次成功，半开状态下会关闭熔断器
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == BreakerHalfOpen {
+		b.inFlight--
+		b.transition(BreakerClosed)
+	}
+}
+
+// Release 放弃一次请求且不计入结果，如调用方取消了请求，半开状态下归还探测名额
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+}
+
+// Failure 报告一次失败，连续失败达到阈值或半开探测失败时打开熔断器
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch b.state {
+	case BreakerHalfOpen:
+		b.inFlight--
+		b.transition(BreakerOpen)
+	case BreakerClosed:
+		if b.failures >= b.config.FailureThreshold {
+			b.transition(BreakerOpen)
+		}
+	}
+}
+
+// State 返回当前状态
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// transition 切换状态并记录指标，调用方需持有锁
+func (b *CircuitBreaker) transition(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	switch state {
+	case BreakerOpen:
+		b.openedAt = b.now()
+	case BreakerClosed, BreakerHalfOpen:
+		b.inFlight = 0
+	}
+	breakerMetrics.Add(b.name+".transitions."+state.String(), 1)
+	b.setStateMetric()
+}
+
+func (b *CircuitBreaker) setStateMetric() {
+	state := new(expvar.String)
+	state.Set(b.state.String())
+	breakerMetrics.Set(b.name+".state", state)
+}
@@ -0,0 +1,29 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+)
+
+// GenerateCode 生成指定位数的随机数字验证码
+func GenerateCode(digits int) (string, error) {
+	code := make([]byte, digits)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+// HashCode 使用服务端密钥计算验证码的 HMAC，Redis 中只保存哈希值
+func HashCode(code string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
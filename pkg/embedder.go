@@ -0,0 +1,289 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 默认的嵌入模型配置
+const (
+	defaultEmbeddingModel     = "text-embedding-v3"
+	defaultEmbeddingDimension = 1024
+	localEmbeddingModel       = "local-hash"
+)
+
+// Embedder 文本嵌入向量的提供者
+type Embedder interface {
+	// Embed 获取单条文本的嵌入向量
+	Embed(ctx context.Context, text string) ([]float64, error)
+	// EmbedBatch 批量获取嵌入向量，返回顺序与 texts 一致
+	EmbedBatch(ctx context.Context, texts []string) ([][]float64, error)
+	// Model 返回使用的模型名称
+	Model() string
+	// Dimension 返回向量维度
+	Dimension() int
+}
+
+// NewEmbedder 根据配置创建嵌入向量提供者，开启缓存时在外层包一层 CachedEmbedder
+func NewEmbedder(conf *viper.Viper, rdb *redis.Client) (Embedder, error) {
+	embedder, err := newProviderEmbedder(conf)
+	if err != nil {
+		return nil, err
+	}
+	if !conf.GetBool("embedding.cache.enabled") {
+		return embedder, nil
+	}
+
+	config := DefaultEmbeddingCacheConfig
+	if conf.IsSet("embedding.cache.ttl") {
+		config.TTL = conf.GetDuration("embedding.cache.ttl")
+	}
+	if conf.IsSet("embedding.cache.local_size") {
+		config.LocalSize = conf.GetInt("embedding.cache.local_size")
+	}
+	return NewCachedEmbedder(embedder, rdb, config), nil
+}
+
+// newProviderEmbedder 根据 embedding.provider 创建具体的提供者
+func newProviderEmbedder(conf *viper.Viper) (Embedder, error) {
+	model := conf.GetString("embedding.model")
+	if model == "" {
+		model = defaultEmbeddingModel
+	}
+	dimension := conf.GetInt("embedding.dimension")
+	if dimension <= 0 {
+		dimension = defaultEmbeddingDimension
+	}
+	apiKey := conf.GetString("embedding.api_key")
+	if apiKey == "" {
+		// 兼容旧配置
+		apiKey = conf.GetString("security.dashscope_api_key.key")
+	}
+
+	switch provider := conf.GetString("embedding.provider"); provider {
+	case "", "dashscope":
+		e := NewDashScopeEmbedder(apiKey, model, dimension)
+		configureClient(e.Client(), conf)
+		return e, nil
+	case "openai":
+		baseURL := conf.GetString("embedding.base_url")
+		if baseURL == "" {
+			return nil, errors.New("embedding.base_url is required for openai provider")
+		}
+		e := NewOpenAIEmbedder(apiKey, baseURL, model, dimension)
+		configureClient(e.Client(), conf)
+		return e, nil
+	case "local":
+		return NewLocalEmbedder(dimension), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider: %s", provider)
+	}
+}
+
+// configureClient 用配置覆盖客户端的批量、重试和熔断参数
+func configureClient(client *Client, conf *viper.Viper) {
+	batch := DefaultBatchConfig
+	if conf.IsSet("embedding.max_batch_size") {
+		batch.MaxBatchSize = conf.GetInt("embedding.max_batch_size")
+	}
+	if conf.IsSet("embedding.max_batch_tokens") {
+		batch.MaxBatchTokens = conf.GetInt("embedding.max_batch_tokens")
+	}
+	client.SetBatchConfig(batch)
+
+	retry := DefaultRetryConfig
+	if conf.IsSet("embedding.retry.max_attempts") {
+		retry.MaxAttempts = conf.GetInt("embedding.retry.max_attempts")
+	}
+	if conf.IsSet("embedding.retry.base_delay") {
+		retry.BaseDelay = conf.GetDuration("embedding.retry.base_delay")
+	}
+	if conf.IsSet("embedding.retry.max_delay") {
+		retry.MaxDelay = conf.GetDuration("embedding.retry.max_delay")
+	}
+	client.SetRetryConfig(retry)
+
+	breaker := DefaultBreakerConfig
+	if conf.IsSet("embedding.breaker.failure_threshold") {
+		breaker.FailureThreshold = conf.GetInt("embedding.breaker.failure_threshold")
+	}
+	if conf.IsSet("embedding.breaker.open_timeout") {
+		breaker.OpenTimeout = conf.GetDuration("embedding.breaker.open_timeout")
+	}
+	client.SetBreakerConfig(breaker)
+}
+
+// DashScopeEmbedder 基于阿里云 DashScope 的嵌入向量提供者
+type DashScopeEmbedder struct {
+	client    *Client
+	model     string
+	dimension int
+}
+
+// NewDashScopeEmbedder 创建 DashScope 嵌入向量提供者
+func NewDashScopeEmbedder(apiKey string, model string, dimension int) *DashScopeEmbedder {
+	return &DashScopeEmbedder{
+		client:    newClient("embedding.dashscope."+model, apiKey, defaultBaseURL),
+		model:     model,
+		dimension: dimension,
+	}
+}
+
+// Embed 获取单条文本的嵌入向量
+func (e *DashScopeEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	return firstEmbedding(e.EmbedBatch(ctx, []string{text}))
+}
+
+// EmbedBatch 批量获取嵌入向量
+func (e *DashScopeEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	return e.client.DoBatch(ctx, texts, Request{
+		Model:          e.model,
+		Dimension:      strconv.Itoa(e.dimension),
+		EncodingFormat: "float",
+	})
+}
+
+// Client 返回底层的 HTTP 客户端
+func (e *DashScopeEmbedder) Client() *Client {
+	return e.client
+}
+
+func (e *DashScopeEmbedder) Model() string {
+	return e.model
+}
+
+func (e *DashScopeEmbedder) Dimension() int {
+	return e.dimension
+}
+
+// OpenAIEmbedder 兼容 OpenAI Embeddings 协议的嵌入向量提供者，接口地址可配置
+type OpenAIEmbedder struct {
+	client    *Client
+	model     string
+	dimension int
+}
+
+// NewOpenAIEmbedder 创建 OpenAI 兼容的嵌入向量提供者
+func NewOpenAIEmbedder(apiKey string, baseURL string, model string, dimension int) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		client:    newClient("embedding.openai."+model, apiKey, baseURL),
+		model:     model,
+		dimension: dimension,
+	}
+}
+
+// Embed 获取单条文本的嵌入向量
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	return firstEmbedding(e.EmbedBatch(ctx, []string{text}))
+}
+
+// EmbedBatch 批量获取嵌入向量
+func (e *OpenAIEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	return e.client.DoBatch(ctx, texts, Request{
+		Model:          e.model,
+		Dimensions:     e.dimension,
+		EncodingFormat: "float",
+	})
+}
+
+// Client 返回底层的 HTTP 客户端
+func (e *OpenAIEmbedder) Client() *Client {
+	return e.client
+}
+
+func (e *OpenAIEmbedder) Model() string {
+	return e.model
+}
+
+func (e *OpenAIEmbedder) Dimension() int {
+	return e.dimension
+}
+
+// firstEmbedding 取出批量结果中的第一条向量
+func firstEmbedding(embeddings [][]float64, err error) ([]float64, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) == 0 {
+		return nil, errors.New("embedding response has no data")
+	}
+	return embeddings[0], nil
+}
+
+// LocalEmbedder 基于特征哈希的本地嵌入向量提供者
+// 结果只与输入文本有关，不依赖网络，适用于测试和离线开发环境
+type LocalEmbedder struct {
+	dimension int
+}
+
+// NewLocalEmbedder 创建本地嵌入向量提供者
+func NewLocalEmbedder(dimension int) *LocalEmbedder {
+	return &LocalEmbedder{dimension: dimension}
+}
+
+// Embed 将文本的词和字符三元组哈希到固定维度，并做 L2 归一化
+func (e *LocalEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	vec := make([]float64, e.dimension)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		e.add(vec, "w:"+word, 1)
+		// 字符三元组让相近的词也有相近的向量
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			e.add(vec, "g:"+string(runes[i:i+3]), 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vec {
+			vec[i] /= norm
+		}
+	}
+	return vec, nil
+}
+
+// EmbedBatch 批量获取嵌入向量
+func (e *LocalEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	embeddings := make([][]float64, len(texts))
+	for i, text := range texts {
+		embedding, err := e.Embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		embeddings[i] = embedding
+	}
+	return embeddings, nil
+}
+
+// add 把一个特征按哈希值累加到向量上，哈希的最高位决定符号
+func (e *LocalEmbedder) add(vec []float64, feature string, weight float64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(feature))
+	sum := h.Sum64()
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	vec[sum%uint64(e.dimension)] += weight
+}
+
+func (e *LocalEmbedder) Model() string {
+	return localEmbeddingModel
+}
+
+func (e *LocalEmbedder) Dimension() int {
+	return e.dimension
+}
@@ -2,13 +2,18 @@ package pkg
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // 配置参数
@@ -17,30 +22,99 @@ const (
 	defaultTimeout = 30 * time.Second
 )
 
+// BatchConfig 批量请求的拆分限制
+type BatchConfig struct {
+	// 单次请求最多包含的文本条数
+	MaxBatchSize int
+	// 单次请求最多包含的 token 数（估算值）
+	MaxBatchTokens int
+}
+
+// DefaultBatchConfig 默认配置（DashScope text-embedding-v3 单次最多 10 条）
+var DefaultBatchConfig = BatchConfig{
+	MaxBatchSize:   10,
+	MaxBatchTokens: 8192,
+}
+
+// RetryConfig 请求失败时的重试配置
+type RetryConfig struct {
+	// 最多尝试次数（包含第一次）
+	MaxAttempts int
+	// 退避的基础时间，每次重试翻倍
+	BaseDelay time.Duration
+	// 单次退避的最长时间
+	MaxDelay time.Duration
+}
+
+// DefaultRetryConfig 默认配置
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// embeddingMetrics 客户端请求指标，通过 pprof 服务的 /debug/vars 暴露
+var embeddingMetrics = expvar.NewMap("embedding_client")
+
 // Client 是调用 Embedding API 的客户端
 type Client struct {
+	// 熔断器指标的名称，多个客户端之间不能重复
+	name    string
 	apiKey  string
 	baseURL string
 	client  *http.Client
+	batch   BatchConfig
+	retry   RetryConfig
+	breaker *CircuitBreaker
 }
 
-// NewClient 创建一个新的 Embedding 客户端
+// NewClient 创建一个新的 Embedding 客户端（DashScope 兼容模式地址）
 func NewClient(apiKey string) *Client {
+	return NewClientWithBaseURL(apiKey, defaultBaseURL)
+}
+
+// NewClientWithBaseURL 创建一个指定接口地址的 Embedding 客户端（OpenAI 兼容协议）
+func NewClientWithBaseURL(apiKey string, baseURL string) *Client {
+	return newClient("embedding", apiKey, baseURL)
+}
+
+// newClient 创建客户端，name 用于区分熔断器指标，同时存在多个客户端时按提供者和模型命名
+func newClient(name string, apiKey string, baseURL string) *Client {
 	return &Client{
+		name:    name,
 		apiKey:  apiKey,
-		baseURL: defaultBaseURL,
+		baseURL: baseURL,
 		client: &http.Client{
 			Timeout: defaultTimeout,
 		},
+		batch:   DefaultBatchConfig,
+		retry:   DefaultRetryConfig,
+		breaker: NewCircuitBreaker(name, DefaultBreakerConfig),
 	}
 }
 
+// SetBatchConfig 设置批量请求的拆分限制
+func (c *Client) SetBatchConfig(config BatchConfig) {
+	c.batch = config
+}
+
+// SetRetryConfig 设置重试配置
+func (c *Client) SetRetryConfig(config RetryConfig) {
+	c.retry = config
+}
+
+// SetBreakerConfig 设置熔断器配置
+func (c *Client) SetBreakerConfig(config BreakerConfig) {
+	c.breaker = NewCircuitBreaker(c.name, config)
+}
+
 // Request 是发送给 API 的请求结构
 type Request struct {
-	Model          string `json:"model"`
-	Input          string `json:"input"`
-	Dimension      string `json:"dimension"`
-	EncodingFormat string `json:"encoding_format,omitempty"`
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimension      string   `json:"dimension,omitempty"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format,omitempty"`
 }
 
 // Response 是从 API 接收的响应结构
@@ -68,8 +142,8 @@ type Usage struct {
 	TotalTokens  int `json:"total_tokens"`
 }
 
-// GetEmbeddings 获取文本的嵌入向量
-func (c *Client) GetEmbeddings(texts string, model string, dimension string) (*Response, error) {
+// GetEmbeddings 获取文本的嵌入向量（单次请求，不做拆分）
+func (c *Client) GetEmbeddings(ctx context.Context, texts []string, model string, dimension string) (*Response, error) {
 
 	// 构建请求体
 	reqBody := Request{
@@ -79,16 +153,102 @@ func (c *Client) GetEmbeddings(texts string, model string, dimension string) (*R
 		EncodingFormat: "float",
 	}
 
+	return c.Do(ctx, reqBody)
+}
+
+// Do 发送构建好的请求体，对 429/5xx 和网络错误按指数退避重试，并经过熔断器
+func (c *Client) Do(ctx context.Context, reqBody Request) (*Response, error) {
 	// 序列化请求体
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("序列化请求失败: %w", err)
 	}
 
+	attempts := c.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 0; ; attempt++ {
+		if err := c.breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		embeddingMetrics.Add("requests", 1)
+		response, retryAfter, err := c.do(ctx, jsonBody)
+		if err == nil {
+			c.breaker.Success()
+			return response, nil
+		}
+
+		if ctx.Err() != nil {
+			// 调用方取消，服务端没有给出结果，只归还探测名额
+			c.breaker.Release()
+			embeddingMetrics.Add("canceled", 1)
+			return nil, err
+		}
+		var apiErr *APIError
+		retryable := !errors.As(err, &apiErr) || apiErr.Retryable()
+		if !retryable {
+			// 请求本身的问题不代表服务不可用
+			c.breaker.Success()
+			embeddingMetrics.Add("failures", 1)
+			return nil, err
+		}
+		c.breaker.Failure()
+		if attempt+1 >= attempts {
+			embeddingMetrics.Add("failures", 1)
+			return nil, err
+		}
+
+		// 等待退避时间，服务端给出 Retry-After 时以其为准
+		delay := c.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		embeddingMetrics.Add("retries", 1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff 计算带完全抖动的指数退避时间
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.retry.BaseDelay << attempt
+	if delay <= 0 || delay > c.retry.MaxDelay {
+		delay = c.retry.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// APIError 接口返回的非 200 响应
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API 请求失败 (状态码: %d): %s", e.StatusCode, e.Body)
+}
+
+// Retryable 限流和服务端错误可以重试
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// do 发送一次请求，返回响应和服务端要求的重试等待时间
+func (c *Client) do(ctx context.Context, jsonBody []byte) (*Response, time.Duration, error) {
 	// 创建 HTTP 请求
-	req, err := http.NewRequest("POST", c.baseURL, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL, bytes.NewReader(jsonBody))
 	if err != nil {
-		return nil, fmt.Errorf("创建请求失败: %w", err)
+		return nil, 0, fmt.Errorf("创建请求失败: %w", err)
 	}
 
 	// 设置请求头
@@ -98,35 +258,109 @@ func (c *Client) GetEmbeddings(texts string, model string, dimension string) (*R
 	// 发送请求
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("发送请求失败: %w", err)
+		return nil, 0, fmt.Errorf("发送请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// 读取响应内容
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("读取响应失败: %w", err)
+		return nil, 0, fmt.Errorf("读取响应失败: %w", err)
 	}
 
 	// 检查 HTTP 状态码
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API 请求失败 (状态码: %d): %s", resp.StatusCode, string(body))
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	// 解析响应 JSON
 	var response Response
 	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("解析响应失败: %w，响应内容: %s", err, string(body))
+		return nil, 0, fmt.Errorf("解析响应失败: %w，响应内容: %s", err, string(body))
+	}
+
+	return &response, 0, nil
+}
+
+// parseRetryAfter 解析 Retry-After 响应头，支持秒数和 HTTP 日期两种格式
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
 	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// DoBatch 按批量限制拆分 texts 并逐批请求，返回与 texts 顺序一致的向量
+// base 中除 Input 外的字段会用于每一批请求
+func (c *Client) DoBatch(ctx context.Context, texts []string, base Request) ([][]float64, error) {
+	embeddings := make([][]float64, len(texts))
+	for _, r := range splitBatches(texts, c.batch) {
+		reqBody := base
+		reqBody.Input = texts[r[0]:r[1]]
 
-	return &response, nil
+		resp, err := c.Do(ctx, reqBody)
+		if err != nil {
+			return nil, err
+		}
+
+		// 按 Index 回填，接口返回的顺序不一定与输入一致
+		for _, data := range resp.Data {
+			if data.Index < 0 || data.Index >= r[1]-r[0] {
+				return nil, fmt.Errorf("响应中的下标越界: %d", data.Index)
+			}
+			embeddings[r[0]+data.Index] = data.Embedding
+		}
+		for i := r[0]; i < r[1]; i++ {
+			if embeddings[i] == nil {
+				return nil, fmt.Errorf("响应中缺少第 %d 条文本的向量", i)
+			}
+		}
+	}
+	return embeddings, nil
+}
+
+// splitBatches 按条数和 token 数拆分，返回每一批的 [start, end) 区间
+// 单条文本超过 token 限制时单独成批
+func splitBatches(texts []string, config BatchConfig) [][2]int {
+	var (
+		batches [][2]int
+		start   int
+		tokens  int
+	)
+	for i, text := range texts {
+		n := estimateTokens(text)
+		size := i - start
+		if size > 0 && ((config.MaxBatchSize > 0 && size >= config.MaxBatchSize) ||
+			(config.MaxBatchTokens > 0 && tokens+n > config.MaxBatchTokens)) {
+			batches = append(batches, [2]int{start, i})
+			start, tokens = i, 0
+		}
+		tokens += n
+	}
+	if start < len(texts) {
+		batches = append(batches, [2]int{start, len(texts)})
+	}
+	return batches
 }
 
-// ConvertToPGVector 将 Embedding 结构体中的向量数据转换为 PostgreSQL 可接受的向量格式
-func ConvertToPGVector(embedding []float64) string {
-	var strs []string
-	for _, val := range embedding {
-		strs = append(strs, strconv.FormatFloat(val, 'f', -1, 64))
+// estimateTokens 粗略估算 token 数：中日韩字符按 1 个计算，其他字符按 4 字节 1 个计算
+func estimateTokens(text string) int {
+	var tokens, others int
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			tokens++
+		} else {
+			others += utf8.RuneLen(r)
+		}
 	}
-	return "[" + strings.Join(strs, ",") + "]"
+	return tokens + (others+3)/4
 }
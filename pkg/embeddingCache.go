@@ -0,0 +1,247 @@
+package pkg
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embeddingCacheKeyPrefix 缓存 key 前缀
+const embeddingCacheKeyPrefix = "embedding:cache:"
+
+// EmbeddingCacheConfig 嵌入向量缓存配置
+type EmbeddingCacheConfig struct {
+	// Redis 中缓存的过期时间
+	TTL time.Duration
+	// 进程内 LRU 的容量，0 表示不启用
+	LocalSize int
+}
+
+// DefaultEmbeddingCacheConfig 默认配置
+var DefaultEmbeddingCacheConfig = EmbeddingCacheConfig{
+	TTL:       7 * 24 * time.Hour,
+	LocalSize: 1000,
+}
+
+// embeddingCacheMetrics 缓存命中指标，通过 pprof 服务的 /debug/vars 暴露
+var embeddingCacheMetrics = expvar.NewMap("embedding_cache")
+
+// CachedEmbedder 在 Embedder 前加一层按内容寻址的缓存
+// key 由模型、维度和归一化后的文本决定，先查进程内 LRU，再查 Redis
+type CachedEmbedder struct {
+	next   Embedder
+	rdb    *redis.Client
+	config EmbeddingCacheConfig
+	local  *lruCache
+}
+
+// NewCachedEmbedder 创建带缓存的 Embedder，rdb 为 nil 时只使用进程内缓存
+func NewCachedEmbedder(next Embedder, rdb *redis.Client, config EmbeddingCacheConfig) *CachedEmbedder {
+	e := &CachedEmbedder{
+		next:   next,
+		rdb:    rdb,
+		config: config,
+	}
+	if config.LocalSize > 0 {
+		e.local = newLRUCache(config.LocalSize)
+	}
+	return e
+}
+
+// Embed 获取单条文本的嵌入向量
+func (e *CachedEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	return firstEmbedding(e.EmbedBatch(ctx, []string{text}))
+}
+
+// EmbedBatch 批量获取嵌入向量，只对未命中缓存的文本请求下游
+func (e *CachedEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	embeddings := make([][]float64, len(texts))
+	keys := make([]string, len(texts))
+	for i, text := range texts {
+		// 归一化只用于缓存 key，下游收到的是原始文本
+		keys[i] = e.cacheKey(normalizeEmbeddingText(text))
+	}
+
+	// 1.进程内缓存
+	var missing []int
+	for i, key := range keys {
+		if e.local != nil {
+			if embedding, ok := e.local.Get(key); ok {
+				embeddings[i] = embedding
+				embeddingCacheMetrics.Add("local_hits", 1)
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+
+	// 2.Redis 缓存，出错时按未命中处理
+	if len(missing) > 0 && e.rdb != nil {
+		redisKeys := make([]string, len(missing))
+		for j, i := range missing {
+			redisKeys[j] = keys[i]
+		}
+		values, err := e.rdb.MGet(ctx, redisKeys...).Result()
+		if err != nil {
+			embeddingCacheMetrics.Add("redis_errors", 1)
+		} else {
+			var stillMissing []int
+			for j, i := range missing {
+				if value, ok := values[j].(string); ok {
+					if embedding, err := decodeEmbedding(value); err == nil {
+						embeddings[i] = embedding
+						embeddingCacheMetrics.Add("redis_hits", 1)
+						if e.local != nil {
+							e.local.Add(keys[i], embedding)
+						}
+						continue
+					}
+				}
+				stillMissing = append(stillMissing, i)
+			}
+			missing = stillMissing
+		}
+	}
+	if len(missing) == 0 {
+		return embeddings, nil
+	}
+
+	// 3.请求下游，归一化后相同的文本只请求一次，使用第一条原始文本
+	var (
+		uniqueTexts []string
+		uniqueKeys  []string
+		positions   = make(map[string][]int)
+	)
+	for _, i := range missing {
+		if _, ok := positions[keys[i]]; !ok {
+			uniqueTexts = append(uniqueTexts, texts[i])
+			uniqueKeys = append(uniqueKeys, keys[i])
+		}
+		positions[keys[i]] = append(positions[keys[i]], i)
+	}
+	embeddingCacheMetrics.Add("misses", int64(len(uniqueTexts)))
+
+	fetched, err := e.next.EmbedBatch(ctx, uniqueTexts)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipe redis.Pipeliner
+	if e.rdb != nil {
+		pipe = e.rdb.Pipeline()
+	}
+	for j, key := range uniqueKeys {
+		for _, i := range positions[key] {
+			embeddings[i] = fetched[j]
+		}
+		if e.local != nil {
+			e.local.Add(key, fetched[j])
+		}
+		if pipe != nil {
+			pipe.Set(ctx, key, encodeEmbedding(fetched[j]), e.config.TTL)
+		}
+	}
+	if pipe != nil {
+		if _, err := pipe.Exec(ctx); err != nil {
+			embeddingCacheMetrics.Add("redis_errors", 1)
+		}
+	}
+	return embeddings, nil
+}
+
+func (e *CachedEmbedder) Model() string {
+	return e.next.Model()
+}
+
+func (e *CachedEmbedder) Dimension() int {
+	return e.next.Dimension()
+}
+
+// cacheKey 由模型、维度和归一化文本计算缓存 key
+func (e *CachedEmbedder) cacheKey(normalized string) string {
+	sum := sha256.Sum256([]byte(e.next.Model() + "\x00" + strconv.Itoa(e.next.Dimension()) + "\x00" + normalized))
+	return embeddingCacheKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// normalizeEmbeddingText 去掉首尾空白、合并连续空白并转为小写
+func normalizeEmbeddingText(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// encodeEmbedding 将向量编码为小端 float64 字节串
+func encodeEmbedding(embedding []float64) string {
+	buf := make([]byte, 8*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint64(buf[8*i:], math.Float64bits(v))
+	}
+	return string(buf)
+}
+
+// decodeEmbedding 解码 encodeEmbedding 生成的字节串
+func decodeEmbedding(value string) ([]float64, error) {
+	if len(value)%8 != 0 {
+		return nil, errors.New("invalid cached embedding length")
+	}
+	embedding := make([]float64, len(value)/8)
+	for i := range embedding {
+		embedding[i] = math.Float64frombits(binary.LittleEndian.Uint64([]byte(value[8*i : 8*i+8])))
+	}
+	return embedding, nil
+}
+
+// lruCache 并发安全的定长 LRU 缓存
+type lruCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value []float64
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) Get(key string) ([]float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*lruEntry).value, true
+	}
+	return nil, false
+}
+
+func (c *lruCache) Add(key string, value []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*lruEntry).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
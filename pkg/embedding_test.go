@@ -0,0 +1,288 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLocalEmbedder_Embed(t *testing.T) {
+	e := NewLocalEmbedder(64)
+	ctx := context.Background()
+
+	a, err := e.Embed(ctx, "sleep and music")
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	b, _ := e.Embed(ctx, "sleep and music")
+	c, _ := e.Embed(ctx, "football")
+
+	if len(a) != 64 {
+		t.Fatalf("Embed() dimension = %d, want 64", len(a))
+	}
+	// 相同文本的向量必须完全一致
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("Embed() is not deterministic at %d: %v != %v", i, a[i], b[i])
+		}
+	}
+	// 向量已归一化
+	var norm float64
+	for _, v := range a {
+		norm += v * v
+	}
+	if math.Abs(norm-1) > 1e-9 {
+		t.Errorf("Embed() norm = %v, want 1", norm)
+	}
+	// 不同文本的向量不同
+	same := true
+	for i := range a {
+		if a[i] != c[i] {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Errorf("Embed() returned the same vector for different texts")
+	}
+}
+
+func TestOpenAIEmbedder_Embed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("unexpected Authorization header: %s", r.Header.Get("Authorization"))
+		}
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		if req.Model != "m" || req.Dimensions != 3 || len(req.Input) != 1 || req.Input[0] != "hello" {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		_ = json.NewEncoder(w).Encode(Response{Data: []Embedding{{Index: 0, Embedding: []float64{1, 2, 3}}}})
+	}))
+	defer server.Close()
+
+	e := NewOpenAIEmbedder("test-key", server.URL, "m", 3)
+	got, err := e.Embed(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("Embed() = %v", got)
+	}
+}
+
+func TestClient_DoBatch(t *testing.T) {
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		sizes = append(sizes, len(req.Input))
+		// 倒序返回，验证按 Index 回填
+		var resp Response
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			resp.Data = append(resp.Data, Embedding{Index: i, Embedding: []float64{float64(len(req.Input[i]))}})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	client := NewClientWithBaseURL("test-key", server.URL)
+	client.SetBatchConfig(BatchConfig{MaxBatchSize: 3, MaxBatchTokens: 4})
+
+	// 每条 token 数分别为 1,1,1,1,3,1,1，先按条数拆分，再按 token 数拆分
+	texts := []string{"a", "bb", "ccc", "dddd", "eeeeeeeeeee", "f", "g"}
+	got, err := client.DoBatch(context.Background(), texts, Request{Model: "m"})
+	if err != nil {
+		t.Fatalf("DoBatch() error = %v", err)
+	}
+	for i, text := range texts {
+		if len(got[i]) != 1 || got[i][0] != float64(len(text)) {
+			t.Errorf("DoBatch()[%d] = %v, want [%d]", i, got[i], len(text))
+		}
+	}
+	want := []int{3, 2, 2}
+	if len(sizes) != len(want) {
+		t.Fatalf("batch sizes = %v, want %v", sizes, want)
+	}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Fatalf("batch sizes = %v, want %v", sizes, want)
+		}
+	}
+}
+
+func TestClient_DoRetry(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			_ = json.NewEncoder(w).Encode(Response{Data: []Embedding{{Embedding: []float64{1}}}})
+		}
+	}))
+	defer server.Close()
+
+	client := NewClientWithBaseURL("test-key", server.URL)
+	client.SetRetryConfig(RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+
+	if _, err := client.Do(context.Background(), Request{Input: []string{"a"}}); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Do() calls = %d, want 3", calls)
+	}
+
+	// 4xx 不重试
+	calls = 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	_, err := client.Do(context.Background(), Request{Input: []string{"a"}})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Do() error = %v, want APIError 400", err)
+	}
+	if calls != 1 {
+		t.Errorf("Do() calls = %d, want 1", calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker("test", BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Second, HalfOpenMaxRequests: 1})
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Allow() error = %v", err)
+		}
+		b.Failure()
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("State() = %v, want open", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() error = %v, want ErrCircuitOpen", err)
+	}
+
+	// 超时后进入半开，只放行一个探测请求
+	now = now.Add(time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() error = %v, want ErrCircuitOpen", err)
+	}
+	// 放弃的探测请求不改变状态，只归还名额
+	b.Release()
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("State() after Release = %v, want half-open", b.State())
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after Release error = %v", err)
+	}
+	b.Success()
+	if b.State() != BreakerClosed {
+		t.Fatalf("State() = %v, want closed", b.State())
+	}
+}
+
+func TestClient_DoCanceledInHalfOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	now := time.Now()
+	client := newClient("embedding.test", "test-key", server.URL)
+	client.SetBreakerConfig(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenMaxRequests: 1})
+	client.breaker.now = func() time.Time { return now }
+	_ = client.breaker.Allow()
+	client.breaker.Failure()
+	now = now.Add(time.Second)
+
+	// 探测请求被调用方取消，熔断器保持半开，且可以再次探测
+	if _, err := client.Do(ctx, Request{Input: []string{"a"}}); err == nil {
+		t.Fatal("Do() error = nil, want canceled")
+	}
+	if state := client.breaker.State(); state != BreakerHalfOpen {
+		t.Errorf("State() = %v, want half-open", state)
+	}
+	if err := client.breaker.Allow(); err != nil {
+		t.Errorf("Allow() error = %v, want probe slot released", err)
+	}
+}
+
+func TestEmbedder_BreakerName(t *testing.T) {
+	a := NewDashScopeEmbedder("key", "text-embedding-v3", 1024)
+	b := NewOpenAIEmbedder("key", "http://localhost", "text-embedding-3-small", 1536)
+	if a.Client().name == b.Client().name {
+		t.Errorf("breaker names collide: %q", a.Client().name)
+	}
+}
+
+// countingEmbedder 记录下游实际请求的文本
+type countingEmbedder struct {
+	*LocalEmbedder
+	requested []string
+}
+
+func (e *countingEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	e.requested = append(e.requested, texts...)
+	return e.LocalEmbedder.EmbedBatch(ctx, texts)
+}
+
+func TestCachedEmbedder_EmbedBatch(t *testing.T) {
+	next := &countingEmbedder{LocalEmbedder: NewLocalEmbedder(8)}
+	e := NewCachedEmbedder(next, nil, EmbeddingCacheConfig{LocalSize: 10})
+	ctx := context.Background()
+
+	first, err := e.EmbedBatch(ctx, []string{"Sleep Well", " sleep  well ", "music"})
+	if err != nil {
+		t.Fatalf("EmbedBatch() error = %v", err)
+	}
+	// 归一化后相同的文本只请求一次，下游收到的是第一条原始文本
+	if len(next.requested) != 2 || next.requested[0] != "Sleep Well" || next.requested[1] != "music" {
+		t.Fatalf("requested = %q, want [Sleep Well music]", next.requested)
+	}
+	for i := range first[0] {
+		if first[0][i] != first[1][i] {
+			t.Fatalf("EmbedBatch() returned different vectors for the same normalized text")
+		}
+	}
+
+	second, err := e.EmbedBatch(ctx, []string{"music", "SLEEP WELL"})
+	if err != nil {
+		t.Fatalf("EmbedBatch() error = %v", err)
+	}
+	if len(next.requested) != 2 {
+		t.Errorf("requested = %v, want cache hits only", next.requested)
+	}
+	for i := range second[0] {
+		if second[0][i] != first[2][i] {
+			t.Fatalf("EmbedBatch() cached vector mismatch")
+		}
+	}
+}
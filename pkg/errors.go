@@ -3,6 +3,7 @@ package pkg
 const (
 	ErrInternalServerError = "系统异常，请稍后再试"
 	ErrServiceBusy         = "服务器繁忙，请稍后再试"
+	ErrTooManyRequests     = "请求过于频繁，请稍后再试"
 )
 
 const (
@@ -10,6 +11,33 @@ const (
 	ErrAccountAlreadyUse = "该账号已被使用"
 	ErrPassword          = "密码错误"
 	ErrUnauthorized      = "用户未登录"
+	ErrEmbeddingNotReady = "兴趣向量计算中，请稍后再试"
+	ErrInvalidPageToken  = "分页参数错误"
+	ErrInvalidInterest   = "兴趣标签不能为空且不能超过100个字符"
+	ErrInterestExists    = "该兴趣已存在"
+	ErrInterestNotFound  = "兴趣不存在"
+	ErrTooManyInterests  = "兴趣数量已达上限"
+	ErrUserDisabled      = "账号已被禁用"
+	ErrTokenRevoked      = "登录已失效，请重新登录"
+	ErrSessionNotFound   = "会话不存在"
+	ErrInvalidEmail      = "邮箱格式错误"
+	ErrInvalidPhone      = "手机号格式错误"
+	ErrInvalidChannel    = "不支持的验证方式"
+	ErrContactNotSet     = "未设置该联系方式"
+	ErrCodeTooFrequent   = "验证码发送过于频繁，请稍后再试"
+	ErrInvalidCode       = "验证码错误或已过期"
+	ErrTooManyAttempts   = "验证码错误次数过多，请重新获取"
+	ErrInvalidPassword   = "密码不能为空"
+	ErrTOTPEnabled       = "已开启两步验证"
+	ErrTOTPNotEnrolled   = "请先绑定身份验证器"
+	ErrInvalidTOTP       = "动态口令错误"
+	ErrChallengeExpired  = "登录验证已失效，请重新登录"
+	ErrTOTPLocked        = "两步验证失败次数过多，请稍后再试"
+)
+
+const (
+	ErrPermissionDenied = "无权限访问"
+	ErrInvalidUserID    = "用户ID不能为空"
 )
 
 const (
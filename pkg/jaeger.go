@@ -68,3 +68,15 @@ func (w metadataReaderWriter) ForeachKey(handler func(key, value string) error)
 	}
 	return nil
 }
+
+// TraceIDFromContext 返回 ctx 中当前 span 的 trace ID，未开启追踪时返回空字符串
+func TraceIDFromContext(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return sc.TraceID().String()
+	}
+	return ""
+}
@@ -1,10 +1,12 @@
 package pkg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/spf13/viper"
+	"google.golang.org/grpc/metadata"
 	"time"
 )
 
@@ -20,14 +22,31 @@ func NewJwt(conf *viper.Viper) *JWT {
 	}
 }
 
+// TokenExpiration 令牌有效期
+const TokenExpiration = 24 * time.Hour
+
+// Claims 令牌声明，令牌与登录会话绑定，吊销会话后令牌失效
+type Claims struct {
+	jwt.StandardClaims
+	SessionID string `json:"sid,omitempty"`
+	// 签发时间（毫秒），iat 只精确到秒，无法区分同一秒内先后发生的吊销和签发
+	IssuedAtMs int64 `json:"iat_ms"`
+}
+
 // GenerateJWT 生成JWT令牌
-func GenerateJWT(userID string, j JWT) (string, int64, error) {
-	expiresAt := time.Now().Add(time.Hour * 24).Unix()
+func GenerateJWT(userID string, sessionID string, j JWT) (string, int64, error) {
+	now := time.Now()
+	expiresAt := now.Add(TokenExpiration).Unix()
 
-	claims := &jwt.StandardClaims{
-		Subject:   userID,
-		ExpiresAt: expiresAt,
-		Issuer:    j.JwtIssuer,
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   userID,
+			ExpiresAt: expiresAt,
+			IssuedAt:  now.Unix(),
+			Issuer:    j.JwtIssuer,
+		},
+		SessionID:  sessionID,
+		IssuedAtMs: now.UnixMilli(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -39,10 +58,19 @@ func GenerateJWT(userID string, j JWT) (string, int64, error) {
 	return tokenString, expiresAt - time.Now().Unix(), nil
 }
 
-// ParseJWT 解析并验证JWT令牌
+// ParseJWT 解析并验证JWT令牌，返回用户ID
 func ParseJWT(tokenString string, j JWT) (string, error) {
+	claims, err := ParseJWTClaims(tokenString, j)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// ParseJWTClaims 解析并验证JWT令牌，返回全部声明
+func ParseJWTClaims(tokenString string, j JWT) (*Claims, error) {
 	// 解析并验证令牌
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// 验证签名方法
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -51,13 +79,26 @@ func ParseJWT(tokenString string, j JWT) (string, error) {
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// 类型断言获取声明
-	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
-		return claims.Subject, nil // 返回用户ID
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		return claims, nil
 	}
 
-	return "", errors.New("invalid token")
+	return nil, errors.New("invalid token")
+}
+
+// TokenFromContext 从 gRPC metadata 中获取 token
+func TokenFromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	tokens := md.Get("token")
+	if len(tokens) == 0 {
+		return "", false
+	}
+	return tokens[0], true
 }
@@ -0,0 +1,205 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// LeaderConfig 选主配置
+type LeaderConfig struct {
+	// 竞选的锁 key，同一组副本使用相同的 key
+	Key string
+	// 领导权的租约时长，leader 进程异常退出后最多经过该时长由其他副本接替
+	TTL time.Duration
+	// 单次等待锁的时长，超时后重新竞选
+	CampaignWait time.Duration
+	// 竞选出错后的重试间隔
+	RetryInterval time.Duration
+}
+
+// DefaultLeaderConfig 默认配置
+var DefaultLeaderConfig = LeaderConfig{
+	Key:           "leader",
+	TTL:           10 * time.Second,
+	CampaignWait:  30 * time.Second,
+	RetryInterval: time.Second,
+}
+
+// LeaderElector 在多个副本之间选出一个 leader，只由 leader 运行的后台任务通过 OnElected 注册
+// 基于 RedisLock 竞选，领导权随锁自动续期，续期失败即失去领导权
+type LeaderElector struct {
+	rdb    *redis.Client
+	logger *zap.Logger
+	config LeaderConfig
+
+	leader atomic.Bool
+
+	mu        sync.Mutex
+	onElected []func(ctx context.Context)
+	onRevoked []func()
+	// term 当前任期的 ctx，不是 leader 时为 nil
+	term context.Context
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewLeaderElector(rdb *redis.Client, logger *zap.Logger, conf *viper.Viper) *LeaderElector {
+	config := DefaultLeaderConfig
+	if conf.IsSet("leader.key") {
+		config.Key = conf.GetString("leader.key")
+	}
+	if conf.IsSet("leader.ttl") {
+		config.TTL = conf.GetDuration("leader.ttl")
+	}
+	if conf.IsSet("leader.campaign_wait") {
+		config.CampaignWait = conf.GetDuration("leader.campaign_wait")
+	}
+	if conf.IsSet("leader.retry_interval") {
+		config.RetryInterval = conf.GetDuration("leader.retry_interval")
+	}
+
+	return &LeaderElector{
+		rdb:    rdb,
+		logger: logger,
+		config: config,
+	}
+}
+
+// StartLeaderElector 随应用启动竞选，停止时主动让出领导权
+func StartLeaderElector(lc fx.Lifecycle, e *LeaderElector) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			e.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			e.logger.Info("resigning leadership")
+			return e.Stop(ctx)
+		},
+	})
+}
+
+// IsLeader 当前副本是否为 leader
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// OnElected 注册成为 leader 时的回调，ctx 在失去领导权或让出时取消
+// 回调在竞选协程中依次同步调用，长期运行的任务应自行启动协程并监听 ctx
+// 注册时已是 leader 则在调用方协程中立即以当前任期的 ctx 调用一次
+func (e *LeaderElector) OnElected(fn func(ctx context.Context)) {
+	e.mu.Lock()
+	e.onElected = append(e.onElected, fn)
+	term := e.term
+	e.mu.Unlock()
+	if term != nil && term.Err() == nil {
+		fn(term)
+	}
+}
+
+// OnRevoked 注册失去领导权或让出时的回调
+func (e *LeaderElector) OnRevoked(fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onRevoked = append(e.onRevoked, fn)
+}
+
+// Start 在后台开始竞选
+func (e *LeaderElector) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	e.logger.Info("starting leader election", zap.String("key", e.config.Key))
+	go e.run(ctx)
+}
+
+// Stop 停止竞选，当前为 leader 时释放锁，让其他副本立即接替
+func (e *LeaderElector) Stop(ctx context.Context) error {
+	if e.cancel == nil {
+		return nil
+	}
+	e.cancel()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *LeaderElector) run(ctx context.Context) {
+	defer close(e.done)
+
+	lockConfig := DefaultLockConfig
+	lockConfig.DefaultExpiration = e.config.TTL
+	lockConfig.DefaultWaitTime = e.config.CampaignWait
+	lockConfig.MetricName = "leader"
+	for ctx.Err() == nil {
+		// 每个任期使用新的锁实例，锁丢失后重新竞选
+		lock := NewRedisLock(e.rdb, e.logger, e.config.Key, lockConfig)
+		_, acquired, err := lock.WaitLock(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				e.logger.Warn("failed to campaign for leadership", zap.Error(err))
+				e.sleep(ctx, e.config.RetryInterval)
+			}
+			continue
+		}
+		if acquired {
+			e.lead(ctx, lock)
+		}
+	}
+}
+
+// lead 持有领导权直到锁丢失或停止竞选
+func (e *LeaderElector) lead(ctx context.Context, lock *RedisLock) {
+	termCtx, cancel := lock.Context(ctx)
+	defer cancel()
+
+	e.leader.Store(true)
+	e.logger.Info("became leader", zap.String("key", e.config.Key))
+	e.mu.Lock()
+	e.term = termCtx
+	onElected := append([]func(ctx context.Context){}, e.onElected...)
+	e.mu.Unlock()
+	for _, fn := range onElected {
+		fn(termCtx)
+	}
+
+	<-termCtx.Done()
+	e.leader.Store(false)
+	if errors.Is(context.Cause(termCtx), ErrLockLost) {
+		e.logger.Error("leadership lost", zap.String("key", e.config.Key))
+	} else {
+		e.logger.Info("leadership resigned", zap.String("key", e.config.Key))
+	}
+	e.mu.Lock()
+	e.term = nil
+	onRevoked := append([]func(){}, e.onRevoked...)
+	e.mu.Unlock()
+	for _, fn := range onRevoked {
+		fn()
+	}
+
+	releaseCtx, releaseCancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer releaseCancel()
+	if err := lock.Unlock(releaseCtx); err != nil && !errors.Is(err, ErrLockLost) {
+		e.logger.Warn("failed to release leadership", zap.Error(err))
+	}
+}
+
+func (e *LeaderElector) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func newTestLeaderElector(t *testing.T, rdb *redis.Client) *LeaderElector {
+	t.Helper()
+	conf := viper.New()
+	conf.Set("leader.ttl", 300*time.Millisecond)
+	conf.Set("leader.campaign_wait", time.Second)
+	conf.Set("leader.retry_interval", 10*time.Millisecond)
+	e := NewLeaderElector(rdb, zap.NewNop(), conf)
+	t.Cleanup(func() { _ = e.Stop(context.Background()) })
+	return e
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLeaderElector_Failover(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	a := newTestLeaderElector(t, rdb)
+	b := newTestLeaderElector(t, rdb)
+
+	elected := make(chan context.Context, 1)
+	revoked := make(chan struct{}, 1)
+	a.OnElected(func(ctx context.Context) { elected <- ctx })
+	a.OnRevoked(func() { revoked <- struct{}{} })
+
+	a.Start()
+	waitFor(t, "a to become leader", a.IsLeader)
+	termCtx := <-elected
+	b.Start()
+	time.Sleep(50 * time.Millisecond)
+	if b.IsLeader() {
+		t.Fatal("two leaders at the same time")
+	}
+
+	// 让出后回调 ctx 取消，另一个副本接替
+	if err := a.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if a.IsLeader() {
+		t.Error("IsLeader() = true after Stop")
+	}
+	select {
+	case <-revoked:
+	default:
+		t.Error("OnRevoked not called on resign")
+	}
+	if termCtx.Err() == nil {
+		t.Error("OnElected ctx not cancelled on resign")
+	}
+	waitFor(t, "b to take over", b.IsLeader)
+}
+
+func TestLeaderElector_LostLeadership(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	e := newTestLeaderElector(t, rdb)
+	revoked := make(chan struct{}, 1)
+	e.OnRevoked(func() { revoked <- struct{}{} })
+
+	e.Start()
+	waitFor(t, "leader elected", e.IsLeader)
+	// 锁被他人抢占，续期失败后失去领导权
+	mr.Set("lock:leader", "other")
+	select {
+	case <-revoked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnRevoked not called after lock lost")
+	}
+	if e.IsLeader() {
+		t.Error("IsLeader() = true after lock lost")
+	}
+
+	mr.Del("lock:leader")
+	waitFor(t, "re-elected", e.IsLeader)
+}
+
+func TestLeaderElector_OnElectedWhileLeader(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	e := newTestLeaderElector(t, rdb)
+	e.Start()
+	waitFor(t, "leader elected", e.IsLeader)
+	waitFor(t, "term started", func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.term != nil
+	})
+
+	// 已是 leader 时注册的回调立即以当前任期的 ctx 调用
+	var termCtx context.Context
+	e.OnElected(func(ctx context.Context) { termCtx = ctx })
+	if termCtx == nil {
+		t.Fatal("OnElected callback not called while already leader")
+	}
+	if termCtx.Err() != nil {
+		t.Fatalf("term ctx already done: %v", termCtx.Err())
+	}
+
+	if err := e.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if termCtx.Err() == nil {
+		t.Error("term ctx not cancelled on resign")
+	}
+}
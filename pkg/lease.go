@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lease 锁的自动续期，获取成功后启动，释放时停止，各类锁共用
+type lease struct {
+	cancel context.CancelFunc
+	// 续期协程退出后关闭
+	done chan struct{}
+	// 续期发现锁已丢失时关闭
+	lost chan struct{}
+}
+
+// startLease 每隔 ttl 的 1/3 调用一次 renew
+// renew 返回 ErrLockLost，或连续失败到上次续期的剩余有效期不足 lostMargin 时，都视为锁丢失并关闭 lost
+// 这样持有者在 key 真正过期、他人可以获取之前就能停止写入
+// 续期不随调用方 ctx 结束，持有者必须调用 stop
+func startLease(ctx context.Context, logger *zap.Logger, key string, ttl time.Duration, renew func(ctx context.Context) error) *lease {
+	renewCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	l := &lease{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	go func() {
+		defer close(l.done)
+		l.run(renewCtx, logger, key, ttl, renew)
+	}()
+	return l
+}
+
+// lostMargin 续期持续失败时，上次续期的剩余有效期低于该值即视为锁丢失，等于一个续期间隔
+func lostMargin(ttl time.Duration) time.Duration {
+	return ttl / 3
+}
+
+func (l *lease) run(ctx context.Context, logger *zap.Logger, key string, ttl time.Duration, renew func(ctx context.Context) error) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	// 以发起续期的时间计算有效期，续期请求本身的耗时不计入剩余有效期
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			err := renew(ctx)
+			if err == nil {
+				renewedAt = start
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, ErrLockLost) || ttl-time.Since(renewedAt) <= lostMargin(ttl) {
+				logger.Error("lock lost", zap.String("key", key), zap.Error(err))
+				close(l.lost)
+				return
+			}
+			// 暂时性错误，锁尚未过期，下次继续续期
+			logger.Warn("failed to renew lock", zap.String("key", key), zap.Error(err))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop 停止续期，并等待续期协程退出，避免释放后再续期
+func (l *lease) stop() {
+	l.cancel()
+	<-l.done
+}
+
+// lostContext 返回在 lost 关闭时取消的 ctx，context.Cause 为 ErrLockLost
+// lost 为 nil 表示未持有锁，返回的 ctx 已取消
+func lostContext(parent context.Context, lost <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	if lost == nil {
+		cancel(ErrLockLost)
+		return ctx, func() {}
+	}
+	go func() {
+		select {
+		case <-lost:
+			cancel(ErrLockLost)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
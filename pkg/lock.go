@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
+	"sync"
 	"time"
 )
 
@@ -22,6 +23,11 @@ type LockConfig struct {
 	MaxRetries int
 	// 重试间隔
 	RetryInterval time.Duration
+	// 指标名，同一类锁使用固定的名称，不能包含用户输入，为空时统计到 default
+	MetricName string
+	// 栅栏令牌计数器的过期时间，每次获取锁时刷新，0 表示不过期
+	// 锁名来自用户输入时必须设置，过期后令牌从 1 重新开始，应远大于持有者可能停顿的最长时间
+	FenceTTL time.Duration
 }
 
 // DefaultLockConfig 默认配置
@@ -33,6 +39,82 @@ var DefaultLockConfig = LockConfig{
 	RetryInterval:     100 * time.Millisecond,
 }
 
+// ErrLockLost 解锁或续期时锁已过期或已被其他持有者获取
+var ErrLockLost = errors.New("lock expired or held by another owner")
+
+// 锁的附属 key 后缀
+const (
+	// fenceKeySuffix 栅栏令牌计数器，默认不过期，保证令牌单调递增；设置 FenceTTL 时在最后一次获取后过期
+	fenceKeySuffix = ":fence"
+	// queueKeySuffix 等待队列（list），队首的等待者优先获取
+	queueKeySuffix = ":queue"
+	// waitersKeySuffix 等待者的存活期限（zset），超过期限未刷新的等待者视为已退出
+	waitersKeySuffix = ":waiters"
+	// releasedChannelSuffix 释放锁时发布消息的频道
+	releasedChannelSuffix = ":released"
+)
+
+// acquireScript 获取锁成功时在同一脚本内递增栅栏令牌，未获取时返回 0
+// 等待队列非空时只有队首可以获取；ARGV[5] 为 1 时未获取的调用方加入队尾并刷新存活期限
+// ARGV[6] 大于 0 时为栅栏令牌计数器设置过期时间（毫秒）
+var acquireScript = redis.NewScript(`
+	local now = tonumber(ARGV[3])
+	-- 清理已退出的队首等待者
+	while true do
+		local head = redis.call("LINDEX", KEYS[3], 0)
+		if not head then
+			break
+		end
+		local deadline = redis.call("ZSCORE", KEYS[4], head)
+		if deadline and tonumber(deadline) > now then
+			break
+		end
+		redis.call("LPOP", KEYS[3])
+		redis.call("ZREM", KEYS[4], head)
+	end
+
+	local head = redis.call("LINDEX", KEYS[3], 0)
+	if (not head or head == ARGV[1]) and redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+		if head then
+			redis.call("LPOP", KEYS[3])
+			redis.call("ZREM", KEYS[4], ARGV[1])
+		end
+		local token = redis.call("INCR", KEYS[2])
+		if tonumber(ARGV[6]) > 0 then
+			redis.call("PEXPIRE", KEYS[2], ARGV[6])
+		end
+		return token
+	end
+
+	if ARGV[5] == "1" then
+		if not redis.call("ZSCORE", KEYS[4], ARGV[1]) then
+			redis.call("RPUSH", KEYS[3], ARGV[1])
+		end
+		redis.call("ZADD", KEYS[4], now + tonumber(ARGV[4]), ARGV[1])
+		redis.call("PEXPIRE", KEYS[3], ARGV[4])
+		redis.call("PEXPIRE", KEYS[4], ARGV[4])
+	end
+	return 0
+`)
+
+// renewScript 仅当锁仍由自己持有时续期
+var renewScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	else
+		return 0
+	end
+`)
+
+// unlockScript 仅当锁仍由自己持有时删除
+var unlockScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	else
+		return 0
+	end
+`)
+
 // RedisLock 分布式锁结构体
 type RedisLock struct {
 	rdb        *redis.Client
@@ -41,8 +123,16 @@ type RedisLock struct {
 	value      string
 	expiration time.Duration
 	config     LockConfig
-	renewCh    chan struct{}
-	stopRenew  chan struct{}
+
+	mu sync.Mutex
+	// 是否持有锁，只在获取成功后为 true
+	held bool
+	// 自动续期，每次获取成功时重新创建
+	lease *lease
+	// 本次持有的栅栏令牌
+	token int64
+	// 本次持有的指标与追踪
+	trace *lockTrace
 }
 
 // NewRedisLock 创建一个新的分布式锁实例
@@ -54,123 +144,149 @@ func NewRedisLock(rdb *redis.Client, logger *zap.Logger, key string, config Lock
 		value:      fmt.Sprintf("%d-%s", time.Now().UnixNano(), uuid.New().String()),
 		expiration: config.DefaultExpiration,
 		config:     config,
-		// 需要续期时传入信号
-		renewCh: make(chan struct{}),
-		// 停止续期时传入信号
-		stopRenew: make(chan struct{}),
 	}
 }
 
-// Lock 获取锁
-func (l *RedisLock) Lock(ctx context.Context) (bool, error) {
-	startTime := time.Now()
-	// 启动自动续期
-	go l.autoRenew(ctx)
-
-	// 尝试获取锁
-	for i := 0; i < l.config.MaxRetries; i++ {
-		// 使用SETNX命令尝试获取锁
-		set, err := l.rdb.SetNX(ctx, l.key, l.value, l.expiration).Result()
-		if err != nil {
-			if errors.Is(err, redis.Nil) {
-				// 键不存在，继续重试
-				time.Sleep(l.config.RetryInterval)
-				continue
+// Lock 轮询获取锁，最多尝试 MaxRetries 次且不超过 DefaultWaitTime，成功后才启动自动续期
+// 成功时返回单调递增的栅栏令牌，写入受保护的资源时携带该令牌，见 repository.FencingRepository
+// 等待期间 ctx 结束时返回 ctx.Err()，需要按顺序阻塞等待时使用 WaitLock
+func (l *RedisLock) Lock(ctx context.Context) (int64, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held {
+		return 0, false, fmt.Errorf("lock %s already acquired", l.key)
+	}
+
+	trace := startLockTrace(ctx, "RedisLock", "Lock", l.key, l.config.MetricName)
+	var token int64
+	acquired, err := pollAcquire(ctx, l.config, func() (bool, error) {
+		var err error
+		token, err = l.tryAcquire(ctx, false)
+		return token > 0, err
+	})
+	trace.acquireDone(ctx, acquired, err)
+	if acquired {
+		l.trace = trace
+	}
+	return token, acquired, err
+}
+
+// pollAcquire 轮询调用 try 直到获取成功，最多尝试 MaxRetries 次且不超过 DefaultWaitTime
+// 等待期间 ctx 结束时返回 ctx.Err()
+func pollAcquire(ctx context.Context, config LockConfig, try func() (bool, error)) (bool, error) {
+	deadline := time.NewTimer(config.DefaultWaitTime)
+	defer deadline.Stop()
+	for i := 0; i < config.MaxRetries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(config.RetryInterval):
+			case <-deadline.C:
+				return false, nil
+			case <-ctx.Done():
+				return false, ctx.Err()
 			}
-			return false, fmt.Errorf("failed to acquire lock: %w", err)
 		}
 
-		if set {
-			return true, nil
+		acquired, err := try()
+		if err != nil || acquired {
+			return acquired, err
 		}
+	}
+	return false, nil
+}
 
-		// 检查是否超过等待时间
-		if time.Since(startTime) > l.config.DefaultWaitTime {
-			return false, nil
-		}
+// tryAcquire 尝试获取一次锁，成功时记录持有状态并启动续期，调用方需持有 l.mu
+func (l *RedisLock) tryAcquire(ctx context.Context, enqueue bool) (int64, error) {
+	flag := "0"
+	if enqueue {
+		flag = "1"
+	}
+	// 使用 SET NX PX 尝试获取锁，成功时同时递增栅栏令牌
+	token, err := acquireScript.Run(ctx, l.rdb,
+		[]string{l.key, l.key + fenceKeySuffix, l.key + queueKeySuffix, l.key + waitersKeySuffix},
+		l.value, l.expiration.Milliseconds(), time.Now().UnixMilli(), lockWaiterTTL.Milliseconds(), flag, l.config.FenceTTL.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if token > 0 {
+		l.held = true
+		l.token = token
+		l.lease = startLease(ctx, l.logger, l.key, l.expiration, l.renew)
+	}
+	return token, nil
+}
 
-		time.Sleep(l.config.RetryInterval)
+// Token 返回当前持有的栅栏令牌，未持有锁时返回 0
+func (l *RedisLock) Token() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		return 0
 	}
+	return l.token
+}
 
-	return false, nil
+// Lost 返回锁丢失时关闭的 channel，临界区应在其关闭后中止
+// 未获取锁时返回 nil，Unlock 不会关闭该 channel
+func (l *RedisLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease == nil {
+		return nil
+	}
+	return l.lease.lost
 }
 
-// autoRenew 自动续期
-func (l *RedisLock) autoRenew(ctx context.Context) {
-	ticker := time.NewTicker(l.expiration / 3)
-	defer ticker.Stop()
-
-	for {
-		select {
-		// 自动续期
-		case <-ticker.C:
-			// 续期逻辑
-			if err := l.renew(ctx); err != nil {
-				// 续期失败，停止续期,并记录日志
-				l.logger.Error("failed to renew lock", zap.String("key", l.key), zap.String("value", l.value), zap.Error(err))
-				return
-			}
-		// 手动续期
-		case <-l.renewCh:
-			// 续期逻辑
-			if err := l.renew(ctx); err != nil {
-				// 续期失败，停止续期,并记录日志
-				l.logger.Error("failed to renew lock", zap.String("key", l.key), zap.String("value", l.value), zap.Error(err))
-				return
-			}
-		case <-ctx.Done():
-			return
-		case <-l.stopRenew:
-			return
-		}
+// Context 返回在锁丢失时取消的 ctx，context.Cause 为 ErrLockLost
+// 未持有锁时返回的 ctx 已取消，使用完毕后需调用 cancel
+func (l *RedisLock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	l.mu.Lock()
+	var lost <-chan struct{}
+	if l.held {
+		lost = l.lease.lost
 	}
+	l.mu.Unlock()
+	return lostContext(parent, lost)
 }
 
 // renew 续期
 func (l *RedisLock) renew(ctx context.Context) error {
-	// 使用Lua脚本确保原子性
-	luaScript := `
-        if redis.call("GET", KEYS[1]) == ARGV[1] then
-            return redis.call("EXPIRE", KEYS[1], ARGV[2])
-        else
-            return 0
-        end
-    `
-
-	result, err := l.rdb.Eval(ctx, luaScript, []string{l.key}, l.value, l.expiration.Seconds()).Result()
+	result, err := renewScript.Run(ctx, l.rdb, []string{l.key}, l.value, l.expiration.Milliseconds()).Int64()
 	if err != nil {
 		return fmt.Errorf("failed to renew lock: %w", err)
 	}
-
-	if result.(int64) == 0 {
-		return fmt.Errorf("lock not found or value mismatch")
+	if result == 0 {
+		return ErrLockLost
 	}
-
 	return nil
 }
 
-// Unlock 释放锁
+// Unlock 释放锁，可重复调用，未持有锁时直接返回
+// 锁在释放前已过期或被他人获取时返回 ErrLockLost
 func (l *RedisLock) Unlock(ctx context.Context) error {
-	// 停止续期
-	close(l.stopRenew)
-
-	// 使用Lua脚本确保原子性
-	luaScript := `
-        if redis.call("GET", KEYS[1]) == ARGV[1] then
-            return redis.call("DEL", KEYS[1])
-        else
-            return 0
-        end
-    `
-
-	result, err := l.rdb.Eval(ctx, luaScript, []string{l.key}, l.value).Result()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		return nil
+	}
+	l.held = false
+
+	l.lease.stop()
+
+	result, err := unlockScript.Run(ctx, l.rdb, []string{l.key}, l.value).Int64()
 	if err != nil {
+		l.trace.released(err)
 		return fmt.Errorf("failed to unlock: %w", err)
 	}
-
-	if result.(int64) == 0 {
-		return fmt.Errorf("lock not found or value mismatch")
+	if result == 0 {
+		l.trace.released(ErrLockLost)
+		return ErrLockLost
+	}
+	l.trace.released(nil)
+	// 唤醒等待者，发布失败时等待者会在兜底间隔后重试
+	if err := l.rdb.Publish(ctx, l.key+releasedChannelSuffix, l.value).Err(); err != nil {
+		l.logger.Warn("failed to publish lock release", zap.String("key", l.key), zap.Error(err))
 	}
-
 	return nil
 }
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// HeldLock 当前被持有的锁
+type HeldLock struct {
+	// 去掉 KeyPrefix 后的锁名
+	Name string
+	// 锁的类型：mutex / reentrant / read / write / semaphore
+	Kind string
+	// 持有者，只包含租约未过期的持有者
+	Holders []LockHolder
+	// 剩余过期时间，小于 0 表示不过期
+	TTL time.Duration
+	// 排队等待的数量，只有 WaitLock 使用等待队列
+	Waiters int64
+}
+
+// LockHolder 锁的持有者
+type LockHolder struct {
+	Owner string
+	// 可重入锁的重入次数，其他锁为 1
+	Count int64
+}
+
+// escapeGlob 转义 SCAN 匹配模式中的通配符，前缀按字面匹配
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// lockAuxiliarySuffixes 锁的附属 key，不是锁本身
+var lockAuxiliarySuffixes = []string{fenceKeySuffix, queueKeySuffix, waitersKeySuffix, rwIntentKeySuffix}
+
+// ListHeldLocks 使用 SCAN 按游标分页列出 keyPrefix 下名称以 namePrefix 开头的锁
+// 跳过栅栏令牌、等待队列等附属 key，每页数量不固定，返回的游标为 0 表示结束
+// 只扫描 rdb 一个节点，Redlock 在其他加锁节点上的锁不会列出
+func ListHeldLocks(ctx context.Context, rdb *redis.Client, keyPrefix string, namePrefix string, cursor uint64, count int64) ([]HeldLock, uint64, error) {
+	keys, next, err := rdb.Scan(ctx, cursor, escapeGlob(keyPrefix+namePrefix)+"*", count).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan locks: %w", err)
+	}
+
+	var candidates []string
+	for _, key := range keys {
+		auxiliary := false
+		for _, suffix := range lockAuxiliarySuffixes {
+			if strings.HasSuffix(key, suffix) {
+				auxiliary = true
+				break
+			}
+		}
+		if !auxiliary {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, next, nil
+	}
+
+	// 1.查询类型和剩余时间
+	pipe := rdb.Pipeline()
+	types := make([]*redis.StatusCmd, len(candidates))
+	ttls := make([]*redis.DurationCmd, len(candidates))
+	for i, key := range candidates {
+		types[i] = pipe.Type(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, fmt.Errorf("failed to inspect locks: %w", err)
+	}
+
+	// 2.按类型查询持有者，扫描之后才释放的锁类型为 none，直接跳过
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe = rdb.Pipeline()
+	holders := make([]redis.Cmder, len(candidates))
+	waiters := make([]*redis.IntCmd, len(candidates))
+	for i, key := range candidates {
+		switch types[i].Val() {
+		case "string":
+			holders[i] = pipe.Get(ctx, key)
+			waiters[i] = pipe.LLen(ctx, key+queueKeySuffix)
+		case "hash":
+			holders[i] = pipe.HGetAll(ctx, key)
+		case "zset":
+			holders[i] = pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: "(" + now, Max: "+inf"})
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, fmt.Errorf("failed to inspect locks: %w", err)
+	}
+
+	var locks []HeldLock
+	for i, key := range candidates {
+		lock := HeldLock{
+			Name: strings.TrimPrefix(key, keyPrefix),
+			TTL:  ttls[i].Val(),
+		}
+		switch cmd := holders[i].(type) {
+		case *redis.StringCmd:
+			if cmd.Err() != nil {
+				continue
+			}
+			lock.Kind = "mutex"
+			if strings.HasSuffix(key, rwWriterKeySuffix) {
+				lock.Kind = "write"
+				lock.Name = strings.TrimSuffix(lock.Name, rwWriterKeySuffix)
+			}
+			lock.Holders = []LockHolder{{Owner: cmd.Val(), Count: 1}}
+			lock.Waiters = waiters[i].Val()
+		case *redis.MapStringStringCmd:
+			lock.Kind = "reentrant"
+			for owner, count := range cmd.Val() {
+				n, _ := strconv.ParseInt(count, 10, 64)
+				lock.Holders = append(lock.Holders, LockHolder{Owner: owner, Count: n})
+			}
+			sort.Slice(lock.Holders, func(i, j int) bool { return lock.Holders[i].Owner < lock.Holders[j].Owner })
+		case *redis.StringSliceCmd:
+			lock.Kind = "semaphore"
+			if strings.HasSuffix(key, rwReadersKeySuffix) {
+				lock.Kind = "read"
+				lock.Name = strings.TrimSuffix(lock.Name, rwReadersKeySuffix)
+			}
+			for _, owner := range cmd.Val() {
+				lock.Holders = append(lock.Holders, LockHolder{Owner: owner, Count: 1})
+			}
+		}
+		if len(lock.Holders) == 0 {
+			continue
+		}
+		locks = append(locks, lock)
+	}
+	return locks, next, nil
+}
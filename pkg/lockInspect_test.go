@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestListHeldLocks(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+
+	mutex := NewRedisLock(rdb, zap.NewNop(), "register:lock:alice", testLockConfig)
+	if _, ok, err := mutex.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	defer mutex.Unlock(ctx)
+	reentrant := NewReentrantLock(rdb, zap.NewNop(), "order:1", testLockConfig)
+	ownerCtx, ok, err := reentrant.Lock(ctx)
+	if err != nil || !ok {
+		t.Fatalf("ReentrantLock.Lock() = %v, %v", ok, err)
+	}
+	defer reentrant.Unlock(ctx)
+	nested := NewReentrantLock(rdb, zap.NewNop(), "order:1", testLockConfig)
+	if _, ok, err := nested.Lock(ownerCtx); err != nil || !ok {
+		t.Fatalf("nested Lock() = %v, %v", ok, err)
+	}
+	defer nested.Unlock(ctx)
+	reader := NewRedisRWLock(rdb, zap.NewNop(), "doc", testLockConfig, true)
+	if ok, err := reader.RLock(ctx); err != nil || !ok {
+		t.Fatalf("RLock() = %v, %v", ok, err)
+	}
+	defer reader.RUnlock(ctx)
+	// 只剩过期许可的信号量不算被持有
+	_, _ = mr.ZAdd("lock:export", float64(time.Now().Add(-time.Second).UnixMilli()), "expired")
+	_, _ = mr.Push("lock:register:lock:alice:queue", "waiter")
+
+	var locks []HeldLock
+	var cursor uint64
+	for {
+		page, next, err := ListHeldLocks(ctx, rdb, "lock:", "", cursor, 10)
+		if err != nil {
+			t.Fatalf("ListHeldLocks() error = %v", err)
+		}
+		locks = append(locks, page...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	got := make(map[string]HeldLock)
+	for _, lock := range locks {
+		got[lock.Name] = lock
+	}
+	if len(got) != 3 {
+		t.Fatalf("ListHeldLocks() = %+v, want 3 locks", locks)
+	}
+	if lock := got["register:lock:alice"]; lock.Kind != "mutex" || lock.Holders[0].Owner != mutex.value || lock.Waiters != 1 || lock.TTL <= 0 {
+		t.Errorf("mutex = %+v", lock)
+	}
+	if lock := got["order:1"]; lock.Kind != "reentrant" || len(lock.Holders) != 1 || lock.Holders[0].Count != 2 {
+		t.Errorf("reentrant = %+v", lock)
+	}
+	if lock := got["doc"]; lock.Kind != "read" || len(lock.Holders) != 1 || lock.Holders[0].Owner != reader.id {
+		t.Errorf("read lock = %+v", lock)
+	}
+
+	// 按名称前缀过滤
+	page, _, err := ListHeldLocks(ctx, rdb, "lock:", "register:", 0, 100)
+	if err != nil || len(page) != 1 || page[0].Name != "register:lock:alice" {
+		t.Errorf("ListHeldLocks(register:) = %+v, %v", page, err)
+	}
+}
+
+func TestListHeldLocks_EscapePrefix(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	for _, name := range []string{"register:lock:a*b", "register:lock:axb", "register:lock:[x]", "register:lock:x"} {
+		l := NewRedisLock(rdb, zap.NewNop(), name, testLockConfig)
+		if _, ok, err := l.Lock(ctx); err != nil || !ok {
+			t.Fatalf("Lock(%q) = %v, %v", name, ok, err)
+		}
+		defer l.Unlock(ctx)
+	}
+
+	// 前缀中的通配符按字面匹配
+	tests := map[string]string{
+		"register:lock:a*": "register:lock:a*b",
+		"register:lock:[":  "register:lock:[x]",
+	}
+	for prefix, want := range tests {
+		page, _, err := ListHeldLocks(ctx, rdb, "lock:", prefix, 0, 100)
+		if err != nil || len(page) != 1 || page[0].Name != want {
+			t.Errorf("ListHeldLocks(%q) = %+v, %v, want only %s", prefix, page, err, want)
+		}
+	}
+	if page, _, err := ListHeldLocks(ctx, rdb, "lock:", "register:lock:?", 0, 100); err != nil || len(page) != 0 {
+		t.Errorf("ListHeldLocks(?) = %+v, %v, want none", page, err)
+	}
+}
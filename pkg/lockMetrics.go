@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// lockMetrics 按 LockConfig.MetricName 统计的指标，通过 pprof 服务的 /debug/vars 暴露
+// <指标名>.wait_ms、<指标名>.hold_ms 为等待和持有时长的直方图，<指标名>.outcome.<结果> 为计数
+// expvar 的指标无法删除，指标名只能来自固定的集合，不能从锁的 key 推导
+var lockMetrics = expvar.NewMap("distributed_lock")
+
+// 加锁结果
+const (
+	lockOutcomeAcquired = "acquired"
+	lockOutcomeTimeout  = "timeout"
+	lockOutcomeCanceled = "canceled"
+	lockOutcomeError    = "error"
+	// 持有期间锁过期或被他人获取
+	lockOutcomeLost = "lost"
+)
+
+// lockDurationBuckets 时长直方图的桶上界（毫秒）
+var lockDurationBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// lockHistogramsMu 保证同一指标只创建一个直方图
+var lockHistogramsMu sync.Mutex
+
+// histogram 累计分布的直方图，输出格式与 Prometheus 的 le 桶一致
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []int64
+	count   int64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)),
+	}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count++
+	h.sum += v
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+}
+
+// String 实现 expvar.Var
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make(map[string]int64, len(h.buckets)+1)
+	for i, upper := range h.buckets {
+		buckets[strconv.FormatFloat(upper, 'f', -1, 64)] = h.counts[i]
+	}
+	buckets["+Inf"] = h.count
+	data, _ := json.Marshal(map[string]any{
+		"count":   h.count,
+		"sum":     h.sum,
+		"buckets": buckets,
+	})
+	return string(data)
+}
+
+func observeLockDuration(name string, d time.Duration) {
+	lockHistogramsMu.Lock()
+	h, ok := lockMetrics.Get(name).(*histogram)
+	if !ok {
+		h = newHistogram(lockDurationBuckets)
+		lockMetrics.Set(name, h)
+	}
+	lockHistogramsMu.Unlock()
+	h.Observe(float64(d) / float64(time.Millisecond))
+}
+
+// defaultLockMetricName 未指定指标名的锁统一统计到该名称下
+const defaultLockMetricName = "default"
+
+// lockTrace 记录一次加锁的等待时长、结果和持有时长，并创建对应的追踪 span
+type lockTrace struct {
+	kind       string
+	key        string
+	metric     string
+	start      time.Time
+	span       opentracing.Span
+	acquiredAt time.Time
+	holdSpan   opentracing.Span
+}
+
+// startLockTrace 开始等待锁，kind 为锁的类型，span 名为 <kind>.<operation>，指标记录到 metricName 下
+func startLockTrace(ctx context.Context, kind string, operation string, key string, metricName string) *lockTrace {
+	span, _ := opentracing.StartSpanFromContext(ctx, kind+"."+operation)
+	span.SetTag("lock.key", key)
+	if metricName == "" {
+		metricName = defaultLockMetricName
+	}
+	return &lockTrace{
+		kind:   kind,
+		key:    key,
+		metric: metricName,
+		start:  time.Now(),
+		span:   span,
+	}
+}
+
+// acquireDone 结束等待，获取成功时开始记录持有时长，持有 span 为 ctx 中 span 的子 span
+func (t *lockTrace) acquireDone(ctx context.Context, acquired bool, err error) {
+	outcome := lockOutcomeTimeout
+	switch {
+	case acquired:
+		outcome = lockOutcomeAcquired
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		outcome = lockOutcomeCanceled
+	case err != nil:
+		outcome = lockOutcomeError
+	}
+	observeLockDuration(t.metric+".wait_ms", time.Since(t.start))
+	lockMetrics.Add(t.metric+".outcome."+outcome, 1)
+
+	t.span.SetTag("lock.outcome", outcome)
+	if err != nil {
+		ext.LogError(t.span, err)
+	}
+	t.span.Finish()
+
+	if acquired {
+		t.acquiredAt = time.Now()
+		t.holdSpan, _ = opentracing.StartSpanFromContext(ctx, t.kind+".Hold")
+		t.holdSpan.SetTag("lock.key", t.key)
+	}
+}
+
+// released 释放锁时记录持有时长，err 为 ErrLockLost 时计为锁丢失，可重复调用
+func (t *lockTrace) released(err error) {
+	if t == nil || t.holdSpan == nil {
+		return
+	}
+	observeLockDuration(t.metric+".hold_ms", time.Since(t.acquiredAt))
+	if errors.Is(err, ErrLockLost) {
+		lockMetrics.Add(t.metric+".outcome."+lockOutcomeLost, 1)
+		t.holdSpan.SetTag("lock.lost", true)
+	}
+	t.holdSpan.Finish()
+	t.holdSpan = nil
+}
@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"go.uber.org/zap"
+)
+
+func TestLockMetricName(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	config := testLockConfig
+	config.MetricName = "register"
+
+	// 用户名中带有 ':' 时也只统计到固定的指标名，不产生新的指标
+	var before int
+	lockMetrics.Do(func(expvar.KeyValue) { before++ })
+	for _, username := range []string{"alice", "bob:smith", "a:b:c"} {
+		l := NewRedisLock(rdb, zap.NewNop(), "register:lock:"+username, config)
+		if _, ok, err := l.Lock(ctx); err != nil || !ok {
+			t.Fatalf("Lock(%q) = %v, %v", username, ok, err)
+		}
+		_ = l.Unlock(ctx)
+	}
+	var names []string
+	lockMetrics.Do(func(kv expvar.KeyValue) { names = append(names, kv.Key) })
+	for _, name := range names {
+		if strings.HasPrefix(name, "register:") || strings.Contains(name, "bob") || strings.HasPrefix(name, "a:b") {
+			t.Errorf("metric %q derived from the lock key", name)
+		}
+	}
+	if lockMetrics.Get("register.hold_ms") == nil {
+		t.Errorf("register.hold_ms not recorded")
+	}
+	// 新增的最多为 register 的 wait_ms、hold_ms 和 outcome.acquired
+	if added := len(names) - before; added > 3 {
+		t.Errorf("added %d metrics, want at most 3: %v", added, names)
+	}
+}
+
+func TestLockMetrics(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	config := testLockConfig
+	config.MetricName = "metrics"
+	a := NewRedisLock(rdb, zap.NewNop(), "metrics:a", config)
+	b := NewRedisLock(rdb, zap.NewNop(), "metrics:b", config)
+	c := NewRedisLock(rdb, zap.NewNop(), "metrics:a", config)
+
+	counter := func(name string) int64 {
+		if v := lockMetrics.Get(name); v != nil {
+			var n int64
+			_ = json.Unmarshal([]byte(v.String()), &n)
+			return n
+		}
+		return 0
+	}
+	acquired := counter("metrics.outcome.acquired")
+	timeout := counter("metrics.outcome.timeout")
+
+	_, _, _ = a.Lock(ctx)
+	_, _, _ = b.Lock(ctx)
+	_, _, _ = c.Lock(ctx)
+	_ = a.Unlock(ctx)
+	_ = b.Unlock(ctx)
+
+	if got := counter("metrics.outcome.acquired") - acquired; got != 2 {
+		t.Errorf("acquired = %d, want 2", got)
+	}
+	if got := counter("metrics.outcome.timeout") - timeout; got != 1 {
+		t.Errorf("timeout = %d, want 1", got)
+	}
+	var hold struct {
+		Count   int64            `json:"count"`
+		Buckets map[string]int64 `json:"buckets"`
+	}
+	if err := json.Unmarshal([]byte(lockMetrics.Get("metrics.hold_ms").String()), &hold); err != nil {
+		t.Fatalf("hold_ms = %v", err)
+	}
+	if hold.Count < 2 || hold.Buckets["+Inf"] != hold.Count {
+		t.Errorf("hold_ms = %+v", hold)
+	}
+}
+
+func TestLockTraceSpans(t *testing.T) {
+	tracer := mocktracer.New()
+	previous := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() { opentracing.SetGlobalTracer(previous) })
+
+	_, rdb := newTestLockRedis(t)
+	parent := tracer.StartSpan("UserService.Register")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	l := NewRedisLock(rdb, zap.NewNop(), "register:lock:alice", testLockConfig)
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	_ = l.Unlock(ctx)
+	parent.Finish()
+
+	spans := make(map[string]*mocktracer.MockSpan)
+	for _, span := range tracer.FinishedSpans() {
+		spans[span.OperationName] = span
+	}
+	parentID := parent.Context().(mocktracer.MockSpanContext).SpanID
+	for _, name := range []string{"RedisLock.Lock", "RedisLock.Hold"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("span %s not finished", name)
+		}
+		if span.ParentID != parentID {
+			t.Errorf("span %s parent = %d, want %d", name, span.ParentID, parentID)
+		}
+	}
+	if outcome := spans["RedisLock.Lock"].Tag("lock.outcome"); outcome != lockOutcomeAcquired {
+		t.Errorf("lock.outcome = %v", outcome)
+	}
+}
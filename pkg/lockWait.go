@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// lockWaiterTTL 等待者的存活期限，等待期间定期刷新，进程退出后由后续等待者清理
+	lockWaiterTTL = 5 * time.Second
+	// lockWaitFallback 兜底重试间隔，覆盖锁过期未发布释放消息、或消息丢失的情况
+	lockWaitFallback = time.Second
+)
+
+// WaitLock 阻塞等待获取锁，等待者按加入队列的顺序依次获取
+// 释放锁时通过 pub/sub 唤醒等待者，等待期限为 DefaultWaitTime，与 MaxRetries 无关
+// 超过期限返回 false，ctx 结束时返回 ctx.Err()，两种情况都会退出等待队列
+func (l *RedisLock) WaitLock(ctx context.Context) (int64, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held {
+		return 0, false, fmt.Errorf("lock %s already acquired", l.key)
+	}
+
+	trace := startLockTrace(ctx, "RedisLock", "WaitLock", l.key, l.config.MetricName)
+	token, acquired, err := l.wait(ctx)
+	trace.acquireDone(ctx, acquired, err)
+	if acquired {
+		l.trace = trace
+	}
+	return token, acquired, err
+}
+
+// wait 订阅释放消息并排队等待，调用方需持有 l.mu
+func (l *RedisLock) wait(ctx context.Context) (int64, bool, error) {
+	// 先订阅再尝试获取，避免错过两者之间的释放消息
+	sub := l.rdb.Subscribe(ctx, l.key+releasedChannelSuffix)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return 0, false, fmt.Errorf("failed to subscribe lock channel: %w", err)
+	}
+	released := sub.Channel()
+
+	deadline := time.NewTimer(l.config.DefaultWaitTime)
+	defer deadline.Stop()
+	fallback := time.NewTicker(lockWaitFallback)
+	defer fallback.Stop()
+	for {
+		token, err := l.tryAcquire(ctx, true)
+		if err != nil {
+			l.leaveQueue()
+			return 0, false, err
+		}
+		if token > 0 {
+			return token, true, nil
+		}
+
+		select {
+		case <-released:
+		case <-fallback.C:
+		case <-deadline.C:
+			l.leaveQueue()
+			return 0, false, nil
+		case <-ctx.Done():
+			l.leaveQueue()
+			return 0, false, ctx.Err()
+		}
+	}
+}
+
+// leaveQueue 放弃等待时退出队列，并唤醒其他等待者，避免队首空等到存活期限
+func (l *RedisLock) leaveQueue() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pipe := l.rdb.TxPipeline()
+	pipe.LRem(ctx, l.key+queueKeySuffix, 0, l.value)
+	pipe.ZRem(ctx, l.key+waitersKeySuffix, l.value)
+	pipe.Publish(ctx, l.key+releasedChannelSuffix, "")
+	if _, err := pipe.Exec(ctx); err != nil {
+		l.logger.Warn("failed to leave lock queue", zap.String("key", l.key), zap.Error(err))
+	}
+}
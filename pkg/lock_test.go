@@ -0,0 +1,358 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func newTestLockRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, rdb
+}
+
+var testLockConfig = LockConfig{
+	DefaultExpiration: 10 * time.Second,
+	DefaultWaitTime:   50 * time.Millisecond,
+	KeyPrefix:         "lock:",
+	MaxRetries:        3,
+	RetryInterval:     10 * time.Millisecond,
+}
+
+func TestRedisLock_Contention(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	a := NewRedisLock(rdb, zap.NewNop(), "order", testLockConfig)
+	b := NewRedisLock(rdb, zap.NewNop(), "order", testLockConfig)
+
+	if _, ok, err := a.Lock(ctx); err != nil || !ok {
+		t.Fatalf("a.Lock() = %v, %v", ok, err)
+	}
+	if _, ok, err := b.Lock(ctx); err != nil || ok {
+		t.Fatalf("b.Lock() = %v, %v, want not acquired", ok, err)
+	}
+	// 获取失败时不启动续期
+	if b.lease != nil {
+		t.Errorf("renew started after failed acquisition")
+	}
+	// 未持有锁时解锁不影响持有者
+	if err := b.Unlock(ctx); err != nil {
+		t.Errorf("b.Unlock() error = %v", err)
+	}
+
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatalf("a.Unlock() error = %v", err)
+	}
+	if _, ok, err := b.Lock(ctx); err != nil || !ok {
+		t.Fatalf("b.Lock() after release = %v, %v", ok, err)
+	}
+	_ = b.Unlock(ctx)
+}
+
+func TestRedisLock_DoubleUnlock(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	l := NewRedisLock(rdb, zap.NewNop(), "order", testLockConfig)
+
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	if err := l.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if err := l.Unlock(ctx); err != nil {
+		t.Errorf("second Unlock() error = %v", err)
+	}
+	if mr.Exists("lock:order") {
+		t.Errorf("lock key still exists")
+	}
+
+	// 释放后可以再次获取
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() after unlock = %v, %v", ok, err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestRedisLock_Expiry(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	a := NewRedisLock(rdb, zap.NewNop(), "order", testLockConfig)
+	b := NewRedisLock(rdb, zap.NewNop(), "order", testLockConfig)
+
+	tokenA, ok, err := a.Lock(ctx)
+	if err != nil || !ok {
+		t.Fatalf("a.Lock() = %v, %v", ok, err)
+	}
+	mr.FastForward(testLockConfig.DefaultExpiration)
+	tokenB, ok, err := b.Lock(ctx)
+	if err != nil || !ok {
+		t.Fatalf("b.Lock() after expiry = %v, %v", ok, err)
+	}
+	// 栅栏令牌单调递增，且计数器不随锁过期
+	if tokenA <= 0 || tokenB <= tokenA || b.Token() != tokenB {
+		t.Errorf("fencing tokens = %d, %d, want increasing", tokenA, tokenB)
+	}
+
+	// 过期后解锁不能删除他人的锁
+	if err := a.Unlock(ctx); !errors.Is(err, ErrLockLost) {
+		t.Errorf("a.Unlock() error = %v, want ErrLockLost", err)
+	}
+	if !mr.Exists("lock:order") {
+		t.Errorf("b's lock deleted by a")
+	}
+	_ = b.Unlock(ctx)
+}
+
+func TestRedisLock_Renew(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	config := testLockConfig
+	config.DefaultExpiration = 300 * time.Millisecond
+	l := NewRedisLock(rdb, zap.NewNop(), "order", config)
+
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	mr.SetTTL("lock:order", 10*time.Millisecond)
+	time.Sleep(250 * time.Millisecond)
+	if ttl := mr.TTL("lock:order"); ttl != config.DefaultExpiration {
+		t.Errorf("TTL after renew = %v, want %v", ttl, config.DefaultExpiration)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestRedisLock_Lost(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	config := testLockConfig
+	config.DefaultExpiration = 150 * time.Millisecond
+	l := NewRedisLock(rdb, zap.NewNop(), "order", config)
+
+	if l.Lost() != nil {
+		t.Fatalf("Lost() before acquisition should be nil")
+	}
+	ctx, cancel := l.Context(context.Background())
+	if !errors.Is(context.Cause(ctx), ErrLockLost) {
+		t.Errorf("Context() before acquisition cause = %v", context.Cause(ctx))
+	}
+	cancel()
+
+	// 调用方 ctx 结束不影响续期
+	reqCtx, reqCancel := context.WithCancel(context.Background())
+	if _, ok, err := l.Lock(reqCtx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	reqCancel()
+	ctx, cancel = l.Context(context.Background())
+	defer cancel()
+
+	// 锁被他人覆盖后，下一次续期发现丢失
+	mr.Set("lock:order", "other")
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatalf("Lost() not closed after value changed")
+	}
+	<-ctx.Done()
+	if !errors.Is(context.Cause(ctx), ErrLockLost) {
+		t.Errorf("context cause = %v, want ErrLockLost", context.Cause(ctx))
+	}
+	if err := l.Unlock(context.Background()); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Unlock() error = %v, want ErrLockLost", err)
+	}
+	if got, _ := mr.Get("lock:order"); got != "other" {
+		t.Errorf("other owner's lock was deleted")
+	}
+}
+
+func TestRedisLock_LostBeforeExpiry(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	config := testLockConfig
+	config.DefaultExpiration = 300 * time.Millisecond
+	l := NewRedisLock(rdb, zap.NewNop(), "order", config)
+	if _, ok, err := l.Lock(context.Background()); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	acquiredAt := time.Now()
+
+	// 续期一直暂时性失败，在 key 过期之前就通知持有者
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatalf("Lost() not closed while renewals keep failing")
+	}
+	elapsed := time.Since(acquiredAt)
+	mr.SetError("")
+	// miniredis 的过期时间不随真实时间流逝，按实际经过的时间快进后 key 仍未过期
+	mr.FastForward(elapsed)
+	if ttl := mr.TTL("lock:order"); ttl <= 0 {
+		t.Errorf("Lost() closed after the key expired: elapsed %v, remaining ttl %v", elapsed, ttl)
+	}
+	_ = l.Unlock(context.Background())
+}
+
+func TestRedisLock_UnlockDoesNotSignalLost(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	l := NewRedisLock(rdb, zap.NewNop(), "order", testLockConfig)
+	if _, ok, err := l.Lock(context.Background()); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	lost := l.Lost()
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	select {
+	case <-lost:
+		t.Errorf("Lost() closed by Unlock")
+	default:
+	}
+}
+
+func TestRedisLock_WaitLockWakeup(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	config := testLockConfig
+	config.DefaultWaitTime = 5 * time.Second
+	holder := NewRedisLock(rdb, zap.NewNop(), "order", config)
+	if _, ok, err := holder.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+
+	// b 先于 c 进入队列，释放后按顺序获取
+	order := make(chan string, 2)
+	wait := func(name string, l *RedisLock) {
+		if _, ok, err := l.WaitLock(ctx); err != nil || !ok {
+			t.Errorf("%s.WaitLock() = %v, %v", name, ok, err)
+			return
+		}
+		order <- name
+	}
+	b := NewRedisLock(rdb, zap.NewNop(), "order", config)
+	c := NewRedisLock(rdb, zap.NewNop(), "order", config)
+	go wait("b", b)
+	time.Sleep(50 * time.Millisecond)
+	go wait("c", c)
+	time.Sleep(50 * time.Millisecond)
+
+	// 释放后通过 pub/sub 唤醒，早于兜底重试间隔
+	start := time.Now()
+	if err := holder.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if first := <-order; first != "b" {
+		t.Fatalf("first acquirer = %s, want b", first)
+	}
+	if elapsed := time.Since(start); elapsed >= lockWaitFallback {
+		t.Errorf("waiter woke after %v, want pub/sub wakeup", elapsed)
+	}
+	select {
+	case name := <-order:
+		t.Fatalf("%s acquired while b holds the lock", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := b.Unlock(ctx); err != nil {
+		t.Fatalf("b.Unlock() error = %v", err)
+	}
+	if second := <-order; second != "c" {
+		t.Fatalf("second acquirer = %s, want c", second)
+	}
+	_ = c.Unlock(ctx)
+}
+
+func TestRedisLock_WaitLockCancel(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	config := testLockConfig
+	config.DefaultWaitTime = 100 * time.Millisecond
+	config.MaxRetries = 1
+	holder := NewRedisLock(rdb, zap.NewNop(), "order", config)
+	if _, ok, err := holder.Lock(context.Background()); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	defer holder.Unlock(context.Background())
+
+	// 等待期限与重试次数无关
+	start := time.Now()
+	waiter := NewRedisLock(rdb, zap.NewNop(), "order", config)
+	if _, ok, err := waiter.WaitLock(context.Background()); err != nil || ok {
+		t.Fatalf("WaitLock() = %v, %v, want timeout", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed < config.DefaultWaitTime {
+		t.Errorf("WaitLock() returned after %v, want at least %v", elapsed, config.DefaultWaitTime)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := waiter.WaitLock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitLock() error = %v, want context deadline", err)
+	}
+	if _, _, err := waiter.Lock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock() error = %v, want context deadline", err)
+	}
+	// 放弃等待后退出队列
+	if items, _ := mr.List("lock:order:queue"); len(items) > 0 {
+		t.Errorf("queue = %v, want empty", items)
+	}
+}
+
+func TestRedisLock_QueueFairness(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	l := NewRedisLock(rdb, zap.NewNop(), "order", testLockConfig)
+
+	// 存活的等待者在队首时，轮询获取不能插队
+	_, _ = mr.Push("lock:order:queue", "waiter")
+	_, _ = mr.ZAdd("lock:order:waiters", float64(time.Now().Add(time.Minute).UnixMilli()), "waiter")
+	if _, ok, err := l.Lock(ctx); err != nil || ok {
+		t.Fatalf("Lock() = %v, %v, want blocked by queue", ok, err)
+	}
+
+	// 等待者超过存活期限后被清理
+	_, _ = mr.ZAdd("lock:order:waiters", float64(time.Now().Add(-time.Second).UnixMilli()), "waiter")
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v, want acquired after stale waiter removed", ok, err)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestRedisLock_FenceTTL(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+
+	// 默认不过期
+	l := NewRedisLock(rdb, zap.NewNop(), "order", testLockConfig)
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	_ = l.Unlock(ctx)
+	if ttl := mr.TTL("lock:order:fence"); ttl != 0 {
+		t.Errorf("fence ttl without FenceTTL = %v, want none", ttl)
+	}
+
+	config := testLockConfig
+	config.FenceTTL = time.Hour
+	for i := 1; i <= 2; i++ {
+		l := NewRedisLock(rdb, zap.NewNop(), "register:lock:alice", config)
+		token, ok, err := l.Lock(ctx)
+		if err != nil || !ok || token != int64(i) {
+			t.Fatalf("Lock() #%d = %d, %v, %v", i, token, ok, err)
+		}
+		_ = l.Unlock(ctx)
+	}
+	if ttl := mr.TTL("lock:register:lock:alice:fence"); ttl != time.Hour {
+		t.Errorf("fence ttl = %v, want %v", ttl, time.Hour)
+	}
+	// 过期后计数器被删除
+	mr.FastForward(time.Hour)
+	if mr.Exists("lock:register:lock:alice:fence") {
+		t.Errorf("fence counter not expired")
+	}
+}
@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// 通知渠道
+const (
+	ChannelEmail = "email"
+	ChannelPhone = "phone"
+)
+
+// Message 一条待发送的通知
+type Message struct {
+	Channel string `json:"channel"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Sender 通知发送者，不同的实现对应不同的发送方式
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSender 根据 notification.sender 创建发送者，默认为 log
+func NewSender(conf *viper.Viper, logger *zap.Logger) (Sender, error) {
+	switch sender := conf.GetString("notification.sender"); sender {
+	case "", "log":
+		return NewLogSender(logger), nil
+	case "file":
+		path := conf.GetString("notification.file_path")
+		if path == "" {
+			return nil, fmt.Errorf("notification.file_path is required for file sender")
+		}
+		return NewFileSender(path), nil
+	case "smtp":
+		return NewSMTPSender(SMTPConfig{
+			Addr:     conf.GetString("notification.smtp.addr"),
+			Username: conf.GetString("notification.smtp.username"),
+			Password: conf.GetString("notification.smtp.password"),
+			From:     conf.GetString("notification.smtp.from"),
+		}, NewLogSender(logger)), nil
+	default:
+		return nil, fmt.Errorf("unknown notification sender: %s", sender)
+	}
+}
+
+// LogSender 只把通知写入日志，用于本地开发
+type LogSender struct {
+	logger *zap.Logger
+}
+
+func NewLogSender(logger *zap.Logger) *LogSender {
+	return &LogSender{logger: logger}
+}
+
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	s.logger.Info("notification sent",
+		zap.String("channel", msg.Channel),
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+		zap.String("body", msg.Body),
+	)
+	return nil
+}
+
+// FileSender 把通知按 JSON 行追加到文件，便于本地开发和测试读取验证码
+type FileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSender(path string) *FileSender {
+	return &FileSender{path: path}
+}
+
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	line, err := json.Marshal(struct {
+		Message
+		SentAt time.Time `json:"sent_at"`
+	}{msg, time.Now()})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// SMTPConfig 邮件服务器配置
+type SMTPConfig struct {
+	// host:port
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPSender 通过 SMTP 发送邮件，其他渠道交给 fallback
+type SMTPSender struct {
+	config   SMTPConfig
+	fallback Sender
+}
+
+func NewSMTPSender(config SMTPConfig, fallback Sender) *SMTPSender {
+	return &SMTPSender{config: config, fallback: fallback}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	if msg.Channel != ChannelEmail {
+		return s.fallback.Send(ctx, msg)
+	}
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		host, _, err := net.SplitHostPort(s.config.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, host)
+	}
+	body := strings.Join([]string{
+		"From: " + s.config.From,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		msg.Body,
+	}, "\r\n")
+	return smtp.SendMail(s.config.Addr, auth, s.config.From, []string{msg.To}, []byte(body))
+}
@@ -0,0 +1,22 @@
+package pkg
+
+import (
+	"context"
+	"google.golang.org/grpc/peer"
+	"net"
+)
+
+// ClientIP 返回 gRPC 调用方的 IP，无法获取时返回空字符串
+func ClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// 非 host:port 形式的地址（如 unix socket）原样返回
+		return addr
+	}
+	return host
+}
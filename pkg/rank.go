@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"math"
+	"time"
+)
+
+// RankItem 待重排的候选
+type RankItem struct {
+	ID string
+	// 相关性得分，越大越相关
+	Relevance float64
+	// 用于计算候选之间相似度的向量，为空时不参与多样性惩罚
+	Vector Vector
+}
+
+// CosineSimilarity 计算两个向量的余弦相似度，维度不一致或存在零向量时返回 0
+func CosineSimilarity(a, b Vector) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// MeanVector 计算多个向量的平均值，维度与第一个向量不一致的向量被忽略，没有向量时返回 nil
+func MeanVector(vectors []Vector) Vector {
+	var (
+		mean  Vector
+		count int
+	)
+	for _, v := range vectors {
+		if len(v) == 0 || (mean != nil && len(v) != len(mean)) {
+			continue
+		}
+		if mean == nil {
+			mean = make(Vector, len(v))
+		}
+		for i := range v {
+			mean[i] += v[i]
+		}
+		count++
+	}
+	for i := range mean {
+		mean[i] /= float32(count)
+	}
+	return mean
+}
+
+// RecencyScore 按半衰期计算时间衰减得分，刚发生为 1，每经过一个半衰期减半
+func RecencyScore(t time.Time, now time.Time, halfLife time.Duration) float64 {
+	if halfLife <= 0 {
+		return 0
+	}
+	age := now.Sub(t)
+	if age < 0 {
+		age = 0
+	}
+	return math.Exp(-math.Ln2 * float64(age) / float64(halfLife))
+}
+
+// MMR 最大边际相关性重排，依次选出 lambda*相关性 - (1-lambda)*与已选结果最大相似度 最高的候选
+// lambda 为 1 时等价于按相关性排序，k <= 0 时返回全部候选
+func MMR(items []RankItem, lambda float64, k int) []RankItem {
+	if k <= 0 || k > len(items) {
+		k = len(items)
+	}
+
+	// maxSim[i] 记录候选 i 与已选结果的最大相似度，每选出一个只需增量更新
+	maxSim := make([]float64, len(items))
+	selected := make([]bool, len(items))
+	result := make([]RankItem, 0, k)
+	for len(result) < k {
+		best, bestScore := -1, math.Inf(-1)
+		for i, item := range items {
+			if selected[i] {
+				continue
+			}
+			score := lambda*item.Relevance - (1-lambda)*maxSim[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		selected[best] = true
+		result = append(result, items[best])
+
+		for i, item := range items {
+			if selected[i] {
+				continue
+			}
+			// 没有向量的候选无法比较，不受多样性惩罚
+			if len(item.Vector) == 0 || len(items[best].Vector) == 0 {
+				continue
+			}
+			if sim := CosineSimilarity(item.Vector, items[best].Vector); sim > maxSim[i] {
+				maxSim[i] = sim
+			}
+		}
+	}
+	return result
+}
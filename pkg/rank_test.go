@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMMR(t *testing.T) {
+	items := []RankItem{
+		{ID: "a", Relevance: 1.0, Vector: Vector{1, 0}},
+		{ID: "a2", Relevance: 0.95, Vector: Vector{1, 0.01}},
+		{ID: "b", Relevance: 0.8, Vector: Vector{0, 1}},
+	}
+
+	tests := []struct {
+		name   string
+		lambda float64
+		want   []string
+	}{
+		{name: "relevance only", lambda: 1, want: []string{"a", "a2", "b"}},
+		{name: "diversity", lambda: 0.5, want: []string{"a", "b", "a2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MMR(items, tt.lambda, 0)
+			if len(got) != len(tt.want) {
+				t.Fatalf("MMR() returned %d items, want %d", len(got), len(tt.want))
+			}
+			for i := range tt.want {
+				if got[i].ID != tt.want[i] {
+					t.Errorf("MMR()[%d] = %s, want %s", i, got[i].ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecencyScore(t *testing.T) {
+	now := time.Now()
+	if got := RecencyScore(now, now, time.Hour); got != 1 {
+		t.Errorf("RecencyScore(now) = %v, want 1", got)
+	}
+	if got := RecencyScore(now.Add(-time.Hour), now, time.Hour); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("RecencyScore(half life) = %v, want 0.5", got)
+	}
+}
+
+func TestMMR_MissingVectors(t *testing.T) {
+	// 没有向量的候选不能被视为彼此相同，按相关性排序
+	items := []RankItem{
+		{ID: "a", Relevance: 1.0},
+		{ID: "b", Relevance: 0.9},
+		{ID: "c", Relevance: 0.8, Vector: Vector{1, 0}},
+		{ID: "d", Relevance: 0.7},
+	}
+	got := MMR(items, 0.5, 0)
+	want := []string{"a", "b", "c", "d"}
+	for i := range want {
+		if got[i].ID != want[i] {
+			t.Fatalf("MMR()[%d] = %s, want %s", i, got[i].ID, want[i])
+		}
+	}
+}
+
+func TestMeanVector(t *testing.T) {
+	got := MeanVector([]Vector{{1, 0}, nil, {0, 1}, {1, 1, 1}})
+	if len(got) != 2 || got[0] != 0.5 || got[1] != 0.5 {
+		t.Errorf("MeanVector() = %v, want [0.5 0.5]", got)
+	}
+	if got := MeanVector(nil); got != nil {
+		t.Errorf("MeanVector(nil) = %v, want nil", got)
+	}
+}
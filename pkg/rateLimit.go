@@ -0,0 +1,227 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimit 限流额度：Period 内最多 Limit 次
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+	// GCRA 允许的最大突发请求数，0 时等于 Limit，滑动窗口忽略该值
+	Burst int
+}
+
+// RateLimitResult 一次限流判断的结果
+type RateLimitResult struct {
+	Allowed bool
+	// 当前还可以通过的请求数
+	Remaining int
+	// 被拒绝时距离下一次可以通过的时间
+	RetryAfter time.Duration
+	// 通过时本次请求在额度中的标识，Refund 时使用
+	ID string
+}
+
+// RateLimiter 基于 Redis 的分布式限流器，多个副本共享额度
+type RateLimiter interface {
+	// Allow 判断 key 的一次请求是否可以通过，通过时计入额度
+	Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+	// Refund 归还 Allow 通过时计入的一次额度，同一调用的其他规则拒绝时使用
+	Refund(ctx context.Context, key string, limit RateLimit, result RateLimitResult) error
+}
+
+// 限流算法
+const (
+	RateLimitSlidingWindow = "sliding_window"
+	RateLimitGCRA          = "gcra"
+)
+
+// NewRateLimiter 按算法名称创建限流器，key 统一加上 prefix
+func NewRateLimiter(rdb *redis.Client, algorithm string, prefix string) (RateLimiter, error) {
+	switch algorithm {
+	case RateLimitSlidingWindow:
+		return NewSlidingWindowLimiter(rdb, prefix), nil
+	case RateLimitGCRA:
+		return NewGCRALimiter(rdb, prefix), nil
+	default:
+		return nil, fmt.Errorf("unsupported rate limit algorithm: %s", algorithm)
+	}
+}
+
+// 限流脚本的 ARGV[1] 为当前时间（毫秒），0 表示使用 Redis 服务端时间，避免各副本时钟不一致改变实际额度
+
+// slidingWindowScript 滑动窗口日志：zset 记录窗口内每次请求的时间（毫秒）
+// 返回 {是否通过, 剩余次数, 需等待的毫秒数}
+var slidingWindowScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	if now == 0 then
+		local time = redis.call("TIME")
+		now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+	end
+	local window = tonumber(ARGV[2])
+	local limit = tonumber(ARGV[3])
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+	local count = redis.call("ZCARD", KEYS[1])
+	if count < limit then
+		redis.call("ZADD", KEYS[1], now, ARGV[4])
+		redis.call("PEXPIRE", KEYS[1], window)
+		return {1, limit - count - 1, 0}
+	end
+	-- 最早的请求移出窗口后才能通过
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	return {0, 0, tonumber(oldest[2]) + window - now}
+`)
+
+// SlidingWindowLimiter 滑动窗口日志限流，按请求精确计数，占用空间与 Limit 成正比
+type SlidingWindowLimiter struct {
+	rdb    *redis.Client
+	prefix string
+	// 测试时固定当前时间，为 nil 时使用 Redis 服务端时间
+	now func() time.Time
+}
+
+func NewSlidingWindowLimiter(rdb *redis.Client, prefix string) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{
+		rdb:    rdb,
+		prefix: prefix,
+	}
+}
+
+func (l *SlidingWindowLimiter) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	id := uuid.New().String()
+	values, err := slidingWindowScript.Run(ctx, l.rdb, []string{l.prefix + key},
+		nowMillis(l.now), limit.Period.Milliseconds(), limit.Limit, id,
+	).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	result := toRateLimitResult(values)
+	if result.Allowed {
+		result.ID = id
+	}
+	return result, nil
+}
+
+// Refund 从窗口中删除本次请求的记录
+func (l *SlidingWindowLimiter) Refund(ctx context.Context, key string, limit RateLimit, result RateLimitResult) error {
+	if !result.Allowed || result.ID == "" {
+		return nil
+	}
+	if err := l.rdb.ZRem(ctx, l.prefix+key, result.ID).Err(); err != nil {
+		return fmt.Errorf("failed to refund rate limit: %w", err)
+	}
+	return nil
+}
+
+// gcraScript 通用信元速率算法：只保存理论到达时间（毫秒），每次请求推后一个发射间隔
+// 理论到达时间超过当前时间的部分不大于突发容忍量时通过
+// 返回 {是否通过, 剩余次数, 需等待的毫秒数}
+var gcraScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	if now == 0 then
+		local time = redis.call("TIME")
+		now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+	end
+	local interval = tonumber(ARGV[2])
+	local tolerance = tonumber(ARGV[3])
+	local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+	if tat < now then
+		tat = now
+	end
+	local new_tat = tat + interval
+	local allow_at = new_tat - tolerance
+	if allow_at > now then
+		return {0, 0, allow_at - now}
+	end
+	redis.call("SET", KEYS[1], new_tat, "PX", new_tat - now)
+	return {1, math.floor((now - allow_at) / interval), 0}
+`)
+
+// gcraRefundScript 理论到达时间提前一个发射间隔，早于当前时间时删除 key
+var gcraRefundScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	if now == 0 then
+		local time = redis.call("TIME")
+		now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+	end
+	local interval = tonumber(ARGV[2])
+	local tat = tonumber(redis.call("GET", KEYS[1]))
+	if not tat then
+		return 0
+	end
+	local new_tat = tat - interval
+	if new_tat <= now then
+		redis.call("DEL", KEYS[1])
+	else
+		redis.call("SET", KEYS[1], new_tat, "PX", new_tat - now)
+	end
+	return 1
+`)
+
+// GCRALimiter GCRA 限流，每个 key 只保存一个时间戳，允许 Burst 次突发后按 Period/Limit 的间隔匀速通过
+type GCRALimiter struct {
+	rdb    *redis.Client
+	prefix string
+	// 测试时固定当前时间，为 nil 时使用 Redis 服务端时间
+	now func() time.Time
+}
+
+func NewGCRALimiter(rdb *redis.Client, prefix string) *GCRALimiter {
+	return &GCRALimiter{
+		rdb:    rdb,
+		prefix: prefix,
+	}
+}
+
+func (l *GCRALimiter) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Limit
+	}
+	interval := gcraInterval(limit)
+	values, err := gcraScript.Run(ctx, l.rdb, []string{l.prefix + key},
+		nowMillis(l.now), interval, interval*int64(burst),
+	).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	return toRateLimitResult(values), nil
+}
+
+// Refund 归还一个发射间隔
+func (l *GCRALimiter) Refund(ctx context.Context, key string, limit RateLimit, result RateLimitResult) error {
+	if !result.Allowed {
+		return nil
+	}
+	if err := gcraRefundScript.Run(ctx, l.rdb, []string{l.prefix + key}, nowMillis(l.now), gcraInterval(limit)).Err(); err != nil {
+		return fmt.Errorf("failed to refund rate limit: %w", err)
+	}
+	return nil
+}
+
+// nowMillis 返回传给限流脚本的当前时间，now 为 nil 时返回 0，由脚本使用 Redis 服务端时间
+func nowMillis(now func() time.Time) int64 {
+	if now == nil {
+		return 0
+	}
+	return now().UnixMilli()
+}
+
+// gcraInterval 以毫秒为单位的发射间隔，至少 1 毫秒
+func gcraInterval(limit RateLimit) int64 {
+	return max(limit.Period.Milliseconds()/int64(limit.Limit), 1)
+}
+
+func toRateLimitResult(values []int64) RateLimitResult {
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}
+}
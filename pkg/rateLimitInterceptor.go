@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 限流维度
+const (
+	// 同一方法的所有调用共享额度
+	RateLimitByMethod = "method"
+	// 按令牌中的用户区分，未登录的调用不受该规则限制
+	RateLimitByUser = "user"
+	// 按客户端 IP 区分
+	RateLimitByIP = "ip"
+)
+
+// RateLimitRetryAfterKey 被限流时响应 header 中的重试等待秒数
+const RateLimitRetryAfterKey = "retry-after"
+
+// rateLimitMetrics 限流指标，通过 pprof 服务的 /debug/vars 暴露
+var rateLimitMetrics = expvar.NewMap("rate_limiter")
+
+// RateLimitRule 一条限流规则
+type RateLimitRule struct {
+	// gRPC 方法全名，如 /user.UserService/Login，* 表示所有方法
+	Method string
+	// 限流维度：method / user / ip
+	By     string
+	Limit  int
+	Period time.Duration
+	Burst  int
+	// 为空时使用全局算法
+	Algorithm string
+}
+
+// RateLimitConfig 限流配置
+type RateLimitConfig struct {
+	Enabled bool
+	// 默认算法：sliding_window / gcra
+	Algorithm string
+	Rules     []RateLimitRule
+}
+
+// RateLimitInterceptor 按配置的规则对 gRPC 一元和流式调用限流，一次调用命中的所有规则都通过才放行
+type RateLimitInterceptor struct {
+	logger   *zap.Logger
+	jwt      *JWT
+	config   RateLimitConfig
+	limiters map[string]RateLimiter
+}
+
+func NewRateLimitInterceptor(rdb *redis.Client, logger *zap.Logger, jwt *JWT, conf *viper.Viper) (*RateLimitInterceptor, error) {
+	config := RateLimitConfig{Algorithm: RateLimitGCRA}
+	if err := conf.UnmarshalKey("rate_limit", &config); err != nil {
+		return nil, err
+	}
+
+	limiters := make(map[string]RateLimiter)
+	for _, algorithm := range []string{RateLimitSlidingWindow, RateLimitGCRA} {
+		limiter, err := NewRateLimiter(rdb, algorithm, "ratelimit:")
+		if err != nil {
+			return nil, err
+		}
+		limiters[algorithm] = limiter
+	}
+	// 默认算法必须受支持，否则未指定算法的规则没有可用的限流器
+	if _, ok := limiters[config.Algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported rate limit algorithm: %s", config.Algorithm)
+	}
+	for _, rule := range config.Rules {
+		algorithm := rule.Algorithm
+		if algorithm == "" {
+			algorithm = config.Algorithm
+		}
+		if _, ok := limiters[algorithm]; !ok {
+			return nil, fmt.Errorf("unsupported rate limit algorithm: %s", algorithm)
+		}
+		if rule.By != RateLimitByMethod && rule.By != RateLimitByUser && rule.By != RateLimitByIP {
+			return nil, fmt.Errorf("unsupported rate limit dimension: %s", rule.By)
+		}
+		if rule.Limit <= 0 || rule.Period <= 0 {
+			return nil, fmt.Errorf("rate limit of %s by %s must be positive", rule.Method, rule.By)
+		}
+	}
+	return &RateLimitInterceptor{
+		logger:   logger,
+		jwt:      jwt,
+		config:   config,
+		limiters: limiters,
+	}, nil
+}
+
+// Unary 返回一元调用的拦截器，被限流时返回 ResourceExhausted，并在 header 中携带 retry-after
+// Redis 出错时放行，避免限流器故障导致服务不可用
+func (i *RateLimitInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !i.config.Enabled {
+			return handler(ctx, req)
+		}
+		if retryAfter := i.allow(ctx, info.FullMethod); retryAfter > 0 {
+			return nil, i.reject(info.FullMethod, retryAfter, func(md metadata.MD) error {
+				return grpc.SetHeader(ctx, md)
+			})
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream 返回流式调用的拦截器，建立一次流计为一次调用，规则和响应与 Unary 相同
+func (i *RateLimitInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !i.config.Enabled {
+			return handler(srv, ss)
+		}
+		if retryAfter := i.allow(ss.Context(), info.FullMethod); retryAfter > 0 {
+			return i.reject(info.FullMethod, retryAfter, ss.SetHeader)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// allowedRule 已计入额度的规则，其他规则拒绝时需要归还
+type allowedRule struct {
+	limiter RateLimiter
+	key     string
+	limit   RateLimit
+	result  RateLimitResult
+}
+
+// allow 检查 method 命中的所有规则，返回需要等待的时间，为 0 表示放行
+// 任一规则拒绝时归还其他规则已计入的额度，被拒绝的调用不消耗任何规则的额度
+func (i *RateLimitInterceptor) allow(ctx context.Context, method string) time.Duration {
+	var (
+		retryAfter time.Duration
+		allowed    []allowedRule
+	)
+	for _, rule := range i.config.Rules {
+		if rule.Method != "*" && rule.Method != method {
+			continue
+		}
+		subject, ok := i.subject(ctx, rule.By)
+		if !ok {
+			continue
+		}
+		algorithm := rule.Algorithm
+		if algorithm == "" {
+			algorithm = i.config.Algorithm
+		}
+		key := rule.Method + ":" + rule.By + ":" + subject
+		limit := RateLimit{Limit: rule.Limit, Period: rule.Period, Burst: rule.Burst}
+		result, err := i.limiters[algorithm].Allow(ctx, key, limit)
+		if err != nil {
+			i.logger.Error("Failed to check rate limit", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		if !result.Allowed {
+			retryAfter = max(retryAfter, result.RetryAfter)
+			continue
+		}
+		allowed = append(allowed, allowedRule{limiter: i.limiters[algorithm], key: key, limit: limit, result: result})
+	}
+	if retryAfter == 0 {
+		rateLimitMetrics.Add(method+".allowed", 1)
+		return 0
+	}
+
+	for _, rule := range allowed {
+		if err := rule.limiter.Refund(ctx, rule.key, rule.limit, rule.result); err != nil {
+			i.logger.Warn("Failed to refund rate limit", zap.String("key", rule.key), zap.Error(err))
+		}
+	}
+	rateLimitMetrics.Add(method+".limited", 1)
+	return retryAfter
+}
+
+// reject 通过 setHeader 返回 retry-after，并返回 ResourceExhausted 错误
+func (i *RateLimitInterceptor) reject(method string, retryAfter time.Duration, setHeader func(md metadata.MD) error) error {
+	seconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+	if err := setHeader(metadata.Pairs(RateLimitRetryAfterKey, seconds)); err != nil {
+		i.logger.Warn("Failed to set retry-after header", zap.String("method", method), zap.Error(err))
+	}
+	return status.Error(codes.ResourceExhausted, ErrTooManyRequests)
+}
+
+// subject 返回规则维度对应的限流对象，无法确定时该规则不生效
+func (i *RateLimitInterceptor) subject(ctx context.Context, by string) (string, bool) {
+	switch by {
+	case RateLimitByMethod:
+		return "", true
+	case RateLimitByUser:
+		token, ok := TokenFromContext(ctx)
+		if !ok {
+			return "", false
+		}
+		// 只校验签名，吊销状态由业务接口校验
+		claims, err := ParseJWTClaims(token, *i.jwt)
+		if err != nil {
+			return "", false
+		}
+		return claims.Subject, true
+	case RateLimitByIP:
+		ip := ClientIP(ctx)
+		return ip, ip != ""
+	default:
+		return "", false
+	}
+}
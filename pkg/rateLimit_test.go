@@ -0,0 +1,311 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	now := time.UnixMilli(1_700_000_000_000)
+	limiter := NewSlidingWindowLimiter(rdb, "ratelimit:")
+	limiter.now = func() time.Time { return now }
+	limit := RateLimit{Limit: 3, Period: time.Second}
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(ctx, "k", limit)
+		if err != nil || !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("Allow() #%d = %+v, %v", i, result, err)
+		}
+		now = now.Add(100 * time.Millisecond)
+	}
+	// 最早的请求在 1 秒后移出窗口
+	result, err := limiter.Allow(ctx, "k", limit)
+	if err != nil || result.Allowed || result.RetryAfter != 700*time.Millisecond {
+		t.Fatalf("Allow() over limit = %+v, %v", result, err)
+	}
+	now = now.Add(700 * time.Millisecond)
+	if result, err := limiter.Allow(ctx, "k", limit); err != nil || !result.Allowed {
+		t.Errorf("Allow() after window slid = %+v, %v", result, err)
+	}
+	if result, err := limiter.Allow(ctx, "other", limit); err != nil || !result.Allowed {
+		t.Errorf("Allow() for another key = %+v, %v", result, err)
+	}
+}
+
+func TestGCRALimiter(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	now := time.UnixMilli(1_700_000_000_000)
+	limiter := NewGCRALimiter(rdb, "ratelimit:")
+	limiter.now = func() time.Time { return now }
+	// 每 100 毫秒一次，允许 2 次突发
+	limit := RateLimit{Limit: 10, Period: time.Second, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "k", limit)
+		if err != nil || !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("Allow() #%d = %+v, %v", i, result, err)
+		}
+	}
+	result, err := limiter.Allow(ctx, "k", limit)
+	if err != nil || result.Allowed || result.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("Allow() over burst = %+v, %v", result, err)
+	}
+	now = now.Add(100 * time.Millisecond)
+	if result, err := limiter.Allow(ctx, "k", limit); err != nil || !result.Allowed {
+		t.Errorf("Allow() after interval = %+v, %v", result, err)
+	}
+	if result, err := limiter.Allow(ctx, "k", limit); err != nil || result.Allowed {
+		t.Errorf("Allow() before next interval = %+v, %v", result, err)
+	}
+}
+
+func TestRateLimiter_Refund(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	limit := RateLimit{Limit: 2, Period: time.Minute}
+	for _, algorithm := range []string{RateLimitSlidingWindow, RateLimitGCRA} {
+		limiter, _ := NewRateLimiter(rdb, algorithm, "ratelimit:")
+		first, err := limiter.Allow(ctx, algorithm, limit)
+		if err != nil || !first.Allowed {
+			t.Fatalf("%s: Allow() = %+v, %v", algorithm, first, err)
+		}
+		_, _ = limiter.Allow(ctx, algorithm, limit)
+		if result, _ := limiter.Allow(ctx, algorithm, limit); result.Allowed {
+			t.Fatalf("%s: Allow() over limit = %+v", algorithm, result)
+		}
+
+		// 归还一次额度后可以再通过一次
+		if err := limiter.Refund(ctx, algorithm, limit, first); err != nil {
+			t.Fatalf("%s: Refund() error = %v", algorithm, err)
+		}
+		if result, err := limiter.Allow(ctx, algorithm, limit); err != nil || !result.Allowed {
+			t.Errorf("%s: Allow() after refund = %+v, %v", algorithm, result, err)
+		}
+		if result, _ := limiter.Allow(ctx, algorithm, limit); result.Allowed {
+			t.Errorf("%s: Allow() refunded more than once = %+v", algorithm, result)
+		}
+	}
+}
+
+func TestRateLimiter_ServerTime(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	now := time.UnixMilli(1_700_000_000_000)
+	mr.SetTime(now)
+	limit := RateLimit{Limit: 1, Period: time.Second}
+	for _, algorithm := range []string{RateLimitSlidingWindow, RateLimitGCRA} {
+		// 未设置 now 时脚本使用 Redis 服务端时间，只有服务端时间前进才恢复额度
+		limiter, _ := NewRateLimiter(rdb, algorithm, "ratelimit:")
+		if result, err := limiter.Allow(ctx, algorithm, limit); err != nil || !result.Allowed {
+			t.Fatalf("%s: Allow() = %+v, %v", algorithm, result, err)
+		}
+		result, err := limiter.Allow(ctx, algorithm, limit)
+		if err != nil || result.Allowed || result.RetryAfter != time.Second {
+			t.Fatalf("%s: Allow() over limit = %+v, %v", algorithm, result, err)
+		}
+	}
+	mr.SetTime(now.Add(time.Second))
+	for _, algorithm := range []string{RateLimitSlidingWindow, RateLimitGCRA} {
+		limiter, _ := NewRateLimiter(rdb, algorithm, "ratelimit:")
+		if result, err := limiter.Allow(ctx, algorithm, limit); err != nil || !result.Allowed {
+			t.Errorf("%s: Allow() after server time advanced = %+v, %v", algorithm, result, err)
+		}
+	}
+}
+
+type fakeServerTransportStream struct {
+	header metadata.MD
+}
+
+func (s *fakeServerTransportStream) Method() string { return "" }
+
+func (s *fakeServerTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *fakeServerTransportStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *fakeServerTransportStream) SetTrailer(md metadata.MD) error { return nil }
+
+func TestRateLimitInterceptor(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	conf := viper.New()
+	conf.Set("rate_limit", map[string]any{
+		"enabled":   true,
+		"algorithm": "sliding_window",
+		"rules": []map[string]any{
+			{"method": "/user.UserService/Login", "by": "ip", "limit": 2, "period": "1m"},
+			{"method": "*", "by": "user", "limit": 1, "period": "1m", "algorithm": "gcra"},
+		},
+	})
+	jwt := &JWT{JwtIssuer: "tx-demo", JwtKey: []byte("test-key")}
+	interceptor, err := NewRateLimitInterceptor(rdb, zap.NewNop(), jwt, conf)
+	if err != nil {
+		t.Fatalf("NewRateLimitInterceptor() error = %v", err)
+	}
+	unary := interceptor.Unary()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	call := func(method string, ip string, token string) (*fakeServerTransportStream, error) {
+		stream := &fakeServerTransportStream{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}})
+		if token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("token", token))
+		}
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return stream, err
+	}
+
+	// 按 IP 限流，不同 IP 额度独立
+	for i := 0; i < 2; i++ {
+		if _, err := call("/user.UserService/Login", "10.0.0.1", ""); err != nil {
+			t.Fatalf("Login #%d error = %v", i, err)
+		}
+	}
+	stream, err := call("/user.UserService/Login", "10.0.0.1", "")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Login over limit error = %v, want ResourceExhausted", err)
+	}
+	if got := stream.header.Get(RateLimitRetryAfterKey); len(got) != 1 || got[0] == "0" {
+		t.Errorf("retry-after = %v", got)
+	}
+	if _, err := call("/user.UserService/Login", "10.0.0.2", ""); err != nil {
+		t.Errorf("Login from another ip error = %v", err)
+	}
+
+	// 按用户限流，未登录的调用不受影响
+	token, _, err := GenerateJWT("user-1", "session-1", *jwt)
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+	if _, err := call("/user.UserService/GetUserInfo", "10.0.0.3", token); err != nil {
+		t.Fatalf("first call error = %v", err)
+	}
+	if _, err := call("/user.UserService/GetUserInfo", "10.0.0.4", token); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second call of same user error = %v, want ResourceExhausted", err)
+	}
+	if _, err := call("/user.UserService/GetUserInfo", "10.0.0.3", ""); err != nil {
+		t.Errorf("anonymous call error = %v", err)
+	}
+}
+
+func TestNewRateLimitInterceptor_InvalidRule(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	conf := viper.New()
+	conf.Set("rate_limit.rules", []map[string]any{{"method": "*", "by": "device", "limit": 1, "period": "1s"}})
+	if _, err := NewRateLimitInterceptor(rdb, zap.NewNop(), &JWT{}, conf); err == nil {
+		t.Error("NewRateLimitInterceptor() with unknown dimension succeeded")
+	}
+
+	conf = viper.New()
+	conf.Set("rate_limit.algorithm", "token_bucket")
+	if _, err := NewRateLimitInterceptor(rdb, zap.NewNop(), &JWT{}, conf); err == nil {
+		t.Error("NewRateLimitInterceptor() with unknown algorithm succeeded")
+	}
+}
+
+func newTestRateLimitInterceptor(t *testing.T, rules []map[string]any) *RateLimitInterceptor {
+	t.Helper()
+	_, rdb := newTestLockRedis(t)
+	conf := viper.New()
+	conf.Set("rate_limit", map[string]any{"enabled": true, "algorithm": "sliding_window", "rules": rules})
+	interceptor, err := NewRateLimitInterceptor(rdb, zap.NewNop(), &JWT{JwtIssuer: "tx-demo", JwtKey: []byte("test-key")}, conf)
+	if err != nil {
+		t.Fatalf("NewRateLimitInterceptor() error = %v", err)
+	}
+	return interceptor
+}
+
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}})
+}
+
+func TestRateLimitInterceptor_RefundOnReject(t *testing.T) {
+	interceptor := newTestRateLimitInterceptor(t, []map[string]any{
+		{"method": "*", "by": "method", "limit": 3, "period": "1m"},
+		{"method": "/user.UserService/Login", "by": "ip", "limit": 1, "period": "1m", "algorithm": "gcra"},
+	})
+	unary := interceptor.Unary()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	call := func(ip string) error {
+		ctx := grpc.NewContextWithServerTransportStream(peerContext(ip), &fakeServerTransportStream{})
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Login"}, handler)
+		return err
+	}
+
+	// 被 IP 规则拒绝的调用不消耗方法规则的额度
+	if err := call("10.0.0.1"); err != nil {
+		t.Fatalf("first call error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := call("10.0.0.1"); status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("repeated call #%d error = %v, want ResourceExhausted", i, err)
+		}
+	}
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3"} {
+		if err := call(ip); err != nil {
+			t.Errorf("call from %s error = %v", ip, err)
+		}
+	}
+	if err := call("10.0.0.4"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("call over method limit error = %v, want ResourceExhausted", err)
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestRateLimitInterceptor_Stream(t *testing.T) {
+	interceptor := newTestRateLimitInterceptor(t, []map[string]any{
+		{"method": "/user.UserService/RecommendUsers", "by": "ip", "limit": 1, "period": "1m"},
+	})
+	stream := interceptor.Stream()
+	var calls int
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		calls++
+		return nil
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/user.UserService/RecommendUsers", IsServerStream: true}
+
+	if err := stream(nil, &fakeServerStream{ctx: peerContext("10.0.0.1")}, info, handler); err != nil {
+		t.Fatalf("first stream error = %v", err)
+	}
+	ss := &fakeServerStream{ctx: peerContext("10.0.0.1")}
+	if err := stream(nil, ss, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second stream error = %v, want ResourceExhausted", err)
+	}
+	if calls != 1 {
+		t.Errorf("handler calls = %d, want 1", calls)
+	}
+	if got := ss.header.Get(RateLimitRetryAfterKey); len(got) != 1 || got[0] == "0" {
+		t.Errorf("retry-after = %v", got)
+	}
+	if err := stream(nil, &fakeServerStream{ctx: peerContext("10.0.0.2")}, info, handler); err != nil {
+		t.Errorf("stream from another ip error = %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Locker 互斥锁，单节点的 RedisLock 与多节点的 Redlock 都实现该接口
+type Locker interface {
+	// Lock 获取锁，成功时返回栅栏令牌
+	Lock(ctx context.Context) (int64, bool, error)
+	// Unlock 释放锁，锁在释放前已丢失时返回 ErrLockLost
+	Unlock(ctx context.Context) error
+	// Lost 返回锁丢失时关闭的 channel
+	Lost() <-chan struct{}
+}
+
+// redlockClockDriftFactor 各节点时钟漂移的估计比例，计算锁的有效期时扣除
+const redlockClockDriftFactor = 0.01
+
+// redlockAcquireScript 在单个节点上获取锁，成功时递增该节点的栅栏令牌计数器
+// ARGV[3] 大于 0 时为计数器设置过期时间（毫秒）
+var redlockAcquireScript = redis.NewScript(`
+	if redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+		local token = redis.call("INCR", KEYS[2])
+		if tonumber(ARGV[3]) > 0 then
+			redis.call("PEXPIRE", KEYS[2], ARGV[3])
+		end
+		return token
+	end
+	return 0
+`)
+
+// raiseFenceScript 将节点的栅栏令牌计数器提高到 ARGV[1]，ARGV[2] 大于 0 时刷新过期时间（毫秒）
+var raiseFenceScript = redis.NewScript(`
+	local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+	if current < tonumber(ARGV[1]) then
+		redis.call("SET", KEYS[1], ARGV[1], "KEEPTTL")
+	end
+	if tonumber(ARGV[2]) > 0 then
+		redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 1
+`)
+
+// Redlock 在多个独立 Redis 节点上加锁，多数节点获取成功才视为持有
+// 单个节点故障或主从切换丢失锁时，其余节点仍能阻止同一把锁被重复获取
+type Redlock struct {
+	nodes      []*redis.Client
+	logger     *zap.Logger
+	key        string
+	value      string
+	expiration time.Duration
+	config     LockConfig
+	quorum     int
+
+	mu sync.Mutex
+	// 是否持有锁，只在获取成功后为 true
+	held bool
+	// 自动续期，每次获取成功时重新创建
+	lease *lease
+	// 本次持有的栅栏令牌
+	token int64
+	// 本次持有的指标与追踪
+	trace *lockTrace
+}
+
+// NewRedlock 创建一个多节点分布式锁实例，nodes 应为互相独立的 Redis 实例，建议奇数个
+func NewRedlock(nodes []*redis.Client, logger *zap.Logger, key string, config LockConfig) *Redlock {
+	return &Redlock{
+		nodes:      nodes,
+		logger:     logger,
+		key:        fmt.Sprintf("%s%s", config.KeyPrefix, key),
+		value:      fmt.Sprintf("%d-%s", time.Now().UnixNano(), uuid.New().String()),
+		expiration: config.DefaultExpiration,
+		config:     config,
+		quorum:     len(nodes)/2 + 1,
+	}
+}
+
+// Lock 轮询获取锁，最多尝试 MaxRetries 次且不超过 DefaultWaitTime，成功后才启动自动续期
+// 成功时返回栅栏令牌：获取成功后会把多数节点的令牌计数器提高到该值，
+// 下一个持有者的多数节点中至少有一个与之相同，因此令牌仍单调递增
+func (l *Redlock) Lock(ctx context.Context) (int64, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held {
+		return 0, false, fmt.Errorf("lock %s already acquired", l.key)
+	}
+
+	trace := startLockTrace(ctx, "Redlock", "Lock", l.key, l.config.MetricName)
+	var token int64
+	acquired, err := pollAcquire(ctx, l.config, func() (bool, error) {
+		var err error
+		token, err = l.tryAcquire(ctx)
+		return token > 0, err
+	})
+	trace.acquireDone(ctx, acquired, err)
+	if acquired {
+		l.trace = trace
+	}
+	return token, acquired, err
+}
+
+// tryAcquire 在所有节点上尝试获取一次锁，调用方需持有 l.mu
+// 多数节点获取成功且扣除耗时和时钟漂移后仍在有效期内才算成功，否则释放已获取的节点
+func (l *Redlock) tryAcquire(ctx context.Context) (int64, error) {
+	start := time.Now()
+	results := l.eachNode(ctx, func(ctx context.Context, rdb *redis.Client) (int64, error) {
+		return redlockAcquireScript.Run(ctx, rdb, []string{l.key, l.key + fenceKeySuffix}, l.value, l.expiration.Milliseconds(), l.config.FenceTTL.Milliseconds()).Int64()
+	})
+
+	var acquired []*redis.Client
+	var token int64
+	var errs []error
+	for i, result := range results {
+		if result.err != nil {
+			errs = append(errs, result.err)
+			continue
+		}
+		if result.value > 0 {
+			acquired = append(acquired, l.nodes[i])
+			token = max(token, result.value)
+		}
+	}
+	drift := l.drift()
+	validity := l.expiration - time.Since(start) - drift
+	if len(acquired) < l.quorum || validity <= 0 {
+		l.release(ctx)
+		// 出错的节点过多，不可能达到多数
+		if len(errs) > len(l.nodes)-l.quorum {
+			return 0, fmt.Errorf("failed to acquire lock: %w", errors.Join(errs...))
+		}
+		return 0, nil
+	}
+
+	// 令牌只有在多数节点的计数器都已提高后才能保证单调递增，否则放弃这次获取
+	var raised int
+	for _, rdb := range acquired {
+		if err := raiseFenceScript.Run(ctx, rdb, []string{l.key + fenceKeySuffix}, token, l.config.FenceTTL.Milliseconds()).Err(); err != nil {
+			l.logger.Warn("failed to raise fencing token", zap.String("key", l.key), zap.Error(err))
+			errs = append(errs, err)
+			continue
+		}
+		raised++
+	}
+	if raised < l.quorum {
+		l.release(ctx)
+		return 0, fmt.Errorf("failed to raise fencing token on quorum: %w", errors.Join(errs...))
+	}
+	validity = l.expiration - time.Since(start) - drift
+	if validity <= 0 {
+		l.release(ctx)
+		return 0, nil
+	}
+
+	l.held = true
+	l.token = token
+	// 锁在各节点上只剩 validity 的有效期，续期和丢失判断都以此为准
+	l.lease = startLease(ctx, l.logger, l.key, validity, l.renew)
+	return token, nil
+}
+
+// Token 返回当前持有的栅栏令牌，未持有锁时返回 0
+func (l *Redlock) Token() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		return 0
+	}
+	return l.token
+}
+
+// Lost 返回锁丢失时关闭的 channel，未获取锁时返回 nil，Unlock 不会关闭该 channel
+func (l *Redlock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease == nil {
+		return nil
+	}
+	return l.lease.lost
+}
+
+// Context 返回在锁丢失时取消的 ctx，context.Cause 为 ErrLockLost
+func (l *Redlock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	l.mu.Lock()
+	var lost <-chan struct{}
+	if l.held {
+		lost = l.lease.lost
+	}
+	l.mu.Unlock()
+	return lostContext(parent, lost)
+}
+
+// drift 时钟漂移的估计值，计算有效期时扣除
+func (l *Redlock) drift() time.Duration {
+	return time.Duration(float64(l.expiration)*redlockClockDriftFactor) + 2*time.Millisecond
+}
+
+// renew 在所有节点上续期，多数节点续期成功且扣除耗时和时钟漂移后仍在有效期内才算成功
+// 仍持有锁的节点已不足多数，或续期耗时超出有效期时返回 ErrLockLost
+func (l *Redlock) renew(ctx context.Context) error {
+	start := time.Now()
+	results := l.eachNode(ctx, func(ctx context.Context, rdb *redis.Client) (int64, error) {
+		return renewScript.Run(ctx, rdb, []string{l.key}, l.value, l.expiration.Milliseconds()).Int64()
+	})
+
+	renewed, lost := 0, 0
+	var errs []error
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			errs = append(errs, result.err)
+		case result.value == 0:
+			lost++
+		default:
+			renewed++
+		}
+	}
+	if renewed >= l.quorum {
+		// 与获取时一致，各节点从收到续期请求时开始计时，扣除耗时和漂移后没有剩余有效期时锁已不可靠
+		if l.expiration-time.Since(start)-l.drift() <= 0 {
+			return ErrLockLost
+		}
+		return nil
+	}
+	if len(l.nodes)-lost < l.quorum {
+		return ErrLockLost
+	}
+	return fmt.Errorf("failed to renew lock on %d of %d nodes: %w", len(l.nodes)-renewed, len(l.nodes), errors.Join(errs...))
+}
+
+// Unlock 在所有节点上释放锁，可重复调用，未持有锁时直接返回
+// 释放前仍持有锁的节点不足多数时返回 ErrLockLost
+func (l *Redlock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		return nil
+	}
+	l.held = false
+
+	l.lease.stop()
+
+	released, errs := l.release(ctx)
+	if released >= l.quorum {
+		l.trace.released(nil)
+		return nil
+	}
+	if len(errs) > 0 {
+		err := fmt.Errorf("failed to unlock: %w", errors.Join(errs...))
+		l.trace.released(err)
+		return err
+	}
+	l.trace.released(ErrLockLost)
+	return ErrLockLost
+}
+
+// release 在所有节点上删除自己持有的锁，包括未获取成功的节点，返回实际删除的节点数
+func (l *Redlock) release(ctx context.Context) (int, []error) {
+	results := l.eachNode(context.WithoutCancel(ctx), func(ctx context.Context, rdb *redis.Client) (int64, error) {
+		return unlockScript.Run(ctx, rdb, []string{l.key}, l.value).Int64()
+	})
+
+	released := 0
+	var errs []error
+	for _, result := range results {
+		if result.err != nil {
+			errs = append(errs, result.err)
+		} else if result.value > 0 {
+			released++
+		}
+	}
+	return released, errs
+}
+
+type nodeResult struct {
+	value int64
+	err   error
+}
+
+// eachNode 并发地在每个节点上执行 fn，单个节点的超时为锁过期时间的 1/10，避免故障节点拖住整个请求
+func (l *Redlock) eachNode(ctx context.Context, fn func(ctx context.Context, rdb *redis.Client) (int64, error)) []nodeResult {
+	results := make([]nodeResult, len(l.nodes))
+	var wg sync.WaitGroup
+	for i, rdb := range l.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, l.expiration/10)
+			defer cancel()
+			value, err := fn(nodeCtx, rdb)
+			results[i] = nodeResult{value: value, err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func newTestLockNodes(t *testing.T, n int) ([]*miniredis.Miniredis, []*redis.Client) {
+	t.Helper()
+	var servers []*miniredis.Miniredis
+	var nodes []*redis.Client
+	for i := 0; i < n; i++ {
+		mr, rdb := newTestLockRedis(t)
+		servers = append(servers, mr)
+		nodes = append(nodes, rdb)
+	}
+	return servers, nodes
+}
+
+func TestRedlock_Quorum(t *testing.T) {
+	servers, nodes := newTestLockNodes(t, 3)
+	ctx := context.Background()
+	// 少数节点故障时仍能获取
+	servers[2].Close()
+
+	a := NewRedlock(nodes, zap.NewNop(), "order", testLockConfig)
+	b := NewRedlock(nodes, zap.NewNop(), "order", testLockConfig)
+	if _, ok, err := a.Lock(ctx); err != nil || !ok {
+		t.Fatalf("a.Lock() = %v, %v", ok, err)
+	}
+	if _, ok, err := b.Lock(ctx); err != nil || ok {
+		t.Fatalf("b.Lock() = %v, %v, want not acquired", ok, err)
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatalf("a.Unlock() error = %v", err)
+	}
+	for i, mr := range servers[:2] {
+		if mr.Exists("lock:order") {
+			t.Errorf("node %d still holds the lock after Unlock", i)
+		}
+	}
+
+	// 多数节点故障时返回错误
+	servers[1].Close()
+	if _, ok, err := b.Lock(ctx); err == nil || ok {
+		t.Errorf("Lock() with majority down = %v, %v, want error", ok, err)
+	}
+}
+
+func TestRedlock_MinorityReleased(t *testing.T) {
+	servers, nodes := newTestLockNodes(t, 3)
+	ctx := context.Background()
+	// 其他持有者占据多数节点时获取失败，且不残留在剩余节点上
+	_ = servers[0].Set("lock:order", "other")
+	_ = servers[1].Set("lock:order", "other")
+
+	l := NewRedlock(nodes, zap.NewNop(), "order", testLockConfig)
+	if _, ok, err := l.Lock(ctx); err != nil || ok {
+		t.Fatalf("Lock() = %v, %v, want not acquired", ok, err)
+	}
+	if servers[2].Exists("lock:order") {
+		t.Errorf("lock left on minority node after failed Lock")
+	}
+
+	servers[1].Del("lock:order")
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() with majority free = %v, %v", ok, err)
+	}
+	if got, _ := servers[0].Get("lock:order"); got != "other" {
+		t.Errorf("lock of other owner overwritten: %q", got)
+	}
+	_ = l.Unlock(ctx)
+}
+
+func TestRedlock_FencingToken(t *testing.T) {
+	servers, nodes := newTestLockNodes(t, 3)
+	ctx := context.Background()
+	_ = servers[0].Set("lock:order:fence", "10")
+
+	a := NewRedlock(nodes, zap.NewNop(), "order", testLockConfig)
+	first, ok, err := a.Lock(ctx)
+	if err != nil || !ok {
+		t.Fatalf("a.Lock() = %v, %v", ok, err)
+	}
+	_ = a.Unlock(ctx)
+
+	// 多数节点的计数器已提高到令牌值，换一组多数节点获取时令牌仍递增
+	servers[0].Close()
+	b := NewRedlock(nodes, zap.NewNop(), "order", testLockConfig)
+	second, ok, err := b.Lock(ctx)
+	if err != nil || !ok {
+		t.Fatalf("b.Lock() = %v, %v", ok, err)
+	}
+	if second <= first {
+		t.Errorf("fencing token = %d after %d, want increasing", second, first)
+	}
+	_ = b.Unlock(ctx)
+}
+
+// failRaiseFenceHook 让节点上提高栅栏令牌的脚本执行失败
+type failRaiseFenceHook struct{}
+
+func (failRaiseFenceHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (failRaiseFenceHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if args := cmd.Args(); cmd.Name() == "evalsha" && len(args) > 1 && args[1] == raiseFenceScript.Hash() {
+			err := errors.New("raise fence failed")
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (failRaiseFenceHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRedlock_FenceRaiseQuorum(t *testing.T) {
+	servers, nodes := newTestLockNodes(t, 3)
+	ctx := context.Background()
+	nodes[0].AddHook(failRaiseFenceHook{})
+	nodes[1].AddHook(failRaiseFenceHook{})
+
+	// 多数节点的令牌计数器未能提高时释放锁并返回错误
+	l := NewRedlock(nodes, zap.NewNop(), "order", testLockConfig)
+	if token, ok, err := l.Lock(ctx); err == nil || ok || token != 0 {
+		t.Fatalf("Lock() = %d, %v, %v, want error", token, ok, err)
+	}
+	for i, mr := range servers {
+		if mr.Exists("lock:order") {
+			t.Errorf("node %d still holds the lock after failed fence raise", i)
+		}
+	}
+	if l.Token() != 0 {
+		t.Errorf("Token() = %d, want 0", l.Token())
+	}
+}
+
+// slowRenewHook 让节点上的续期脚本延迟 delay 后执行，并忽略单个节点的超时，模拟慢但成功的续期
+type slowRenewHook struct {
+	delay time.Duration
+}
+
+func (h slowRenewHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h slowRenewHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if args := cmd.Args(); cmd.Name() == "evalsha" && len(args) > 1 && args[1] == renewScript.Hash() {
+			time.Sleep(h.delay)
+			return next(context.WithoutCancel(ctx), cmd)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h slowRenewHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRedlock_RenewValidity(t *testing.T) {
+	_, nodes := newTestLockNodes(t, 3)
+	ctx := context.Background()
+	l := NewRedlock(nodes, zap.NewNop(), "order", testLockConfig)
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	defer l.Unlock(ctx)
+	if err := l.renew(ctx); err != nil {
+		t.Fatalf("renew() error = %v", err)
+	}
+
+	// 多数节点续期成功，但耗时超出有效期时视为锁丢失
+	l.expiration = 20 * time.Millisecond
+	for _, rdb := range nodes {
+		rdb.AddHook(slowRenewHook{delay: 30 * time.Millisecond})
+	}
+	if err := l.renew(ctx); !errors.Is(err, ErrLockLost) {
+		t.Errorf("slow renew() error = %v, want ErrLockLost", err)
+	}
+}
+
+func TestRedlock_Lost(t *testing.T) {
+	servers, nodes := newTestLockNodes(t, 3)
+	ctx := context.Background()
+	config := testLockConfig
+	config.DefaultExpiration = 150 * time.Millisecond
+
+	l := NewRedlock(nodes, zap.NewNop(), "order", config)
+	if _, ok, err := l.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	servers[0].Del("lock:order")
+	servers[1].Del("lock:order")
+
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Lost() not closed after majority of nodes lost the lock")
+	}
+	if err := l.Unlock(ctx); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Unlock() error = %v, want ErrLockLost", err)
+	}
+}
@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// reentrantAcquireScript 锁不存在或由同一持有者持有时，持有者计数加一并重置过期时间，返回计数
+// 锁 key 为 hash，字段为持有者，值为重入次数，同一个 key 不能与 RedisLock 混用
+var reentrantAcquireScript = redis.NewScript(`
+	if redis.call("EXISTS", KEYS[1]) == 0 or redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+		local count = redis.call("HINCRBY", KEYS[1], ARGV[1], 1)
+		redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		return count
+	end
+	return 0
+`)
+
+// reentrantReleaseScript 持有者计数减一，减到 0 时删除锁，返回剩余计数，未持有时返回 -1
+var reentrantReleaseScript = redis.NewScript(`
+	if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+		return -1
+	end
+	local count = redis.call("HINCRBY", KEYS[1], ARGV[1], -1)
+	if count <= 0 then
+		redis.call("DEL", KEYS[1])
+		return 0
+	end
+	return count
+`)
+
+// reentrantRenewScript 仅当持有者仍持有锁时续期
+var reentrantRenewScript = redis.NewScript(`
+	if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+type lockOwnerKey struct{}
+
+// WithLockOwner 在 ctx 中携带可重入锁的持有者标识，同一持有者可以重复获取同一把锁
+func WithLockOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, lockOwnerKey{}, owner)
+}
+
+// LockOwnerFromContext 获取 ctx 中的持有者标识
+func LockOwnerFromContext(ctx context.Context) (string, bool) {
+	owner, ok := ctx.Value(lockOwnerKey{}).(string)
+	return owner, ok && owner != ""
+}
+
+// ReentrantLock 可重入分布式锁，持有者标识通过 ctx 传递
+// 嵌套调用时各自创建 ReentrantLock 并传入外层返回的 ctx 即可重入，每次 Lock 对应一次 Unlock
+type ReentrantLock struct {
+	rdb        *redis.Client
+	logger     *zap.Logger
+	key        string
+	expiration time.Duration
+	config     LockConfig
+
+	mu sync.Mutex
+	// 本实例获取的次数和持有者，每个实例在持有期间独立续期
+	holds int
+	owner string
+	lease *lease
+	trace *lockTrace
+}
+
+// NewReentrantLock 创建可重入锁实例
+func NewReentrantLock(rdb *redis.Client, logger *zap.Logger, key string, config LockConfig) *ReentrantLock {
+	return &ReentrantLock{
+		rdb:        rdb,
+		logger:     logger,
+		key:        fmt.Sprintf("%s%s", config.KeyPrefix, key),
+		expiration: config.DefaultExpiration,
+		config:     config,
+	}
+}
+
+// Lock 获取锁，ctx 中没有持有者标识时生成一个
+// 返回携带持有者标识的 ctx，嵌套调用使用该 ctx 获取同一把锁时直接重入
+func (l *ReentrantLock) Lock(ctx context.Context) (context.Context, bool, error) {
+	owner, ok := LockOwnerFromContext(ctx)
+	if !ok {
+		owner = uuid.New().String()
+		ctx = WithLockOwner(ctx, owner)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holds > 0 && l.owner != owner {
+		return ctx, false, fmt.Errorf("lock %s already acquired by another owner on this instance", l.key)
+	}
+
+	var trace *lockTrace
+	if l.holds == 0 {
+		trace = startLockTrace(ctx, "ReentrantLock", "Lock", l.key, l.config.MetricName)
+	}
+	acquired, err := pollAcquire(ctx, l.config, func() (bool, error) {
+		count, err := reentrantAcquireScript.Run(ctx, l.rdb, []string{l.key}, owner, l.expiration.Milliseconds()).Int64()
+		if err != nil {
+			return false, fmt.Errorf("failed to acquire lock: %w", err)
+		}
+		return count > 0, nil
+	})
+	if trace != nil {
+		trace.acquireDone(ctx, acquired, err)
+	}
+	if err != nil || !acquired {
+		return ctx, false, err
+	}
+	if l.holds == 0 {
+		l.owner = owner
+		l.lease = startLease(ctx, l.logger, l.key, l.expiration, l.renew)
+		l.trace = trace
+	}
+	l.holds++
+	return ctx, true, nil
+}
+
+func (l *ReentrantLock) renew(ctx context.Context) error {
+	result, err := reentrantRenewScript.Run(ctx, l.rdb, []string{l.key}, l.owner, l.expiration.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to renew lock: %w", err)
+	}
+	if result == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Unlock 释放一次本实例获取的锁，重入计数减到 0 时删除锁，本实例未持有时直接返回
+// 锁在释放前已过期或被他人获取时返回 ErrLockLost
+func (l *ReentrantLock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holds == 0 {
+		return nil
+	}
+	l.holds--
+	if l.holds == 0 {
+		l.lease.stop()
+	}
+
+	// 最外层释放时才结束持有时长的统计
+	trace := l.trace
+	if l.holds > 0 {
+		trace = nil
+	}
+	result, err := reentrantReleaseScript.Run(ctx, l.rdb, []string{l.key}, l.owner).Int64()
+	if err != nil {
+		trace.released(err)
+		return fmt.Errorf("failed to unlock: %w", err)
+	}
+	if result < 0 {
+		trace.released(ErrLockLost)
+		return ErrLockLost
+	}
+	trace.released(nil)
+	return nil
+}
+
+// Lost 返回锁丢失时关闭的 channel，未获取锁时返回 nil
+func (l *ReentrantLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease == nil {
+		return nil
+	}
+	return l.lease.lost
+}
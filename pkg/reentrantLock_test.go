@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestReentrantLock_Nested(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	outer := NewReentrantLock(rdb, zap.NewNop(), "order", testLockConfig)
+	ctx, ok, err := outer.Lock(context.Background())
+	if err != nil || !ok {
+		t.Fatalf("outer.Lock() = %v, %v", ok, err)
+	}
+	if _, has := LockOwnerFromContext(ctx); !has {
+		t.Fatalf("Lock() did not attach owner to ctx")
+	}
+
+	// 嵌套调用携带同一持有者，直接重入
+	inner := NewReentrantLock(rdb, zap.NewNop(), "order", testLockConfig)
+	if _, ok, err := inner.Lock(ctx); err != nil || !ok {
+		t.Fatalf("inner.Lock() = %v, %v", ok, err)
+	}
+	other := NewReentrantLock(rdb, zap.NewNop(), "order", testLockConfig)
+	if _, ok, err := other.Lock(context.Background()); err != nil || ok {
+		t.Fatalf("other.Lock() = %v, %v, want not acquired", ok, err)
+	}
+
+	// 内层释放后外层仍持有
+	if err := inner.Unlock(ctx); err != nil {
+		t.Fatalf("inner.Unlock() error = %v", err)
+	}
+	if !mr.Exists("lock:order") {
+		t.Fatalf("lock released by inner unlock")
+	}
+	if err := outer.Unlock(ctx); err != nil {
+		t.Fatalf("outer.Unlock() error = %v", err)
+	}
+	if mr.Exists("lock:order") {
+		t.Fatalf("lock still exists after outer unlock")
+	}
+	if _, ok, err := other.Lock(context.Background()); err != nil || !ok {
+		t.Fatalf("other.Lock() after release = %v, %v", ok, err)
+	}
+	_ = other.Unlock(context.Background())
+}
+
+func TestReentrantLock_SameInstance(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	l := NewReentrantLock(rdb, zap.NewNop(), "order", testLockConfig)
+	ctx := WithLockOwner(context.Background(), "request-1")
+
+	for i := 0; i < 2; i++ {
+		if _, ok, err := l.Lock(ctx); err != nil || !ok {
+			t.Fatalf("Lock() #%d = %v, %v", i, ok, err)
+		}
+	}
+	if got := mr.HGet("lock:order", "request-1"); got != "2" {
+		t.Fatalf("hold count = %s, want 2", got)
+	}
+	// 不同持有者不能通过同一实例获取
+	if _, _, err := l.Lock(WithLockOwner(context.Background(), "request-2")); err == nil {
+		t.Errorf("Lock() with another owner on the same instance succeeded")
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := l.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock() #%d error = %v", i, err)
+		}
+	}
+	if mr.Exists("lock:order") {
+		t.Errorf("lock still exists after unlocking every hold")
+	}
+}
+
+func TestReentrantLock_Expiry(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	a := NewReentrantLock(rdb, zap.NewNop(), "order", testLockConfig)
+	ctx, ok, err := a.Lock(context.Background())
+	if err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	mr.FastForward(testLockConfig.DefaultExpiration)
+
+	b := NewReentrantLock(rdb, zap.NewNop(), "order", testLockConfig)
+	if _, ok, err := b.Lock(context.Background()); err != nil || !ok {
+		t.Fatalf("b.Lock() after expiry = %v, %v", ok, err)
+	}
+	if err := a.Unlock(ctx); !errors.Is(err, ErrLockLost) {
+		t.Errorf("a.Unlock() error = %v, want ErrLockLost", err)
+	}
+	if !mr.Exists("lock:order") {
+		t.Errorf("b's lock deleted by a")
+	}
+	_ = b.Unlock(context.Background())
+}
@@ -0,0 +1,248 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// 读写锁的 key 后缀，读者各自持有租约，进程退出后单个读者的租约自动过期
+const (
+	// rwWriterKeySuffix 写者（string），值为写者标识
+	rwWriterKeySuffix = ":write"
+	// rwReadersKeySuffix 读者（zset），分数为租约过期时间（毫秒）
+	rwReadersKeySuffix = ":readers"
+	// rwIntentKeySuffix 写优先时等待中的写者，存在期间新读者不能获取
+	rwIntentKeySuffix = ":write_intent"
+)
+
+// rLockScript 没有写者（写优先时也没有等待中的写者）时加入读者
+var rLockScript = redis.NewScript(`
+	local now = tonumber(ARGV[2])
+	redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", now)
+	if redis.call("EXISTS", KEYS[1]) == 1 then
+		return 0
+	end
+	if ARGV[4] == "1" and redis.call("EXISTS", KEYS[3]) == 1 then
+		return 0
+	end
+	redis.call("ZADD", KEYS[2], now + tonumber(ARGV[3]), ARGV[1])
+	redis.call("PEXPIRE", KEYS[2], ARGV[3])
+	return 1
+`)
+
+// wLockScript 没有写者和有效读者时获取写锁；写优先时未获取的写者登记等待意向
+var wLockScript = redis.NewScript(`
+	local now = tonumber(ARGV[2])
+	redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", now)
+	if redis.call("EXISTS", KEYS[1]) == 0 and redis.call("ZCARD", KEYS[2]) == 0 then
+		redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[3])
+		if redis.call("GET", KEYS[3]) == ARGV[1] then
+			redis.call("DEL", KEYS[3])
+		end
+		return 1
+	end
+	if ARGV[4] == "1" then
+		local intent = redis.call("GET", KEYS[3])
+		if not intent or intent == ARGV[1] then
+			redis.call("SET", KEYS[3], ARGV[1], "PX", ARGV[5])
+		end
+	end
+	return 0
+`)
+
+// rRenewScript 租约未过期时续期，读者和信号量许可共用
+var rRenewScript = redis.NewScript(`
+	local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+	if not score or tonumber(score) <= tonumber(ARGV[2]) then
+		return 0
+	end
+	redis.call("ZADD", KEYS[1], "XX", tonumber(ARGV[2]) + tonumber(ARGV[3]), ARGV[1])
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+	return 1
+`)
+
+// cancelIntentScript 写者放弃等待时撤销自己的等待意向
+var cancelIntentScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+// RedisRWLock 分布式读写锁，允许多个读者或一个写者
+// 每个实例同一时间只持有读锁或写锁之一，并发的读者各自创建实例
+type RedisRWLock struct {
+	rdb             *redis.Client
+	logger          *zap.Logger
+	key             string
+	id              string
+	expiration      time.Duration
+	config          LockConfig
+	writerPreferred bool
+
+	mu sync.Mutex
+	// 当前持有的模式：空 / read / write
+	mode  string
+	lease *lease
+	trace *lockTrace
+}
+
+// NewRedisRWLock 创建读写锁实例
+// writerPreferred 为 true 时，有写者等待期间新的读者不能获取，避免写者饥饿
+func NewRedisRWLock(rdb *redis.Client, logger *zap.Logger, key string, config LockConfig, writerPreferred bool) *RedisRWLock {
+	return &RedisRWLock{
+		rdb:             rdb,
+		logger:          logger,
+		key:             fmt.Sprintf("%s%s", config.KeyPrefix, key),
+		id:              uuid.New().String(),
+		expiration:      config.DefaultExpiration,
+		config:          config,
+		writerPreferred: writerPreferred,
+	}
+}
+
+func (l *RedisRWLock) keys() []string {
+	return []string{l.key + rwWriterKeySuffix, l.key + rwReadersKeySuffix, l.key + rwIntentKeySuffix}
+}
+
+func (l *RedisRWLock) preferFlag() string {
+	if l.writerPreferred {
+		return "1"
+	}
+	return "0"
+}
+
+// RLock 获取读锁
+func (l *RedisRWLock) RLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mode != "" {
+		return false, fmt.Errorf("rwlock %s already acquired", l.key)
+	}
+
+	trace := startLockTrace(ctx, "RedisRWLock", "RLock", l.key, l.config.MetricName)
+	acquired, err := pollAcquire(ctx, l.config, func() (bool, error) {
+		result, err := rLockScript.Run(ctx, l.rdb, l.keys(), l.id, time.Now().UnixMilli(), l.expiration.Milliseconds(), l.preferFlag()).Int64()
+		if err != nil {
+			return false, fmt.Errorf("failed to acquire read lock: %w", err)
+		}
+		return result == 1, nil
+	})
+	trace.acquireDone(ctx, acquired, err)
+	if err != nil || !acquired {
+		return false, err
+	}
+	l.mode = "read"
+	l.trace = trace
+	l.lease = startLease(ctx, l.logger, l.key, l.expiration, l.renewRead)
+	return true, nil
+}
+
+// Lock 获取写锁，写优先时放弃等待会撤销等待意向
+func (l *RedisRWLock) Lock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mode != "" {
+		return false, fmt.Errorf("rwlock %s already acquired", l.key)
+	}
+
+	trace := startLockTrace(ctx, "RedisRWLock", "Lock", l.key, l.config.MetricName)
+	acquired, err := pollAcquire(ctx, l.config, func() (bool, error) {
+		result, err := wLockScript.Run(ctx, l.rdb, l.keys(), l.id, time.Now().UnixMilli(), l.expiration.Milliseconds(), l.preferFlag(), lockWaiterTTL.Milliseconds()).Int64()
+		if err != nil {
+			return false, fmt.Errorf("failed to acquire write lock: %w", err)
+		}
+		return result == 1, nil
+	})
+	trace.acquireDone(ctx, acquired, err)
+	if err != nil || !acquired {
+		if l.writerPreferred {
+			cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+			defer cancel()
+			if err := cancelIntentScript.Run(cancelCtx, l.rdb, []string{l.key + rwIntentKeySuffix}, l.id).Err(); err != nil {
+				l.logger.Warn("failed to cancel write intent", zap.String("key", l.key), zap.Error(err))
+			}
+		}
+		return false, err
+	}
+	l.mode = "write"
+	l.trace = trace
+	l.lease = startLease(ctx, l.logger, l.key, l.expiration, l.renewWrite)
+	return true, nil
+}
+
+func (l *RedisRWLock) renewRead(ctx context.Context) error {
+	result, err := rRenewScript.Run(ctx, l.rdb, []string{l.key + rwReadersKeySuffix}, l.id, time.Now().UnixMilli(), l.expiration.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to renew read lock: %w", err)
+	}
+	if result == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (l *RedisRWLock) renewWrite(ctx context.Context) error {
+	result, err := renewScript.Run(ctx, l.rdb, []string{l.key + rwWriterKeySuffix}, l.id, l.expiration.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to renew write lock: %w", err)
+	}
+	if result == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Unlock 释放当前持有的读锁或写锁，可重复调用，未持有时直接返回
+// 锁在释放前已过期或被他人获取时返回 ErrLockLost
+func (l *RedisRWLock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	mode := l.mode
+	if mode == "" {
+		return nil
+	}
+	l.mode = ""
+	l.lease.stop()
+
+	var (
+		result int64
+		err    error
+	)
+	if mode == "read" {
+		result, err = l.rdb.ZRem(ctx, l.key+rwReadersKeySuffix, l.id).Result()
+	} else {
+		result, err = unlockScript.Run(ctx, l.rdb, []string{l.key + rwWriterKeySuffix}, l.id).Int64()
+	}
+	if err != nil {
+		l.trace.released(err)
+		return fmt.Errorf("failed to unlock: %w", err)
+	}
+	if result == 0 {
+		l.trace.released(ErrLockLost)
+		return ErrLockLost
+	}
+	l.trace.released(nil)
+	return nil
+}
+
+// RUnlock 释放读锁，等同于 Unlock
+func (l *RedisRWLock) RUnlock(ctx context.Context) error {
+	return l.Unlock(ctx)
+}
+
+// Lost 返回锁丢失时关闭的 channel，未获取锁时返回 nil
+func (l *RedisRWLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease == nil {
+		return nil
+	}
+	return l.lease.lost
+}
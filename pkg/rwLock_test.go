@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRedisRWLock_ReadersAndWriter(t *testing.T) {
+	_, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	r1 := NewRedisRWLock(rdb, zap.NewNop(), "doc", testLockConfig, false)
+	r2 := NewRedisRWLock(rdb, zap.NewNop(), "doc", testLockConfig, false)
+	w := NewRedisRWLock(rdb, zap.NewNop(), "doc", testLockConfig, false)
+
+	for _, r := range []*RedisRWLock{r1, r2} {
+		if ok, err := r.RLock(ctx); err != nil || !ok {
+			t.Fatalf("RLock() = %v, %v", ok, err)
+		}
+	}
+	if ok, err := w.Lock(ctx); err != nil || ok {
+		t.Fatalf("Lock() with readers = %v, %v, want not acquired", ok, err)
+	}
+
+	_ = r1.RUnlock(ctx)
+	_ = r2.RUnlock(ctx)
+	if ok, err := w.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Lock() after readers released = %v, %v", ok, err)
+	}
+	if ok, err := r1.RLock(ctx); err != nil || ok {
+		t.Fatalf("RLock() with writer = %v, %v, want not acquired", ok, err)
+	}
+	if err := w.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if err := w.Unlock(ctx); err != nil {
+		t.Errorf("second Unlock() error = %v", err)
+	}
+	if ok, err := r1.RLock(ctx); err != nil || !ok {
+		t.Fatalf("RLock() after writer released = %v, %v", ok, err)
+	}
+	_ = r1.RUnlock(ctx)
+}
+
+func TestRedisRWLock_WriterPreference(t *testing.T) {
+	for _, preferred := range []bool{true, false} {
+		_, rdb := newTestLockRedis(t)
+		ctx := context.Background()
+		reader := NewRedisRWLock(rdb, zap.NewNop(), "doc", testLockConfig, preferred)
+		if ok, err := reader.RLock(ctx); err != nil || !ok {
+			t.Fatalf("RLock() = %v, %v", ok, err)
+		}
+
+		// 写者在后台等待读者释放
+		waitConfig := testLockConfig
+		waitConfig.DefaultWaitTime = 2 * time.Second
+		waitConfig.MaxRetries = 1000
+		writer := NewRedisRWLock(rdb, zap.NewNop(), "doc", waitConfig, preferred)
+		done := make(chan bool)
+		go func() {
+			ok, _ := writer.Lock(ctx)
+			done <- ok
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		late := NewRedisRWLock(rdb, zap.NewNop(), "doc", testLockConfig, preferred)
+		ok, err := late.RLock(ctx)
+		if err != nil {
+			t.Fatalf("RLock() error = %v", err)
+		}
+		if ok == preferred {
+			t.Errorf("writerPreferred=%v: late reader acquired = %v", preferred, ok)
+		}
+		_ = late.RUnlock(ctx)
+		_ = reader.RUnlock(ctx)
+		if !<-done {
+			t.Errorf("writerPreferred=%v: writer not acquired after readers released", preferred)
+		}
+		_ = writer.Unlock(ctx)
+	}
+}
+
+func TestRedisRWLock_ExpiredReader(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	// 已退出的读者租约过期后不再阻塞写者
+	_, _ = mr.ZAdd("lock:doc:readers", float64(time.Now().Add(-time.Second).UnixMilli()), "crashed")
+	w := NewRedisRWLock(rdb, zap.NewNop(), "doc", testLockConfig, true)
+	if ok, err := w.Lock(context.Background()); err != nil || !ok {
+		t.Fatalf("Lock() = %v, %v", ok, err)
+	}
+	_ = w.Unlock(context.Background())
+}
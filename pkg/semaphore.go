@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// semaphoreAcquireScript 清理过期租约后，有空闲许可时登记新的租约
+// 信号量 key 为 zset，成员为许可标识，分数为租约过期时间（毫秒）
+var semaphoreAcquireScript = redis.NewScript(`
+	local now = tonumber(ARGV[2])
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+	if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[4]) then
+		return 0
+	end
+	redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+	return 1
+`)
+
+// RedisSemaphore 分布式计数信号量，最多 permits 个持有者，每个许可独立续期
+// 同一个 key 的所有使用方需使用相同的 permits
+type RedisSemaphore struct {
+	rdb        *redis.Client
+	logger     *zap.Logger
+	key        string
+	permits    int
+	expiration time.Duration
+	config     LockConfig
+}
+
+// NewRedisSemaphore 创建信号量
+func NewRedisSemaphore(rdb *redis.Client, logger *zap.Logger, key string, permits int, config LockConfig) *RedisSemaphore {
+	return &RedisSemaphore{
+		rdb:        rdb,
+		logger:     logger,
+		key:        fmt.Sprintf("%s%s", config.KeyPrefix, key),
+		permits:    permits,
+		expiration: config.DefaultExpiration,
+		config:     config,
+	}
+}
+
+// SemaphorePermit 获取到的一个许可，持有期间自动续期
+type SemaphorePermit struct {
+	sem   *RedisSemaphore
+	id    string
+	lease *lease
+	trace *lockTrace
+
+	mu       sync.Mutex
+	released bool
+}
+
+// Acquire 获取一个许可，没有空闲许可时按 LockConfig 轮询等待，超时返回 nil
+func (s *RedisSemaphore) Acquire(ctx context.Context) (*SemaphorePermit, error) {
+	id := uuid.New().String()
+	trace := startLockTrace(ctx, "RedisSemaphore", "Acquire", s.key, s.config.MetricName)
+	acquired, err := pollAcquire(ctx, s.config, func() (bool, error) {
+		result, err := semaphoreAcquireScript.Run(ctx, s.rdb, []string{s.key}, id, time.Now().UnixMilli(), s.expiration.Milliseconds(), s.permits).Int64()
+		if err != nil {
+			return false, fmt.Errorf("failed to acquire semaphore: %w", err)
+		}
+		return result == 1, nil
+	})
+	trace.acquireDone(ctx, acquired, err)
+	if err != nil || !acquired {
+		return nil, err
+	}
+
+	permit := &SemaphorePermit{sem: s, id: id, trace: trace}
+	permit.lease = startLease(ctx, s.logger, s.key, s.expiration, permit.renew)
+	return permit, nil
+}
+
+// Available 返回当前空闲的许可数
+func (s *RedisSemaphore) Available(ctx context.Context) (int, error) {
+	held, err := s.rdb.ZCount(ctx, s.key, fmt.Sprint(time.Now().UnixMilli()), "+inf").Result()
+	if err != nil {
+		return 0, err
+	}
+	if available := s.permits - int(held); available > 0 {
+		return available, nil
+	}
+	return 0, nil
+}
+
+func (p *SemaphorePermit) renew(ctx context.Context) error {
+	result, err := rRenewScript.Run(ctx, p.sem.rdb, []string{p.sem.key}, p.id, time.Now().UnixMilli(), p.sem.expiration.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to renew semaphore permit: %w", err)
+	}
+	if result == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release 归还许可，可重复调用；许可在归还前已过期时返回 ErrLockLost
+func (p *SemaphorePermit) Release(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.released {
+		return nil
+	}
+	p.released = true
+	p.lease.stop()
+
+	removed, err := p.sem.rdb.ZRem(ctx, p.sem.key, p.id).Result()
+	if err != nil {
+		p.trace.released(err)
+		return fmt.Errorf("failed to release semaphore permit: %w", err)
+	}
+	if removed == 0 {
+		p.trace.released(ErrLockLost)
+		return ErrLockLost
+	}
+	p.trace.released(nil)
+	return nil
+}
+
+// Lost 返回许可租约丢失时关闭的 channel
+func (p *SemaphorePermit) Lost() <-chan struct{} {
+	return p.lease.lost
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRedisSemaphore(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	ctx := context.Background()
+	sem := NewRedisSemaphore(rdb, zap.NewNop(), "export", 2, testLockConfig)
+
+	p1, err := sem.Acquire(ctx)
+	if err != nil || p1 == nil {
+		t.Fatalf("Acquire() = %v, %v", p1, err)
+	}
+	p2, err := sem.Acquire(ctx)
+	if err != nil || p2 == nil {
+		t.Fatalf("Acquire() = %v, %v", p2, err)
+	}
+	if p3, err := sem.Acquire(ctx); err != nil || p3 != nil {
+		t.Fatalf("Acquire() without free permits = %v, %v, want nil", p3, err)
+	}
+	if available, _ := sem.Available(ctx); available != 0 {
+		t.Errorf("Available() = %d, want 0", available)
+	}
+
+	if err := p1.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if err := p1.Release(ctx); err != nil {
+		t.Errorf("second Release() error = %v", err)
+	}
+	p3, err := sem.Acquire(ctx)
+	if err != nil || p3 == nil {
+		t.Fatalf("Acquire() after release = %v, %v", p3, err)
+	}
+
+	// 租约过期的许可被回收，原持有者归还时得到 ErrLockLost
+	_, _ = mr.ZAdd("lock:export", float64(time.Now().Add(-time.Second).UnixMilli()), p2.id)
+	p4, err := sem.Acquire(ctx)
+	if err != nil || p4 == nil {
+		t.Fatalf("Acquire() after lease expired = %v, %v", p4, err)
+	}
+	if err := p2.Release(ctx); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Release() of expired permit error = %v, want ErrLockLost", err)
+	}
+	_ = p3.Release(ctx)
+	_ = p4.Release(ctx)
+}
+
+func TestRedisSemaphore_Renew(t *testing.T) {
+	mr, rdb := newTestLockRedis(t)
+	config := testLockConfig
+	config.DefaultExpiration = 150 * time.Millisecond
+	sem := NewRedisSemaphore(rdb, zap.NewNop(), "export", 1, config)
+	permit, err := sem.Acquire(context.Background())
+	if err != nil || permit == nil {
+		t.Fatalf("Acquire() = %v, %v", permit, err)
+	}
+	acquiredAt := time.Now()
+	time.Sleep(200 * time.Millisecond)
+
+	// 续期后租约的过期时间晚于初始过期时间
+	score, _ := mr.ZScore("lock:export", permit.id)
+	if int64(score) <= acquiredAt.Add(config.DefaultExpiration).UnixMilli() {
+		t.Errorf("permit lease not renewed")
+	}
+	select {
+	case <-permit.Lost():
+		t.Errorf("permit lost while renewing")
+	default:
+	}
+	_ = permit.Release(context.Background())
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量，取 8 位结果的后 6 位
+func TestTOTPCode_RFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	now := time.Now()
+	previous, _ := TOTPCode(secret, TOTPStep(now)-1)
+	if step, ok := ValidateTOTP(secret, previous, now, 1); !ok || step != TOTPStep(now)-1 {
+		t.Errorf("ValidateTOTP(previous step) = %d, %v", step, ok)
+	}
+	stale, _ := TOTPCode(secret, TOTPStep(now)-2)
+	if _, ok := ValidateTOTP(secret, stale, now, 1); ok {
+		t.Errorf("ValidateTOTP accepted code outside skew")
+	}
+
+	uri := TOTPURI("tx-demo", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/tx-demo:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("TOTPURI() = %s", uri)
+	}
+}
+
+func TestCipher(t *testing.T) {
+	conf := viper.New()
+	conf.Set("security.encryption.key", "test-key")
+	c, err := NewCipher(conf)
+	if err != nil {
+		t.Fatalf("NewCipher() error = %v", err)
+	}
+	encrypted, err := c.Encrypt("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if strings.Contains(encrypted, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("Encrypt() leaked plaintext")
+	}
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil || decrypted != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Decrypt() = %s, %v", decrypted, err)
+	}
+
+	// 密文被篡改时解密失败
+	sealed, _ := base64.StdEncoding.DecodeString(encrypted)
+	sealed[len(sealed)-1] ^= 1
+	if _, err := c.Decrypt(base64.StdEncoding.EncodeToString(sealed)); err == nil {
+		t.Errorf("Decrypt() accepted tampered ciphertext")
+	}
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Vector 对应 pgvector 的 vector 类型，以 float32 存储
+// 实现了 sql.Scanner 和 driver.Valuer，可直接作为 gorm 模型字段，nil 对应 NULL
+type Vector []float32
+
+// NewVector 将嵌入接口返回的 float64 向量转换为 Vector
+func NewVector(values []float64) Vector {
+	if values == nil {
+		return nil
+	}
+	v := make(Vector, len(values))
+	for i, value := range values {
+		v[i] = float32(value)
+	}
+	return v
+}
+
+// Float64s 转换为 float64 切片，便于计算
+func (v Vector) Float64s() []float64 {
+	values := make([]float64, len(v))
+	for i, value := range v {
+		values[i] = float64(value)
+	}
+	return values
+}
+
+// String 返回 pgvector 的文本格式，如 [1,2.5,3]
+func (v Vector) String() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, value := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(value), 'f', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// Parse 解析 pgvector 的文本格式
+func (v *Vector) Parse(s string) error {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return fmt.Errorf("invalid vector text: %q", s)
+	}
+	s = s[1 : len(s)-1]
+	if strings.TrimSpace(s) == "" {
+		*v = Vector{}
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	values := make(Vector, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return fmt.Errorf("invalid vector element %q: %w", part, err)
+		}
+		values[i] = float32(value)
+	}
+	*v = values
+	return nil
+}
+
+// MarshalBinary 编码为 pgvector 的二进制格式：
+// 2 字节维度 + 2 字节保留位 + 每个元素 4 字节 float32，均为大端序
+func (v Vector) MarshalBinary() ([]byte, error) {
+	if len(v) > math.MaxUint16 {
+		return nil, fmt.Errorf("vector dimension %d exceeds limit", len(v))
+	}
+	buf := make([]byte, 4+4*len(v))
+	binary.BigEndian.PutUint16(buf[0:], uint16(len(v)))
+	for i, value := range v {
+		binary.BigEndian.PutUint32(buf[4+4*i:], math.Float32bits(value))
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary 解码 pgvector 的二进制格式
+func (v *Vector) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("invalid vector binary: too short")
+	}
+	dim := int(binary.BigEndian.Uint16(data[0:]))
+	if len(data) != 4+4*dim {
+		return fmt.Errorf("invalid vector binary: expected %d bytes for dimension %d, got %d", 4+4*dim, dim, len(data))
+	}
+	values := make(Vector, dim)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.BigEndian.Uint32(data[4+4*i:]))
+	}
+	*v = values
+	return nil
+}
+
+// Scan 实现 sql.Scanner，支持文本和二进制两种格式
+func (v *Vector) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		return v.Parse(src)
+	case []byte:
+		// 文本格式以 '[' 开头；二进制格式的首字节是维度高位，pgvector 最大维度 16000 不会出现 '['
+		if len(src) > 0 && src[0] == '[' {
+			return v.Parse(string(src))
+		}
+		return v.UnmarshalBinary(src)
+	default:
+		return fmt.Errorf("unsupported vector source type %T", src)
+	}
+}
+
+// Value 实现 driver.Valuer，以文本格式写入
+func (v Vector) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return v.String(), nil
+}
+
+// GormDataType 指定 gorm 的字段类型
+func (Vector) GormDataType() string {
+	return "vector"
+}
@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+var (
+	_ sql.Scanner   = (*Vector)(nil)
+	_ driver.Valuer = Vector(nil)
+)
+
+func TestVector_Text(t *testing.T) {
+	v := Vector{1, -2.5, 0.125}
+	text := v.String()
+	if text != "[1,-2.5,0.125]" {
+		t.Fatalf("String() = %s", text)
+	}
+
+	tests := []struct {
+		name string
+		src  interface{}
+	}{
+		{name: "string", src: text},
+		{name: "bytes", src: []byte(text)},
+		{name: "spaces", src: "[1, -2.5 ,0.125]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Vector
+			if err := got.Scan(tt.src); err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if !equalVectors(got, v) {
+				t.Errorf("Scan() = %v, want %v", got, v)
+			}
+		})
+	}
+
+	var empty Vector
+	if err := empty.Scan("[]"); err != nil || empty == nil || len(empty) != 0 {
+		t.Errorf("Scan([]) = %v, %v", empty, err)
+	}
+	if err := empty.Scan("1,2"); err == nil {
+		t.Errorf("Scan() expected error for invalid text")
+	}
+}
+
+func TestVector_Binary(t *testing.T) {
+	v := Vector{1, -2.5, 0.125}
+	// pgvector 二进制格式：维度 3，保留位 0，随后为大端 float32
+	wire := []byte{
+		0x00, 0x03, 0x00, 0x00,
+		0x3f, 0x80, 0x00, 0x00,
+		0xc0, 0x20, 0x00, 0x00,
+		0x3e, 0x00, 0x00, 0x00,
+	}
+
+	data, err := v.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+	if !bytes.Equal(data, wire) {
+		t.Fatalf("MarshalBinary() = %x, want %x", data, wire)
+	}
+
+	var got Vector
+	if err := got.Scan(wire); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if !equalVectors(got, v) {
+		t.Errorf("Scan() = %v, want %v", got, v)
+	}
+
+	if err := got.Scan(wire[:6]); err == nil {
+		t.Errorf("Scan() expected error for truncated binary")
+	}
+}
+
+func TestVector_Value(t *testing.T) {
+	var null Vector
+	if value, err := null.Value(); err != nil || value != nil {
+		t.Errorf("Value() = %v, %v, want nil", value, err)
+	}
+	if err := null.Scan(nil); err != nil || null != nil {
+		t.Errorf("Scan(nil) = %v, %v", null, err)
+	}
+
+	v := NewVector([]float64{0.1, 0.2})
+	value, err := v.Value()
+	if err != nil {
+		t.Fatalf("Value() error = %v", err)
+	}
+	var got Vector
+	if err := got.Scan(value); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if !equalVectors(got, v) {
+		t.Errorf("round trip = %v, want %v", got, v)
+	}
+}
+
+func equalVectors(a, b Vector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"tx-demo/model"
+)
+
+type AuditRepository interface {
+	CreateEvent(ctx context.Context, event *model.AuditEvent) error
+	ListEvents(ctx context.Context, filter AuditFilter, beforeID int64, limit int) ([]*model.AuditEvent, error)
+}
+
+// AuditFilter 查询审计事件的过滤条件，零值表示不过滤
+type AuditFilter struct {
+	ActorID  string
+	TargetID string
+	Action   string
+	Outcome  string
+	Since    time.Time
+	Until    time.Time
+}
+
+type auditRepository struct {
+	*Repository
+}
+
+func NewAuditRepository(
+	r *Repository,
+) AuditRepository {
+	return &auditRepository{
+		Repository: r,
+	}
+}
+
+// CreateEvent 追加一条审计事件
+func (a *auditRepository) CreateEvent(ctx context.Context, event *model.AuditEvent) error {
+	return a.DB(ctx).Create(event).Error
+}
+
+// ListEvents 按时间倒序分页查询 id 小于 beforeID 的审计事件，beforeID <= 0 时从最新一条开始
+func (a *auditRepository) ListEvents(ctx context.Context, filter AuditFilter, beforeID int64, limit int) ([]*model.AuditEvent, error) {
+	var events []*model.AuditEvent
+	query := a.DB(ctx)
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	if filter.ActorID != "" {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	return events, query.Order("id DESC").Limit(limit).Find(&events).Error
+}
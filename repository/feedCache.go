@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"time"
+)
+
+// feedCacheKeyPrefix 推荐列表缓存 key 前缀
+const feedCacheKeyPrefix = "recommend:feed:"
+
+// FeedItem 推荐列表中的一项
+type FeedItem struct {
+	UserID     string  `json:"user_id"`
+	Score      float64 `json:"score"`
+	Similarity float64 `json:"similarity"`
+}
+
+// FeedCache 按用户缓存排好序的推荐列表
+// 用户喜好和兴趣标签变化时，由 UserRepository 和 InterestRepository 在写入后直接删除缓存
+type FeedCache interface {
+	// Get 获取缓存，未命中时返回 false
+	Get(ctx context.Context, userID string) ([]FeedItem, bool, error)
+	Set(ctx context.Context, userID string, items []FeedItem) error
+}
+
+type feedCache struct {
+	*Repository
+	ttl time.Duration
+}
+
+func NewFeedCache(
+	r *Repository,
+	conf *viper.Viper,
+) FeedCache {
+	ttl := conf.GetDuration("recommend.cache_ttl")
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	return &feedCache{
+		Repository: r,
+		ttl:        ttl,
+	}
+}
+
+func feedCacheKey(userID string) string {
+	return feedCacheKeyPrefix + userID
+}
+
+// Get 获取缓存，未命中时返回 false
+func (c *feedCache) Get(ctx context.Context, userID string) ([]FeedItem, bool, error) {
+	data, err := c.rdb.Get(ctx, feedCacheKey(userID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	var items []FeedItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, false, err
+	}
+	return items, true, nil
+}
+
+// Set 写入缓存
+func (c *feedCache) Set(ctx context.Context, userID string, items []FeedItem) error {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, feedCacheKey(userID), data, c.ttl).Err()
+}
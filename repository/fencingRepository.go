@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStaleFencingToken 写入携带的栅栏令牌比资源上记录的更旧，说明锁已被他人重新获取
+var ErrStaleFencingToken = errors.New("stale fencing token")
+
+type FencingRepository interface {
+	// FencedWrite 在事务中校验并记录栅栏令牌，令牌不小于已记录的令牌时才执行 fn
+	FencedWrite(ctx context.Context, resource string, token int64, fn func(ctx context.Context) error) error
+	// Release 删除资源上不大于 token 的令牌记录，写入结果已由其他约束（如唯一索引）保护后调用，避免记录无限增长
+	Release(ctx context.Context, resource string, token int64) error
+}
+
+type fencingRepository struct {
+	*Repository
+}
+
+func NewFencingRepository(
+	r *Repository,
+) FencingRepository {
+	return &fencingRepository{
+		Repository: r,
+	}
+}
+
+// FencedWrite 令牌行在事务提交前保持行锁，携带旧令牌的并发写入会等待后被拒绝
+func (f *fencingRepository) FencedWrite(ctx context.Context, resource string, token int64, fn func(ctx context.Context) error) error {
+	return f.Transaction(ctx, func(ctx context.Context) error {
+		result := f.DB(ctx).Exec(`
+			INSERT INTO fencing_tokens (resource, token, updated_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT (resource) DO UPDATE
+			SET token = EXCLUDED.token, updated_at = EXCLUDED.updated_at
+			WHERE fencing_tokens.token <= EXCLUDED.token`, resource, token)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrStaleFencingToken
+		}
+		return fn(ctx)
+	})
+}
+
+// Release 已被更新的令牌覆盖时不删除
+func (f *fencingRepository) Release(ctx context.Context, resource string, token int64) error {
+	return f.DB(ctx).Exec(`DELETE FROM fencing_tokens WHERE resource = ? AND token <= ?`, resource, token).Error
+}
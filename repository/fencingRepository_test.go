@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// fakeFencingPool 模拟 fencing_tokens 表的 upsert 语义，事务提交前的写入对其他连接不可见
+type fakeFencingPool struct {
+	mu     sync.Mutex
+	tokens map[string]int64
+}
+
+func (p *fakeFencingPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return &fakeFencingTx{fakeFencingPool: p, staged: map[string]int64{}}, nil
+}
+
+func (p *fakeFencingPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+// ExecContext 事务外只支持删除令牌记录
+func (p *fakeFencingPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if !strings.Contains(query, "DELETE FROM fencing_tokens") || len(args) != 2 {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	resource, token := args[0].(string), args[1].(int64)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if current, ok := p.tokens[resource]; ok && current <= token {
+		delete(p.tokens, resource)
+		return driver.RowsAffected(1), nil
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (p *fakeFencingPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (p *fakeFencingPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+type fakeFencingTx struct {
+	*fakeFencingPool
+	staged map[string]int64
+}
+
+func (tx *fakeFencingTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if !strings.Contains(query, "INSERT INTO fencing_tokens") || len(args) != 2 {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	resource, token := args[0].(string), args[1].(int64)
+
+	current, ok := tx.staged[resource]
+	if !ok {
+		tx.mu.Lock()
+		current, ok = tx.tokens[resource]
+		tx.mu.Unlock()
+	}
+	// ON CONFLICT ... WHERE fencing_tokens.token <= EXCLUDED.token
+	if ok && current > token {
+		return driver.RowsAffected(0), nil
+	}
+	tx.staged[resource] = token
+	return driver.RowsAffected(1), nil
+}
+
+func (tx *fakeFencingTx) Commit() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for resource, token := range tx.staged {
+		tx.tokens[resource] = token
+	}
+	return nil
+}
+
+func (tx *fakeFencingTx) Rollback() error {
+	return nil
+}
+
+func TestFencingRepository_FencedWrite(t *testing.T) {
+	pool := &fakeFencingPool{tokens: map[string]int64{}}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	fencing := NewFencingRepository(NewRepository(db, nil))
+	ctx := context.Background()
+
+	var calls int
+	write := func(ctx context.Context) error {
+		calls++
+		return nil
+	}
+
+	if err := fencing.FencedWrite(ctx, "register:lock:1", 2, write); err != nil {
+		t.Fatalf("FencedWrite(2) error = %v", err)
+	}
+	// 旧令牌被拒绝，且不会执行写入
+	if err := fencing.FencedWrite(ctx, "register:lock:1", 1, write); !errors.Is(err, ErrStaleFencingToken) {
+		t.Fatalf("FencedWrite(1) error = %v, want ErrStaleFencingToken", err)
+	}
+	if calls != 1 {
+		t.Fatalf("write calls = %d, want 1", calls)
+	}
+	// 相同令牌允许重复写入，其他资源互不影响
+	if err := fencing.FencedWrite(ctx, "register:lock:1", 2, write); err != nil {
+		t.Fatalf("FencedWrite(2) again error = %v", err)
+	}
+	if err := fencing.FencedWrite(ctx, "register:lock:2", 1, write); err != nil {
+		t.Fatalf("FencedWrite(other resource) error = %v", err)
+	}
+
+	// 写入失败时令牌随事务回滚，不会拒绝之后更小的令牌
+	writeErr := errors.New("write failed")
+	if err := fencing.FencedWrite(ctx, "register:lock:1", 5, func(ctx context.Context) error {
+		return writeErr
+	}); !errors.Is(err, writeErr) {
+		t.Fatalf("FencedWrite(5) error = %v, want %v", err, writeErr)
+	}
+	if got := pool.tokens["register:lock:1"]; got != 2 {
+		t.Fatalf("token after rollback = %d, want 2", got)
+	}
+	if err := fencing.FencedWrite(ctx, "register:lock:1", 3, write); err != nil {
+		t.Fatalf("FencedWrite(3) error = %v", err)
+	}
+}
+
+func TestFencingRepository_Release(t *testing.T) {
+	pool := &fakeFencingPool{tokens: map[string]int64{}}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	fencing := NewFencingRepository(NewRepository(db, nil))
+	ctx := context.Background()
+	noop := func(ctx context.Context) error { return nil }
+
+	if err := fencing.FencedWrite(ctx, "register:lock:alice", 3, noop); err != nil {
+		t.Fatalf("FencedWrite() error = %v", err)
+	}
+	// 旧令牌不能删除新令牌的记录
+	if err := fencing.Release(ctx, "register:lock:alice", 2); err != nil {
+		t.Fatalf("Release(2) error = %v", err)
+	}
+	if _, ok := pool.tokens["register:lock:alice"]; !ok {
+		t.Fatalf("Release() with an older token deleted the record")
+	}
+	if err := fencing.Release(ctx, "register:lock:alice", 3); err != nil {
+		t.Fatalf("Release(3) error = %v", err)
+	}
+	if len(pool.tokens) != 0 {
+		t.Errorf("tokens after Release = %v, want empty", pool.tokens)
+	}
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"tx-demo/model"
+	"tx-demo/pkg"
+)
+
+var (
+	// ErrInterestExists 用户已添加过该兴趣标签
+	ErrInterestExists = errors.New("interest already exists")
+	// ErrTooManyInterests 用户的兴趣数量已达上限
+	ErrTooManyInterests = errors.New("too many interests")
+)
+
+type InterestRepository interface {
+	ListByUserID(ctx context.Context, userID string) ([]*model.UserInterest, error)
+	ListByUserIDs(ctx context.Context, userIDs []string) ([]*model.UserInterest, error)
+	UpdateEmbedding(ctx context.Context, interest *model.UserInterest, embedding pkg.Vector) error
+	CreateInterest(ctx context.Context, interest *model.UserInterest, limit int) error
+	DeleteInterest(ctx context.Context, userID string, tag string) (bool, error)
+}
+
+type interestRepository struct {
+	*Repository
+}
+
+func NewInterestRepository(
+	r *Repository,
+) InterestRepository {
+	return &interestRepository{
+		Repository: r,
+	}
+}
+
+// ListByUserID 查询用户的全部兴趣，按添加顺序排列
+func (i *interestRepository) ListByUserID(ctx context.Context, userID string) ([]*model.UserInterest, error) {
+	var interests []*model.UserInterest
+	return interests, i.DB(ctx).Where("user_id = ?", userID).Order("id").Find(&interests).Error
+}
+
+// ListByUserIDs 批量查询多个用户的兴趣，按添加顺序排列
+func (i *interestRepository) ListByUserIDs(ctx context.Context, userIDs []string) ([]*model.UserInterest, error) {
+	var interests []*model.UserInterest
+	if len(userIDs) == 0 {
+		return interests, nil
+	}
+	return interests, i.DB(ctx).Where("user_id IN ?", userIDs).Order("id").Find(&interests).Error
+}
+
+// UpdateEmbedding 写入兴趣的嵌入向量，并使该用户的推荐缓存失效
+func (i *interestRepository) UpdateEmbedding(ctx context.Context, interest *model.UserInterest, embedding pkg.Vector) error {
+	err := i.DB(ctx).Model(&model.UserInterest{}).Where("id = ?", interest.ID).Update("embedding", embedding).Error
+	if err != nil {
+		return err
+	}
+	return i.rdb.Del(ctx, feedCacheKey(interest.UserID)).Err()
+}
+
+// CreateInterest 添加兴趣，用户已有 limit 个兴趣时返回 ErrTooManyInterests，标签重复时返回 ErrInterestExists
+// 计数和插入在同一事务中进行，并锁住用户行，使同一用户的并发添加串行执行；成功后使该用户的推荐缓存失效
+func (i *interestRepository) CreateInterest(ctx context.Context, interest *model.UserInterest, limit int) error {
+	err := i.Transaction(ctx, func(ctx context.Context) error {
+		if err := i.DB(ctx).Exec("SELECT id FROM users WHERE user_id = ? FOR UPDATE", interest.UserID).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := i.DB(ctx).Model(&model.UserInterest{}).Where("user_id = ?", interest.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= int64(limit) {
+			return ErrTooManyInterests
+		}
+		return i.DB(ctx).Create(interest).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrInterestExists
+	}
+	if err != nil {
+		return err
+	}
+	return i.rdb.Del(ctx, feedCacheKey(interest.UserID)).Err()
+}
+
+// DeleteInterest 删除兴趣，返回是否存在，并使该用户的推荐缓存失效
+func (i *interestRepository) DeleteInterest(ctx context.Context, userID string, tag string) (bool, error) {
+	result := i.DB(ctx).Where("user_id = ? AND tag = ?", userID, tag).Delete(&model.UserInterest{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, i.rdb.Del(ctx, feedCacheKey(userID)).Err()
+}
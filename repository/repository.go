@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -68,12 +69,12 @@ func NewDB(conf *viper.Viper) *gorm.DB {
 	// GORM doc: https://gorm.io/docs/connecting_to_the_database.html
 	switch driver {
 	case "mysql":
-		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
 	case "postgres":
 		db, err = gorm.Open(postgres.New(postgres.Config{
 			DSN:                  dsn,
 			PreferSimpleProtocol: true, // disables implicit prepared statement usage
-		}), &gorm.Config{})
+		}), &gorm.Config{TranslateError: true}) // 唯一约束冲突转换为 gorm.ErrDuplicatedKey
 	default:
 		panic("unknown db driver")
 	}
@@ -110,3 +111,35 @@ func NewRedis(conf *viper.Viper) *redis.Client {
 
 	return rdb
 }
+
+// LockNodes 多节点加锁（Redlock）使用的独立 Redis 实例，未配置时为空
+type LockNodes []*redis.Client
+
+// NewLockNodes 按 data.redis.lock_nodes 创建连接
+// 少数节点不可用时仍能加锁，因此启动时连接失败只记录警告
+func NewLockNodes(conf *viper.Viper, logger *zap.Logger) LockNodes {
+	var configs []struct {
+		Addr     string
+		Password string
+		DB       int
+	}
+	if err := conf.UnmarshalKey("data.redis.lock_nodes", &configs); err != nil {
+		panic(fmt.Sprintf("lock nodes config error: %s", err.Error()))
+	}
+
+	var nodes LockNodes
+	for _, c := range configs {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     c.Addr,
+			Password: c.Password,
+			DB:       c.DB,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			logger.Warn("lock node unavailable", zap.String("addr", c.Addr), zap.Error(err))
+		}
+		cancel()
+		nodes = append(nodes, rdb)
+	}
+	return nodes
+}
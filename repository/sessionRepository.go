@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+	"tx-demo/model"
+)
+
+const (
+	// sessionKeyPrefix 活跃会话 key 前缀，key 存在即表示会话有效
+	sessionKeyPrefix = "session:"
+	// sessionPersistInterval 最后活跃时间写回数据库的最小间隔
+	sessionPersistInterval = time.Minute
+)
+
+// touchSessionScript 会话存在时更新最后活跃时间，距上次写回数据库超过间隔时返回 1
+var touchSessionScript = redis.NewScript(`
+	if redis.call("EXISTS", KEYS[1]) == 0 then
+		return -1
+	end
+	redis.call("HSET", KEYS[1], "last_seen", ARGV[1])
+	local persisted = tonumber(redis.call("HGET", KEYS[1], "persisted_at") or "0")
+	if tonumber(ARGV[1]) - persisted >= tonumber(ARGV[2]) then
+		redis.call("HSET", KEYS[1], "persisted_at", ARGV[1])
+		return 1
+	end
+	return 0
+`)
+
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *model.Session) error
+	// TouchSession 校验会话是否有效并刷新最后活跃时间
+	TouchSession(ctx context.Context, sessionID string) (bool, error)
+	ListActiveSessions(ctx context.Context, userID string) ([]*model.Session, error)
+	// RevokeSession 吊销用户的会话，会话不存在或已吊销时返回 false
+	RevokeSession(ctx context.Context, userID string, sessionID string) (bool, error)
+	// RevokeUserSessions 吊销用户的全部会话，返回吊销的数量
+	RevokeUserSessions(ctx context.Context, userID string) (int, error)
+}
+
+type sessionRepository struct {
+	*Repository
+}
+
+func NewSessionRepository(
+	r *Repository,
+) SessionRepository {
+	return &sessionRepository{
+		Repository: r,
+	}
+}
+
+func sessionKey(sessionID string) string {
+	return sessionKeyPrefix + sessionID
+}
+
+// CreateSession 写入数据库，并在 Redis 中记录活跃会话直到过期
+func (s *sessionRepository) CreateSession(ctx context.Context, session *model.Session) error {
+	if err := s.DB(ctx).Create(session).Error; err != nil {
+		return err
+	}
+	now := strconv.FormatInt(session.LastSeenAt.Unix(), 10)
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, sessionKey(session.SessionID), "user_id", session.UserID, "last_seen", now, "persisted_at", now)
+	pipe.ExpireAt(ctx, sessionKey(session.SessionID), session.ExpiresAt)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// TouchSession 最后活跃时间先写 Redis，按间隔写回数据库，避免每次请求都更新数据库
+func (s *sessionRepository) TouchSession(ctx context.Context, sessionID string) (bool, error) {
+	now := time.Now()
+	result, err := touchSessionScript.Run(ctx, s.rdb, []string{sessionKey(sessionID)}, now.Unix(), int64(sessionPersistInterval.Seconds())).Int64()
+	if err != nil {
+		return false, err
+	}
+	switch result {
+	case -1:
+		return false, nil
+	case 1:
+		err = s.DB(ctx).Model(&model.Session{}).Where("session_id = ?", sessionID).Update("last_seen_at", now).Error
+		return true, err
+	}
+	return true, nil
+}
+
+// ListActiveSessions 查询用户未吊销且未过期的会话，最后活跃时间以 Redis 中的为准
+func (s *sessionRepository) ListActiveSessions(ctx context.Context, userID string) ([]*model.Session, error) {
+	var sessions []*model.Session
+	err := s.DB(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error
+	if err != nil || len(sessions) == 0 {
+		return sessions, err
+	}
+
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(sessions))
+	for i, session := range sessions {
+		cmds[i] = pipe.HGet(ctx, sessionKey(session.SessionID), "last_seen")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		if lastSeen, err := cmd.Int64(); err == nil {
+			sessions[i].LastSeenAt = time.Unix(lastSeen, 0)
+		}
+	}
+	return sessions, nil
+}
+
+// RevokeSession 标记数据库中的会话为已吊销，并删除 Redis 中的活跃会话
+func (s *sessionRepository) RevokeSession(ctx context.Context, userID string, sessionID string) (bool, error) {
+	result := s.DB(ctx).Model(&model.Session{}).
+		Where("session_id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, s.rdb.Del(ctx, sessionKey(sessionID)).Err()
+}
+
+// RevokeUserSessions 标记用户全部未吊销的会话为已吊销，并删除 Redis 中的活跃会话
+func (s *sessionRepository) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	var sessionIDs []string
+	err := s.Transaction(ctx, func(ctx context.Context) error {
+		err := s.DB(ctx).Model(&model.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Pluck("session_id", &sessionIDs).Error
+		if err != nil || len(sessionIDs) == 0 {
+			return err
+		}
+		return s.DB(ctx).Model(&model.Session{}).
+			Where("session_id IN ?", sessionIDs).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil || len(sessionIDs) == 0 {
+		return 0, err
+	}
+
+	keys := make([]string, len(sessionIDs))
+	for i, sessionID := range sessionIDs {
+		keys[i] = sessionKey(sessionID)
+	}
+	return len(sessionIDs), s.rdb.Del(ctx, keys...).Err()
+}
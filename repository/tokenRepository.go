@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"time"
+	"tx-demo/pkg"
+)
+
+// tokenRevokedKeyPrefix 用户令牌吊销时间 key 前缀，值为毫秒时间戳
+const tokenRevokedKeyPrefix = "token:revoked:"
+
+type TokenRepository interface {
+	// RevokeUserTokens 吊销用户在此之前签发的全部令牌
+	RevokeUserTokens(ctx context.Context, userID string) error
+	// IsRevoked 判断在 issuedAtMs（毫秒）签发的令牌是否已被吊销
+	IsRevoked(ctx context.Context, userID string, issuedAtMs int64) (bool, error)
+}
+
+type tokenRepository struct {
+	*Repository
+}
+
+func NewTokenRepository(
+	r *Repository,
+) TokenRepository {
+	return &tokenRepository{
+		Repository: r,
+	}
+}
+
+func tokenRevokedKey(userID string) string {
+	return tokenRevokedKeyPrefix + userID
+}
+
+// RevokeUserTokens 记录吊销时间（毫秒），保留到此前签发的令牌全部过期为止
+func (t *tokenRepository) RevokeUserTokens(ctx context.Context, userID string) error {
+	return t.rdb.Set(ctx, tokenRevokedKey(userID), time.Now().UnixMilli(), pkg.TokenExpiration).Err()
+}
+
+// IsRevoked 吊销之前签发的令牌视为已吊销，吊销之后（包括同一秒内）重新登录签发的令牌不受影响
+func (t *tokenRepository) IsRevoked(ctx context.Context, userID string, issuedAtMs int64) (bool, error) {
+	revokedAt, err := t.rdb.Get(ctx, tokenRevokedKey(userID)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+	return issuedAtMs < revokedAt, nil
+}
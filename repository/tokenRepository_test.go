@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTokenRepository_IsRevoked(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	tokens := NewTokenRepository(NewRepository(nil, rdb))
+	ctx := context.Background()
+
+	if revoked, err := tokens.IsRevoked(ctx, "u1", time.Now().UnixMilli()); err != nil || revoked {
+		t.Fatalf("IsRevoked() before revoke = %v, %v", revoked, err)
+	}
+
+	before := time.Now().UnixMilli()
+	time.Sleep(2 * time.Millisecond)
+	if err := tokens.RevokeUserTokens(ctx, "u1"); err != nil {
+		t.Fatalf("RevokeUserTokens() error = %v", err)
+	}
+	revokedAt, _ := rdb.Get(ctx, tokenRevokedKey("u1")).Int64()
+
+	tests := []struct {
+		name       string
+		issuedAtMs int64
+		want       bool
+	}{
+		{name: "issued before revoke", issuedAtMs: before, want: true},
+		{name: "issued 1ms before revoke", issuedAtMs: revokedAt - 1, want: true},
+		// 同一秒内吊销之后重新登录签发的令牌仍然有效
+		{name: "issued at revoke", issuedAtMs: revokedAt, want: false},
+		{name: "issued 1ms after revoke", issuedAtMs: revokedAt + 1, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := tokens.IsRevoked(ctx, "u1", tt.issuedAtMs)
+			if err != nil {
+				t.Fatalf("IsRevoked() error = %v", err)
+			}
+			if revoked != tt.want {
+				t.Errorf("IsRevoked(%d) = %v, want %v (revoked at %d)", tt.issuedAtMs, revoked, tt.want, revokedAt)
+			}
+		})
+	}
+}
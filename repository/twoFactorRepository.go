@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+	"tx-demo/model"
+)
+
+const (
+	// totpStepKeyPrefix 用户最近一次使用的动态口令时间步，防止同一口令被重放
+	totpStepKeyPrefix = "totp:step:"
+	// loginChallengeKeyPrefix 两步登录的挑战令牌 key 前缀，key 为令牌哈希
+	loginChallengeKeyPrefix = "login:challenge:"
+	// totpFailuresKeyPrefix 用户两步验证失败次数 key 前缀，跨挑战累计
+	totpFailuresKeyPrefix = "totp:failures:"
+	// totpStepTTL 时间步记录的保留时间，需覆盖口令允许的时钟偏差窗口
+	totpStepTTL = 5 * time.Minute
+)
+
+// useTOTPStepScript 只接受比上次更新的时间步
+var useTOTPStepScript = redis.NewScript(`
+	local last = tonumber(redis.call("GET", KEYS[1]) or "-1")
+	if tonumber(ARGV[1]) <= last then
+		return 0
+	end
+	redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+	return 1
+`)
+
+// checkChallengeScript 累加尝试次数，超过次数后删除挑战；挑战未被占用时占用并返回用户ID
+var checkChallengeScript = redis.NewScript(`
+	local userID = redis.call("HGET", KEYS[1], "user_id")
+	if userID == false then
+		return ""
+	end
+	local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+	if attempts > tonumber(ARGV[1]) then
+		redis.call("DEL", KEYS[1])
+		return ""
+	end
+	if redis.call("HSETNX", KEYS[1], "claimed", 1) == 0 then
+		return ""
+	end
+	return userID
+`)
+
+// recordFailureScript 累加失败次数，窗口从第一次失败开始计算
+var recordFailureScript = redis.NewScript(`
+	local failures = redis.call("INCR", KEYS[1])
+	if failures == 1 then
+		redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	end
+	return failures
+`)
+
+type TwoFactorRepository interface {
+	// SavePendingSecret 保存待确认的密钥，已开启两步验证时返回 false
+	SavePendingSecret(ctx context.Context, userID string, secret string) (bool, error)
+	// EnableTOTP 开启两步验证，并用新的恢复码替换旧的恢复码
+	EnableTOTP(ctx context.Context, userID string, codeHashes []string) error
+	// ConsumeRecoveryCode 使用一次恢复码，不存在或已使用时返回 false
+	ConsumeRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error)
+	// UseTOTPStep 记录已使用的时间步，该时间步及更早的口令已使用过时返回 false
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	CreateChallenge(ctx context.Context, challengeHash string, userID string, ttl time.Duration) error
+	// CheckChallenge 占用挑战并返回对应的用户ID，不存在、已过期、尝试次数超过 maxAttempts 或正被其他请求占用时返回空
+	// 占用后校验失败需调用 ReleaseChallenge，校验成功需调用 DeleteChallenge
+	CheckChallenge(ctx context.Context, challengeHash string, maxAttempts int) (string, error)
+	// ReleaseChallenge 释放占用，挑战可用于下一次尝试
+	ReleaseChallenge(ctx context.Context, challengeHash string) error
+	// DeleteChallenge 删除挑战，已被删除时返回 false
+	DeleteChallenge(ctx context.Context, challengeHash string) (bool, error)
+	// RecordFailure 累加用户两步验证的失败次数，返回 window 内的失败次数
+	RecordFailure(ctx context.Context, userID string, window time.Duration) (int64, error)
+	// Failures 返回用户当前窗口内两步验证的失败次数
+	Failures(ctx context.Context, userID string) (int64, error)
+	// ResetFailures 验证成功后清零失败次数
+	ResetFailures(ctx context.Context, userID string) error
+}
+
+type twoFactorRepository struct {
+	*Repository
+}
+
+func NewTwoFactorRepository(
+	r *Repository,
+) TwoFactorRepository {
+	return &twoFactorRepository{
+		Repository: r,
+	}
+}
+
+func (t *twoFactorRepository) SavePendingSecret(ctx context.Context, userID string, secret string) (bool, error) {
+	result := t.DB(ctx).Model(&model.User{}).
+		Where("user_id = ? AND totp_enabled = ?", userID, false).
+		Update("totp_secret", secret)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (t *twoFactorRepository) EnableTOTP(ctx context.Context, userID string, codeHashes []string) error {
+	return t.Transaction(ctx, func(ctx context.Context) error {
+		if err := t.DB(ctx).Model(&model.User{}).Where("user_id = ?", userID).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		if err := t.DB(ctx).Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]*model.RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, &model.RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return t.DB(ctx).Create(&codes).Error
+	})
+}
+
+func (t *twoFactorRepository) ConsumeRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	result := t.DB(ctx).Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func (t *twoFactorRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	used, err := useTOTPStepScript.Run(ctx, t.rdb, []string{totpStepKeyPrefix + userID}, step, int64(totpStepTTL.Seconds())).Int()
+	return used == 1, err
+}
+
+func (t *twoFactorRepository) CreateChallenge(ctx context.Context, challengeHash string, userID string, ttl time.Duration) error {
+	key := loginChallengeKeyPrefix + challengeHash
+	pipe := t.rdb.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID, "attempts", strconv.Itoa(0))
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (t *twoFactorRepository) CheckChallenge(ctx context.Context, challengeHash string, maxAttempts int) (string, error) {
+	return checkChallengeScript.Run(ctx, t.rdb, []string{loginChallengeKeyPrefix + challengeHash}, maxAttempts).Text()
+}
+
+func (t *twoFactorRepository) DeleteChallenge(ctx context.Context, challengeHash string) (bool, error) {
+	deleted, err := t.rdb.Del(ctx, loginChallengeKeyPrefix+challengeHash).Result()
+	return deleted > 0, err
+}
+
+func (t *twoFactorRepository) ReleaseChallenge(ctx context.Context, challengeHash string) error {
+	return t.rdb.HDel(ctx, loginChallengeKeyPrefix+challengeHash, "claimed").Err()
+}
+
+func (t *twoFactorRepository) RecordFailure(ctx context.Context, userID string, window time.Duration) (int64, error) {
+	return recordFailureScript.Run(ctx, t.rdb, []string{totpFailuresKeyPrefix + userID}, window.Milliseconds()).Int64()
+}
+
+func (t *twoFactorRepository) Failures(ctx context.Context, userID string) (int64, error) {
+	failures, err := t.rdb.Get(ctx, totpFailuresKeyPrefix+userID).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return failures, err
+}
+
+func (t *twoFactorRepository) ResetFailures(ctx context.Context, userID string) error {
+	return t.rdb.Del(ctx, totpFailuresKeyPrefix+userID).Err()
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTwoFactorRepository_ClaimChallenge(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	twoFactor := NewTwoFactorRepository(NewRepository(nil, rdb))
+	ctx := context.Background()
+
+	if err := twoFactor.CreateChallenge(ctx, "hash", "u1", time.Minute); err != nil {
+		t.Fatalf("CreateChallenge() error = %v", err)
+	}
+	if userID, _ := twoFactor.CheckChallenge(ctx, "hash", 5); userID != "u1" {
+		t.Fatalf("CheckChallenge() = %q, want u1", userID)
+	}
+	// 被占用期间并发的校验拿不到挑战，不会在同一挑战上同时消耗恢复码
+	if userID, _ := twoFactor.CheckChallenge(ctx, "hash", 5); userID != "" {
+		t.Fatalf("CheckChallenge() while claimed = %q, want empty", userID)
+	}
+	if err := twoFactor.ReleaseChallenge(ctx, "hash"); err != nil {
+		t.Fatalf("ReleaseChallenge() error = %v", err)
+	}
+	if userID, _ := twoFactor.CheckChallenge(ctx, "hash", 5); userID != "u1" {
+		t.Fatalf("CheckChallenge() after release = %q, want u1", userID)
+	}
+	if deleted, _ := twoFactor.DeleteChallenge(ctx, "hash"); !deleted {
+		t.Fatalf("DeleteChallenge() = false, want true")
+	}
+}
+
+func TestTwoFactorRepository_Failures(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	twoFactor := NewTwoFactorRepository(NewRepository(nil, rdb))
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		failures, err := twoFactor.RecordFailure(ctx, "u1", time.Minute)
+		if err != nil || failures != int64(i) {
+			t.Fatalf("RecordFailure() = %d, %v, want %d", failures, err, i)
+		}
+		// 窗口从第一次失败开始计算，后续失败不延长
+		mr.FastForward(10 * time.Second)
+	}
+	if failures, _ := twoFactor.Failures(ctx, "u1"); failures != 3 {
+		t.Errorf("Failures() = %d, want 3", failures)
+	}
+	mr.FastForward(30 * time.Second)
+	if failures, _ := twoFactor.Failures(ctx, "u1"); failures != 0 {
+		t.Errorf("Failures() after window = %d, want 0", failures)
+	}
+
+	_, _ = twoFactor.RecordFailure(ctx, "u1", time.Minute)
+	if err := twoFactor.ResetFailures(ctx, "u1"); err != nil {
+		t.Fatalf("ResetFailures() error = %v", err)
+	}
+	if failures, _ := twoFactor.Failures(ctx, "u1"); failures != 0 {
+		t.Errorf("Failures() after reset = %d, want 0", failures)
+	}
+}
@@ -2,13 +2,51 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"gorm.io/gorm"
+	"strings"
+	"time"
 	"tx-demo/model"
+	"tx-demo/pkg"
 )
 
 type UserRepository interface {
 	FindByUsername(ctx context.Context, username string) (*model.User, error)
 	CreateUser(ctx context.Context, user *model.User) error
 	FindByUserID(ctx context.Context, userID string) (*model.User, error)
+	FindByUserIDs(ctx context.Context, userIDs []string) ([]*model.User, error)
+	UpdateLikeEmbedding(ctx context.Context, userID string, embedding pkg.Vector) error
+	UpdateEmbeddingStatus(ctx context.Context, userID string, status string) error
+	ListUsersAfterID(ctx context.Context, afterID int64, limit int, statuses []string) ([]*model.User, error)
+	CountUsersAfterID(ctx context.Context, afterID int64, statuses []string) (int64, error)
+	FindSimilarUsers(ctx context.Context, userID string, aggregation string, limit int) ([]*SimilarUser, error)
+	ListUsers(ctx context.Context, filter UserFilter, afterID int64, limit int) ([]*model.User, error)
+	UpdateDisabled(ctx context.Context, userID string, disabled bool) error
+	UpdatePassword(ctx context.Context, userID string, password string) error
+	UpdateContact(ctx context.Context, userID string, email string, phone string) error
+	MarkContactVerified(ctx context.Context, userID string, channel string, contact string) (bool, error)
+}
+
+// UserFilter 管理后台查询用户的过滤条件，零值表示不过滤
+type UserFilter struct {
+	UsernamePrefix string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+}
+
+// 多个兴趣向量的相似度聚合方式
+const (
+	// AggregationMax 取两两兴趣之间的最大相似度，只要有一个兴趣相近即可
+	AggregationMax = "max"
+	// AggregationMean 取两两兴趣之间的平均相似度，整体兴趣越相近越靠前
+	AggregationMean = "mean"
+)
+
+// SimilarUser 相似度查询结果
+type SimilarUser struct {
+	model.User
+	// 余弦相似度，1 表示方向完全一致
+	Similarity float64
 }
 
 type userRepository struct {
@@ -39,3 +77,153 @@ func (u *userRepository) FindByUserID(ctx context.Context, userID string) (*mode
 	var user model.User
 	return &user, u.DB(ctx).Where("user_id = ?", userID).First(&user).Error
 }
+
+// FindByUserIDs 根据 用户ID 批量查询用户
+func (u *userRepository) FindByUserIDs(ctx context.Context, userIDs []string) ([]*model.User, error) {
+	var users []*model.User
+	return users, u.DB(ctx).Where("user_id IN ?", userIDs).Find(&users).Error
+}
+
+// UpdateLikeEmbedding 写入喜好的嵌入向量，并将状态置为 ready，同时使该用户的推荐缓存失效
+func (u *userRepository) UpdateLikeEmbedding(ctx context.Context, userID string, embedding pkg.Vector) error {
+	err := u.DB(ctx).Model(&model.User{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"like_embedding":   embedding,
+		"embedding_status": model.EmbeddingStatusReady,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return u.rdb.Del(ctx, feedCacheKey(userID)).Err()
+}
+
+// UpdateEmbeddingStatus 更新嵌入向量的计算状态
+func (u *userRepository) UpdateEmbeddingStatus(ctx context.Context, userID string, status string) error {
+	return u.DB(ctx).Model(&model.User{}).Where("user_id = ?", userID).Update("embedding_status", status).Error
+}
+
+// ListUsersAfterID 按主键顺序分页查询 id 大于 afterID 的用户，statuses 非空时按嵌入状态过滤
+func (u *userRepository) ListUsersAfterID(ctx context.Context, afterID int64, limit int, statuses []string) ([]*model.User, error) {
+	var users []*model.User
+	query := u.DB(ctx).Where("id > ?", afterID)
+	if len(statuses) > 0 {
+		query = query.Where("embedding_status IN ?", statuses)
+	}
+	return users, query.Order("id").Limit(limit).Find(&users).Error
+}
+
+// CountUsersAfterID 统计 id 大于 afterID 的用户数，statuses 非空时按嵌入状态过滤
+func (u *userRepository) CountUsersAfterID(ctx context.Context, afterID int64, statuses []string) (int64, error) {
+	var count int64
+	query := u.DB(ctx).Model(&model.User{}).Where("id > ?", afterID)
+	if len(statuses) > 0 {
+		query = query.Where("embedding_status IN ?", statuses)
+	}
+	return count, query.Count(&count).Error
+}
+
+// FindSimilarUsers 查询与 userID 兴趣最相近的用户，已删除和已禁用的用户不参与推荐
+// 每个用户的兴趣向量包括 like_embedding 和 user_interests 中的全部向量，
+// 两个用户的相似度为双方兴趣向量两两余弦相似度按 aggregation 聚合的结果。
+// 候选集先由查询用户的每个向量通过 HNSW 索引取最近的 limit 个邻居得到，只对候选集计算聚合相似度，
+// 因此结果是近似的，但不需要扫描全部向量
+func (u *userRepository) FindSimilarUsers(ctx context.Context, userID string, aggregation string, limit int) ([]*SimilarUser, error) {
+	aggregate := "MAX"
+	if aggregation == AggregationMean {
+		aggregate = "AVG"
+	}
+
+	var users []*SimilarUser
+	return users, u.DB(ctx).Raw(`
+		WITH q AS (
+			SELECT like_embedding AS embedding FROM users
+			WHERE user_id = @user AND like_embedding IS NOT NULL AND deleted_at IS NULL
+			UNION ALL
+			SELECT embedding FROM user_interests
+			WHERE user_id = @user AND embedding IS NOT NULL
+		), candidates AS (
+			SELECT n.user_id FROM q CROSS JOIN LATERAL (
+				SELECT user_id FROM users
+				WHERE like_embedding IS NOT NULL AND deleted_at IS NULL AND disabled = false
+				ORDER BY like_embedding <=> q.embedding
+				LIMIT @limit
+			) n
+			UNION
+			SELECT n.user_id FROM q CROSS JOIN LATERAL (
+				SELECT user_id FROM user_interests
+				WHERE embedding IS NOT NULL
+				ORDER BY embedding <=> q.embedding
+				LIMIT @limit
+			) n
+		), vectors AS (
+			SELECT users.user_id, users.like_embedding AS embedding FROM users
+			JOIN candidates ON candidates.user_id = users.user_id
+			WHERE users.like_embedding IS NOT NULL
+			UNION ALL
+			SELECT user_interests.user_id, user_interests.embedding FROM user_interests
+			JOIN candidates ON candidates.user_id = user_interests.user_id
+			WHERE user_interests.embedding IS NOT NULL
+		), scores AS (
+			SELECT vectors.user_id, `+aggregate+`(1 - (vectors.embedding <=> q.embedding)) AS similarity
+			FROM vectors CROSS JOIN q
+			WHERE vectors.user_id <> @user
+			GROUP BY vectors.user_id
+		)
+		SELECT users.*, scores.similarity FROM users
+		JOIN scores ON scores.user_id = users.user_id
+		WHERE users.deleted_at IS NULL AND users.disabled = false
+		ORDER BY scores.similarity DESC
+		LIMIT @limit`, sql.Named("user", userID), sql.Named("limit", limit)).Scan(&users).Error
+}
+
+// ListUsers 按主键顺序分页查询 id 大于 afterID 且满足过滤条件的用户
+func (u *userRepository) ListUsers(ctx context.Context, filter UserFilter, afterID int64, limit int) ([]*model.User, error) {
+	var users []*model.User
+	query := u.DB(ctx).Where("id > ?", afterID)
+	if filter.UsernamePrefix != "" {
+		query = query.Where("username LIKE ?", escapeLike(filter.UsernamePrefix)+"%")
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+	return users, query.Order("id").Limit(limit).Find(&users).Error
+}
+
+// UpdateDisabled 禁用或启用用户
+func (u *userRepository) UpdateDisabled(ctx context.Context, userID string, disabled bool) error {
+	return u.DB(ctx).Model(&model.User{}).Where("user_id = ?", userID).Update("disabled", disabled).Error
+}
+
+// UpdatePassword 更新密码哈希
+func (u *userRepository) UpdatePassword(ctx context.Context, userID string, password string) error {
+	return u.DB(ctx).Model(&model.User{}).Where("user_id = ?", userID).Update("password", password).Error
+}
+
+// UpdateContact 更新邮箱和手机号，发生变化的联系方式需要重新验证
+func (u *userRepository) UpdateContact(ctx context.Context, userID string, email string, phone string) error {
+	return u.DB(ctx).Model(&model.User{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"email":          email,
+		"phone":          phone,
+		"email_verified": gorm.Expr("email_verified AND email = ?", email),
+		"phone_verified": gorm.Expr("phone_verified AND phone = ?", phone),
+	}).Error
+}
+
+// MarkContactVerified 将联系方式标记为已验证，联系方式已被修改时返回 false
+func (u *userRepository) MarkContactVerified(ctx context.Context, userID string, channel string, contact string) (bool, error) {
+	column := "email"
+	if channel == pkg.ChannelPhone {
+		column = "phone"
+	}
+	result := u.DB(ctx).Model(&model.User{}).
+		Where("user_id = ? AND "+column+" = ?", userID, contact).
+		Update(column+"_verified", true)
+	return result.RowsAffected > 0, result.Error
+}
+
+// escapeLike 转义 LIKE 中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
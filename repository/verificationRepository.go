@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// verificationKeyPrefix 验证码 key 前缀，完整 key 为 verify:<用途>:<对象>
+const verificationKeyPrefix = "verify:"
+
+// 验证码用途
+const (
+	VerificationPurposeContact       = "contact"
+	VerificationPurposePasswordReset = "password_reset"
+)
+
+// VerifyResult 验证码校验结果
+type VerifyResult int
+
+const (
+	// VerifyOK 验证通过，验证码已作废
+	VerifyOK VerifyResult = iota
+	// VerifyMismatch 验证码错误
+	VerifyMismatch
+	// VerifyExpired 验证码不存在或已过期
+	VerifyExpired
+	// VerifyTooManyAttempts 错误次数过多，验证码已作废
+	VerifyTooManyAttempts
+)
+
+// saveCodeScript 冷却期内拒绝重复发送，否则保存验证码哈希并重置错误次数
+var saveCodeScript = redis.NewScript(`
+	if redis.call("SET", KEYS[2], "1", "PX", ARGV[3], "NX") == false then
+		return 0
+	end
+	redis.call("DEL", KEYS[1])
+	redis.call("HSET", KEYS[1], "hash", ARGV[1], "attempts", 0)
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+`)
+
+// verifyCodeScript 原子地累加错误次数并比较哈希，通过或超过次数后删除验证码
+var verifyCodeScript = redis.NewScript(`
+	local hash = redis.call("HGET", KEYS[1], "hash")
+	if hash == false then
+		return 2
+	end
+	if hash == ARGV[1] then
+		redis.call("DEL", KEYS[1])
+		return 0
+	end
+	local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+	if attempts >= tonumber(ARGV[2]) then
+		redis.call("DEL", KEYS[1])
+		return 3
+	end
+	return 1
+`)
+
+type VerificationRepository interface {
+	// SaveCode 保存验证码哈希，距上次发送不足 resendInterval 时返回 false
+	SaveCode(ctx context.Context, purpose, subject, codeHash string, ttl, resendInterval time.Duration) (bool, error)
+	VerifyCode(ctx context.Context, purpose, subject, codeHash string, maxAttempts int) (VerifyResult, error)
+}
+
+type verificationRepository struct {
+	*Repository
+}
+
+func NewVerificationRepository(
+	r *Repository,
+) VerificationRepository {
+	return &verificationRepository{
+		Repository: r,
+	}
+}
+
+func verificationKey(purpose, subject string) string {
+	return verificationKeyPrefix + purpose + ":" + subject
+}
+
+func (v *verificationRepository) SaveCode(ctx context.Context, purpose, subject, codeHash string, ttl, resendInterval time.Duration) (bool, error) {
+	key := verificationKey(purpose, subject)
+	saved, err := saveCodeScript.Run(ctx, v.rdb, []string{key, key + ":cooldown"},
+		codeHash, ttl.Milliseconds(), resendInterval.Milliseconds()).Int()
+	return saved == 1, err
+}
+
+func (v *verificationRepository) VerifyCode(ctx context.Context, purpose, subject, codeHash string, maxAttempts int) (VerifyResult, error) {
+	result, err := verifyCodeScript.Run(ctx, v.rdb, []string{verificationKey(purpose, subject)}, codeHash, maxAttempts).Int()
+	if err != nil {
+		return VerifyExpired, err
+	}
+	return VerifyResult(result), nil
+}
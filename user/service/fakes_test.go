@@ -0,0 +1,566 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+)
+
+var codePattern = regexp.MustCompile(`[0-9]{6}`)
+
+// fakeUserRepository 内存实现的 UserRepository
+type fakeUserRepository struct {
+	mu        sync.Mutex
+	nextID    int64
+	users     map[string]*model.User
+	interests *fakeInterestRepository
+}
+
+func newFakeUserRepository(interests *fakeInterestRepository) *fakeUserRepository {
+	return &fakeUserRepository{
+		users:     make(map[string]*model.User),
+		interests: interests,
+	}
+}
+
+func (f *fakeUserRepository) FindByUsername(ctx context.Context, username string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if user.Username == username {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (f *fakeUserRepository) CreateUser(ctx context.Context, user *model.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	user.ID = f.nextID
+	copied := *user
+	f.users[user.UserID] = &copied
+	return nil
+}
+
+func (f *fakeUserRepository) FindByUserID(ctx context.Context, userID string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUserRepository) FindByUserIDs(ctx context.Context, userIDs []string) ([]*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var users []*model.User
+	for _, userID := range userIDs {
+		if user, ok := f.users[userID]; ok {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeUserRepository) UpdateLikeEmbedding(ctx context.Context, userID string, embedding pkg.Vector) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[userID]; ok {
+		user.LikeEmbedding = embedding
+		user.EmbeddingStatus = model.EmbeddingStatusReady
+	}
+	return nil
+}
+
+func (f *fakeUserRepository) UpdateEmbeddingStatus(ctx context.Context, userID string, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[userID]; ok {
+		user.EmbeddingStatus = status
+	}
+	return nil
+}
+
+func (f *fakeUserRepository) ListUsersAfterID(ctx context.Context, afterID int64, limit int, statuses []string) ([]*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var users []*model.User
+	for _, user := range f.users {
+		if user.ID > afterID && matchStatus(user.EmbeddingStatus, statuses) {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (f *fakeUserRepository) CountUsersAfterID(ctx context.Context, afterID int64, statuses []string) (int64, error) {
+	users, err := f.ListUsersAfterID(ctx, afterID, len(f.users), statuses)
+	return int64(len(users)), err
+}
+
+// FindSimilarUsers 与 SQL 实现一致：双方全部兴趣向量两两比较后按 aggregation 聚合
+func (f *fakeUserRepository) FindSimilarUsers(ctx context.Context, userID string, aggregation string, limit int) ([]*repository.SimilarUser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := f.vectors(userID)
+	var result []*repository.SimilarUser
+	for id, user := range f.users {
+		if id == userID || user.Disabled {
+			continue
+		}
+		var sims []float64
+		for _, c := range f.vectors(id) {
+			for _, q := range query {
+				sims = append(sims, pkg.CosineSimilarity(q, c))
+			}
+		}
+		if len(sims) == 0 {
+			continue
+		}
+		similarity := sims[0]
+		if aggregation == repository.AggregationMean {
+			var sum float64
+			for _, sim := range sims {
+				sum += sim
+			}
+			similarity = sum / float64(len(sims))
+		} else {
+			for _, sim := range sims[1:] {
+				if sim > similarity {
+					similarity = sim
+				}
+			}
+		}
+		result = append(result, &repository.SimilarUser{User: *user, Similarity: similarity})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Similarity > result[j].Similarity })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (f *fakeUserRepository) ListUsers(ctx context.Context, filter repository.UserFilter, afterID int64, limit int) ([]*model.User, error) {
+	users, err := f.ListUsersAfterID(ctx, afterID, len(f.users), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result []*model.User
+	for _, user := range users {
+		if !strings.HasPrefix(user.Username, filter.UsernamePrefix) {
+			continue
+		}
+		if !filter.CreatedAfter.IsZero() && user.CreatedAt.Before(filter.CreatedAfter) {
+			continue
+		}
+		if !filter.CreatedBefore.IsZero() && !user.CreatedAt.Before(filter.CreatedBefore) {
+			continue
+		}
+		result = append(result, user)
+	}
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (f *fakeUserRepository) UpdateDisabled(ctx context.Context, userID string, disabled bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[userID]; ok {
+		user.Disabled = disabled
+	}
+	return nil
+}
+
+func (f *fakeUserRepository) UpdatePassword(ctx context.Context, userID string, password string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[userID]; ok {
+		user.Password = password
+	}
+	return nil
+}
+
+func (f *fakeUserRepository) UpdateContact(ctx context.Context, userID string, email string, phone string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[userID]; ok {
+		user.EmailVerified = user.EmailVerified && user.Email == email
+		user.PhoneVerified = user.PhoneVerified && user.Phone == phone
+		user.Email, user.Phone = email, phone
+	}
+	return nil
+}
+
+func (f *fakeUserRepository) MarkContactVerified(ctx context.Context, userID string, channel string, contact string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return false, nil
+	}
+	if channel == pkg.ChannelPhone && user.Phone == contact {
+		user.PhoneVerified = true
+		return true, nil
+	}
+	if channel == pkg.ChannelEmail && user.Email == contact {
+		user.EmailVerified = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (f *fakeUserRepository) vectors(userID string) []pkg.Vector {
+	var vectors []pkg.Vector
+	if user, ok := f.users[userID]; ok && user.LikeEmbedding != nil {
+		vectors = append(vectors, user.LikeEmbedding)
+	}
+	interests, _ := f.interests.ListByUserID(context.Background(), userID)
+	for _, interest := range interests {
+		vectors = append(vectors, interest.Embedding)
+	}
+	return vectors
+}
+
+func matchStatus(status string, statuses []string) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeInterestRepository 内存实现的 InterestRepository
+type fakeInterestRepository struct {
+	mu        sync.Mutex
+	nextID    int64
+	interests []*model.UserInterest
+}
+
+func (f *fakeInterestRepository) ListByUserID(ctx context.Context, userID string) ([]*model.UserInterest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var interests []*model.UserInterest
+	for _, interest := range f.interests {
+		if interest.UserID == userID {
+			interests = append(interests, interest)
+		}
+	}
+	return interests, nil
+}
+
+func (f *fakeInterestRepository) ListByUserIDs(ctx context.Context, userIDs []string) ([]*model.UserInterest, error) {
+	var interests []*model.UserInterest
+	for _, userID := range userIDs {
+		list, _ := f.ListByUserID(ctx, userID)
+		interests = append(interests, list...)
+	}
+	return interests, nil
+}
+
+func (f *fakeInterestRepository) UpdateEmbedding(ctx context.Context, interest *model.UserInterest, embedding pkg.Vector) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, stored := range f.interests {
+		if stored.ID == interest.ID {
+			stored.Embedding = embedding
+		}
+	}
+	return nil
+}
+
+func (f *fakeInterestRepository) CreateInterest(ctx context.Context, interest *model.UserInterest, limit int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var count int
+	for _, existing := range f.interests {
+		if existing.UserID != interest.UserID {
+			continue
+		}
+		if existing.Tag == interest.Tag {
+			return repository.ErrInterestExists
+		}
+		count++
+	}
+	if count >= limit {
+		return repository.ErrTooManyInterests
+	}
+	f.nextID++
+	interest.ID = f.nextID
+	f.interests = append(f.interests, interest)
+	return nil
+}
+
+func (f *fakeInterestRepository) DeleteInterest(ctx context.Context, userID string, tag string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, interest := range f.interests {
+		if interest.UserID == userID && interest.Tag == tag {
+			f.interests = append(f.interests[:i], f.interests[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fakeFeedCache 内存实现的 FeedCache
+type fakeFeedCache struct {
+	mu    sync.Mutex
+	feeds map[string][]repository.FeedItem
+}
+
+func (f *fakeFeedCache) Get(ctx context.Context, userID string) ([]repository.FeedItem, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items, ok := f.feeds[userID]
+	return items, ok, nil
+}
+
+func (f *fakeFeedCache) Set(ctx context.Context, userID string, items []repository.FeedItem) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.feeds[userID] = items
+	return nil
+}
+
+// fakeEmbeddingQueue 记录投递的任务
+type fakeEmbeddingQueue struct {
+	mu      sync.Mutex
+	userIDs []string
+}
+
+func (f *fakeEmbeddingQueue) Enqueue(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.userIDs = append(f.userIDs, userID)
+	return nil
+}
+
+func (f *fakeEmbeddingQueue) enqueued() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.userIDs...)
+}
+
+// fakeAuditRepository 记录写入的审计事件
+type fakeAuditRepository struct {
+	mu     sync.Mutex
+	events []*model.AuditEvent
+}
+
+func (f *fakeAuditRepository) CreateEvent(ctx context.Context, event *model.AuditEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	event.ID = int64(len(f.events) + 1)
+	f.events = append(f.events, event)
+	return nil
+}
+
+func (f *fakeAuditRepository) ListEvents(ctx context.Context, filter repository.AuditFilter, beforeID int64, limit int) ([]*model.AuditEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []*model.AuditEvent
+	for i := len(f.events) - 1; i >= 0 && len(events) < limit; i-- {
+		event := f.events[i]
+		if beforeID > 0 && event.ID >= beforeID {
+			continue
+		}
+		if filter.Action != "" && event.Action != filter.Action {
+			continue
+		}
+		if filter.ActorID != "" && event.ActorID != filter.ActorID {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// fakeSessionRepository 内存实现的 SessionRepository
+type fakeSessionRepository struct {
+	mu       sync.Mutex
+	sessions []*model.Session
+}
+
+func (f *fakeSessionRepository) CreateSession(ctx context.Context, session *model.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session.ID = int64(len(f.sessions) + 1)
+	f.sessions = append(f.sessions, session)
+	return nil
+}
+
+func (f *fakeSessionRepository) TouchSession(ctx context.Context, sessionID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, session := range f.sessions {
+		if session.SessionID == sessionID && session.RevokedAt == nil {
+			session.LastSeenAt = time.Now()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeSessionRepository) ListActiveSessions(ctx context.Context, userID string) ([]*model.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var sessions []*model.Session
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (f *fakeSessionRepository) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var revoked int
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (f *fakeSessionRepository) RevokeSession(ctx context.Context, userID string, sessionID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, session := range f.sessions {
+		if session.SessionID == sessionID && session.UserID == userID && session.RevokedAt == nil {
+			now := time.Now()
+			session.RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fakeSender 记录发送的通知
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []pkg.Message
+}
+
+func (f *fakeSender) Send(ctx context.Context, msg pkg.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+// lastCode 返回最近一条通知中的验证码
+func (f *fakeSender) lastCode() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.messages) == 0 {
+		return ""
+	}
+	return codePattern.FindString(f.messages[len(f.messages)-1].Body)
+}
+
+// fakeTwoFactorRepository 数据库部分使用内存实现，Redis 部分使用真实实现
+type fakeTwoFactorRepository struct {
+	repository.TwoFactorRepository
+	users *fakeUserRepository
+	mu    sync.Mutex
+	codes map[string][]string
+}
+
+func (f *fakeTwoFactorRepository) SavePendingSecret(ctx context.Context, userID string, secret string) (bool, error) {
+	f.users.mu.Lock()
+	defer f.users.mu.Unlock()
+	user, ok := f.users.users[userID]
+	if !ok || user.TOTPEnabled {
+		return false, nil
+	}
+	user.TOTPSecret = secret
+	return true, nil
+}
+
+func (f *fakeTwoFactorRepository) EnableTOTP(ctx context.Context, userID string, codeHashes []string) error {
+	f.users.mu.Lock()
+	if user, ok := f.users.users[userID]; ok {
+		user.TOTPEnabled = true
+	}
+	f.users.mu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.codes[userID] = append([]string(nil), codeHashes...)
+	return nil
+}
+
+func (f *fakeTwoFactorRepository) ConsumeRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, hash := range f.codes[userID] {
+		if hash == codeHash {
+			f.codes[userID] = append(f.codes[userID][:i], f.codes[userID][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fakeFencingRepository 内存实现的 FencingRepository
+type fakeFencingRepository struct {
+	mu     sync.Mutex
+	tokens map[string]int64
+}
+
+func (f *fakeFencingRepository) FencedWrite(ctx context.Context, resource string, token int64, fn func(ctx context.Context) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if token < f.tokens[resource] {
+		return repository.ErrStaleFencingToken
+	}
+	f.tokens[resource] = token
+	return fn(ctx)
+}
+
+func (f *fakeFencingRepository) Release(ctx context.Context, resource string, token int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if current, ok := f.tokens[resource]; ok && current <= token {
+		delete(f.tokens, resource)
+	}
+	return nil
+}
+
+// size 返回保留的令牌记录数
+func (f *fakeFencingRepository) size() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.tokens)
+}
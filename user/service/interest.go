@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+	pb "tx-demo/user/proto"
+)
+
+const (
+	maxInterestTagLength = 100
+	maxInterestsPerUser  = 20
+)
+
+// AddInterest 添加兴趣标签，同步计算该标签的嵌入向量
+func (s UserServiceServer) AddInterest(ctx context.Context, req *pb.AddInterestRequest) (*pb.InterestListResponse, error) {
+	s.logger.Info("AddInterest called", zap.String("tag", req.Tag))
+
+	// 1.校验登录状态和标签
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tag, ok := normalizeInterestTag(req.Tag)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, pkg.ErrInvalidInterest)
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.AddInterest")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.提前检查重复和数量上限，避免无谓的嵌入请求，并发添加由仓库在事务中保证
+	interests, err := s.interestRepo.ListByUserID(ctx, userId)
+	if err != nil {
+		s.logger.Error("Failed to query interests", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	for _, interest := range interests {
+		if interest.Tag == tag {
+			return nil, status.Errorf(codes.AlreadyExists, pkg.ErrInterestExists)
+		}
+	}
+	if len(interests) >= maxInterestsPerUser {
+		return nil, status.Errorf(codes.FailedPrecondition, pkg.ErrTooManyInterests)
+	}
+
+	// 4.计算嵌入向量并保存
+	embedding, err := s.embedder.Embed(ctx, tag)
+	if err != nil {
+		s.logger.Error("Failed to embed interest", zap.Error(err))
+		if errors.Is(err, pkg.ErrCircuitOpen) {
+			return nil, status.Errorf(codes.Unavailable, pkg.ErrServiceBusy)
+		}
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	interest := &model.UserInterest{
+		UserID:    userId,
+		Tag:       tag,
+		Embedding: pkg.NewVector(embedding),
+	}
+	if err := s.interestRepo.CreateInterest(ctx, interest, maxInterestsPerUser); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrInterestExists):
+			return nil, status.Errorf(codes.AlreadyExists, pkg.ErrInterestExists)
+		case errors.Is(err, repository.ErrTooManyInterests):
+			return nil, status.Errorf(codes.FailedPrecondition, pkg.ErrTooManyInterests)
+		}
+		s.logger.Error("Failed to create interest", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	interests, err = s.interestRepo.ListByUserID(ctx, userId)
+	if err != nil {
+		s.logger.Error("Failed to query interests", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	s.logger.Info("Interest added successfully", zap.String("user_id", userId), zap.String("tag", tag))
+	return interestList(interests), nil
+}
+
+// RemoveInterest 删除兴趣标签
+func (s UserServiceServer) RemoveInterest(ctx context.Context, req *pb.RemoveInterestRequest) (*pb.InterestListResponse, error) {
+	s.logger.Info("RemoveInterest called", zap.String("tag", req.Tag))
+
+	// 1.校验登录状态和标签
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tag, ok := normalizeInterestTag(req.Tag)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, pkg.ErrInvalidInterest)
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.RemoveInterest")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.删除并返回剩余的兴趣
+	deleted, err := s.interestRepo.DeleteInterest(ctx, userId, tag)
+	if err != nil {
+		s.logger.Error("Failed to delete interest", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if !deleted {
+		return nil, status.Errorf(codes.NotFound, pkg.ErrInterestNotFound)
+	}
+	interests, err := s.interestRepo.ListByUserID(ctx, userId)
+	if err != nil {
+		s.logger.Error("Failed to query interests", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	s.logger.Info("Interest removed successfully", zap.String("user_id", userId), zap.String("tag", tag))
+	return interestList(interests), nil
+}
+
+// ListInterests 获取当前用户的兴趣标签
+func (s UserServiceServer) ListInterests(ctx context.Context, req *emptypb.Empty) (*pb.InterestListResponse, error) {
+	s.logger.Info("ListInterests called")
+
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.ListInterests")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	interests, err := s.interestRepo.ListByUserID(ctx, userId)
+	if err != nil {
+		s.logger.Error("Failed to query interests", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	return interestList(interests), nil
+}
+
+// normalizeInterestTag 去除首尾空白并校验长度
+func normalizeInterestTag(tag string) (string, bool) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" || utf8.RuneCountInString(tag) > maxInterestTagLength {
+		return "", false
+	}
+	return tag, true
+}
+
+func interestList(interests []*model.UserInterest) *pb.InterestListResponse {
+	tags := make([]string, len(interests))
+	for i, interest := range interests {
+		tags[i] = interest.Tag
+	}
+	return &pb.InterestListResponse{Tags: tags}
+}
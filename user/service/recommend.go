@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+	pb "tx-demo/user/proto"
+)
+
+const (
+	defaultRecommendPageSize = 20
+	maxRecommendPageSize     = 100
+)
+
+// recommendConfig 推荐排序参数
+type recommendConfig struct {
+	candidates       int
+	similarityWeight float64
+	recencyWeight    float64
+	recencyHalfLife  time.Duration
+	mmrLambda        float64
+	aggregation      string
+}
+
+func newRecommendConfig(conf *viper.Viper) recommendConfig {
+	config := recommendConfig{
+		candidates:       200,
+		similarityWeight: 0.8,
+		recencyWeight:    0.2,
+		recencyHalfLife:  30 * 24 * time.Hour,
+		mmrLambda:        0.7,
+		aggregation:      repository.AggregationMax,
+	}
+	if conf.IsSet("recommend.candidates") {
+		config.candidates = conf.GetInt("recommend.candidates")
+	}
+	if conf.IsSet("recommend.similarity_weight") {
+		config.similarityWeight = conf.GetFloat64("recommend.similarity_weight")
+	}
+	if conf.IsSet("recommend.recency_weight") {
+		config.recencyWeight = conf.GetFloat64("recommend.recency_weight")
+	}
+	if conf.IsSet("recommend.recency_half_life") {
+		config.recencyHalfLife = conf.GetDuration("recommend.recency_half_life")
+	}
+	if conf.IsSet("recommend.mmr_lambda") {
+		config.mmrLambda = conf.GetFloat64("recommend.mmr_lambda")
+	}
+	if conf.IsSet("recommend.interest_aggregation") {
+		config.aggregation = conf.GetString("recommend.interest_aggregation")
+	}
+	return config
+}
+
+// RecommendUsers 推荐兴趣相近的用户（流式返回，分页）
+func (s UserServiceServer) RecommendUsers(req *pb.RecommendUsersRequest, stream grpc.ServerStreamingServer[pb.RecommendedUser]) error {
+	ctx := stream.Context()
+	s.logger.Info("RecommendUsers called", zap.String("page_token", req.PageToken))
+
+	// 1.校验登录状态和分页参数
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultRecommendPageSize
+	}
+	if pageSize > maxRecommendPageSize {
+		pageSize = maxRecommendPageSize
+	}
+	offset := 0
+	if req.PageToken != "" {
+		offset, err = strconv.Atoi(req.PageToken)
+		if err != nil || offset < 0 {
+			return status.Errorf(codes.InvalidArgument, pkg.ErrInvalidPageToken)
+		}
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.RecommendUsers")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.获取排好序的推荐列表
+	feed, err := s.loadFeed(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if offset >= len(feed) {
+		return nil
+	}
+	end := offset + pageSize
+	if end > len(feed) {
+		end = len(feed)
+	}
+	page := feed[offset:end]
+
+	// 4.查询当前页的用户信息并逐条返回
+	userIDs := make([]string, len(page))
+	for i, item := range page {
+		userIDs[i] = item.UserID
+	}
+	users, err := s.userRepo.FindByUserIDs(ctx, userIDs)
+	if err != nil {
+		s.logger.Error("Failed to query recommended users", zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	byID := make(map[string]*model.User, len(users))
+	for _, user := range users {
+		byID[user.UserID] = user
+	}
+
+	for i, item := range page {
+		user, ok := byID[item.UserID]
+		if !ok {
+			// 缓存期间用户被删除
+			continue
+		}
+		resp := &pb.RecommendedUser{
+			UserId:     user.UserID,
+			Username:   user.Username,
+			Like:       user.Like,
+			Score:      item.Score,
+			Similarity: item.Similarity,
+			Rank:       int32(offset + i),
+		}
+		if i == len(page)-1 && end < len(feed) {
+			resp.NextPageToken = strconv.Itoa(end)
+		}
+		if err := stream.Send(resp); err != nil {
+			s.logger.Error("Failed to send recommended user", zap.Error(err))
+			return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+		}
+	}
+
+	s.logger.Info("Recommended users sent successfully", zap.String("user_id", userId), zap.Int("count", len(page)))
+	return nil
+}
+
+// loadFeed 优先读取缓存，未命中时按 兴趣相似度 + 注册时间 打分并用 MMR 重排后写入缓存
+func (s UserServiceServer) loadFeed(ctx context.Context, userId string) ([]repository.FeedItem, error) {
+	feed, ok, err := s.feedCache.Get(ctx, userId)
+	if err != nil {
+		// 缓存不可用时直接计算
+		s.logger.Warn("Failed to read feed cache", zap.Error(err))
+	}
+	if ok {
+		return feed, nil
+	}
+
+	user, err := s.userRepo.FindByUserID(ctx, userId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Errorf(codes.NotFound, pkg.ErrUserNotFound)
+		}
+		s.logger.Error("Failed to query user", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if user.LikeEmbedding == nil {
+		// 喜好向量尚未生成时，需要至少有一个兴趣标签
+		interests, err := s.interestRepo.ListByUserID(ctx, userId)
+		if err != nil {
+			s.logger.Error("Failed to query interests", zap.Error(err))
+			return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+		}
+		if len(interests) == 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, pkg.ErrEmbeddingNotReady)
+		}
+	}
+
+	config := newRecommendConfig(s.conf)
+	candidates, err := s.userRepo.FindSimilarUsers(ctx, userId, config.aggregation, config.candidates)
+	if err != nil {
+		s.logger.Error("Failed to query similar users", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	vectors, err := s.diversityVectors(ctx, candidates)
+	if err != nil {
+		s.logger.Error("Failed to query candidate interests", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	feed = rankFeed(candidates, vectors, config, time.Now())
+	if err := s.feedCache.Set(ctx, userId, feed); err != nil {
+		s.logger.Warn("Failed to write feed cache", zap.Error(err))
+	}
+	return feed, nil
+}
+
+// diversityVectors 返回 MMR 计算候选之间相似度使用的向量
+// 喜好向量已就绪时直接使用，否则使用兴趣向量的平均值，两者都没有的候选不参与多样性惩罚
+func (s UserServiceServer) diversityVectors(ctx context.Context, candidates []*repository.SimilarUser) (map[string]pkg.Vector, error) {
+	vectors := make(map[string]pkg.Vector, len(candidates))
+	var pending []string
+	for _, c := range candidates {
+		if c.LikeEmbedding != nil && c.EmbeddingStatus == model.EmbeddingStatusReady {
+			vectors[c.UserID] = c.LikeEmbedding
+		} else {
+			pending = append(pending, c.UserID)
+		}
+	}
+	if len(pending) == 0 {
+		return vectors, nil
+	}
+
+	interests, err := s.interestRepo.ListByUserIDs(ctx, pending)
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[string][]pkg.Vector, len(pending))
+	for _, interest := range interests {
+		byUser[interest.UserID] = append(byUser[interest.UserID], interest.Embedding)
+	}
+	for userID, embeddings := range byUser {
+		if mean := pkg.MeanVector(embeddings); mean != nil {
+			vectors[userID] = mean
+		}
+	}
+	return vectors, nil
+}
+
+// rankFeed 计算综合得分并用 MMR 重排，避免推荐列表被同一类兴趣占满
+// vectors 为候选用于多样性计算的向量，缺失的候选不受多样性惩罚
+func rankFeed(candidates []*repository.SimilarUser, vectors map[string]pkg.Vector, config recommendConfig, now time.Time) []repository.FeedItem {
+	items := make([]pkg.RankItem, len(candidates))
+	scores := make(map[string]repository.FeedItem, len(candidates))
+	for i, c := range candidates {
+		score := config.similarityWeight*c.Similarity + config.recencyWeight*pkg.RecencyScore(c.CreatedAt, now, config.recencyHalfLife)
+		items[i] = pkg.RankItem{ID: c.UserID, Relevance: score, Vector: vectors[c.UserID]}
+		scores[c.UserID] = repository.FeedItem{UserID: c.UserID, Score: score, Similarity: c.Similarity}
+	}
+	// 先按得分排序，保证得分相同的候选顺序稳定
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Relevance > items[j].Relevance
+	})
+
+	ranked := pkg.MMR(items, config.mmrLambda, 0)
+	feed := make([]repository.FeedItem, len(ranked))
+	for i, item := range ranked {
+		feed[i] = scores[item.ID]
+	}
+	return feed
+}
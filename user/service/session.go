@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	pb "tx-demo/user/proto"
+)
+
+// createSession 根据请求的 metadata 和客户端 IP 记录一次登录会话
+func (s UserServiceServer) createSession(ctx context.Context, userId string) (*model.Session, error) {
+	now := time.Now()
+	session := &model.Session{
+		SessionID:  pkg.GenerateUUID(),
+		UserID:     userId,
+		Device:     truncate(firstMetadata(ctx, "device"), 100),
+		UserAgent:  truncate(firstMetadata(ctx, "user-agent"), 255),
+		IP:         pkg.ClientIP(ctx),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(pkg.TokenExpiration),
+	}
+	return session, s.sessionRepo.CreateSession(ctx, session)
+}
+
+// ListSessions 获取当前用户的活跃会话
+func (s UserServiceServer) ListSessions(ctx context.Context, req *emptypb.Empty) (*pb.ListSessionsResponse, error) {
+	s.logger.Info("ListSessions called")
+
+	// 1.校验登录状态
+	claims, err := s.authenticateClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.ListSessions")
+	span.SetTag("userId", claims.Subject)
+	defer span.Finish()
+
+	// 3.查询活跃会话
+	sessions, err := s.sessionRepo.ListActiveSessions(ctx, claims.Subject)
+	if err != nil {
+		s.logger.Error("Failed to list sessions", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	resp := &pb.ListSessionsResponse{}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &pb.Session{
+			SessionId:  session.SessionID,
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			Ip:         session.IP,
+			CreateAt:   timestamppb.New(session.CreatedAt),
+			LastSeenAt: timestamppb.New(session.LastSeenAt),
+			Current:    session.SessionID == claims.SessionID,
+		})
+	}
+	return resp, nil
+}
+
+// RevokeSession 吊销当前用户的会话，该会话的令牌立即失效
+func (s UserServiceServer) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*emptypb.Empty, error) {
+	s.logger.Info("RevokeSession called", zap.String("session_id", req.SessionId))
+
+	// 1.校验登录状态
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.RevokeSession")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.只能吊销自己的会话
+	revoked, err := s.sessionRepo.RevokeSession(ctx, userId, req.SessionId)
+	if err != nil {
+		s.logger.Error("Failed to revoke session", zap.Error(err))
+		s.audit(ctx, userId, model.AuditActionRevokeSession, req.SessionId, model.AuditOutcomeFailure, err.Error())
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if !revoked {
+		return nil, status.Errorf(codes.NotFound, pkg.ErrSessionNotFound)
+	}
+
+	s.audit(ctx, userId, model.AuditActionRevokeSession, req.SessionId, model.AuditOutcomeSuccess, "")
+	s.logger.Info("Session revoked successfully", zap.String("user_id", userId), zap.String("session_id", req.SessionId))
+	return &emptypb.Empty{}, nil
+}
+
+// firstMetadata 获取 metadata 中 key 的第一个值
+func firstMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// truncate 按字符数截断，避免超出列长度
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	pb "tx-demo/user/proto"
+)
+
+// totpConfig 两步验证参数
+type totpConfig struct {
+	issuer            string
+	skew              int
+	challengeTTL      time.Duration
+	maxAttempts       int
+	maxFailures       int
+	lockout           time.Duration
+	recoveryCodeCount int
+}
+
+func newTOTPConfig(conf *viper.Viper) totpConfig {
+	config := totpConfig{
+		issuer:            "tx-demo",
+		skew:              1,
+		challengeTTL:      5 * time.Minute,
+		maxAttempts:       5,
+		maxFailures:       10,
+		lockout:           15 * time.Minute,
+		recoveryCodeCount: 10,
+	}
+	if conf.IsSet("totp.issuer") {
+		config.issuer = conf.GetString("totp.issuer")
+	}
+	if conf.IsSet("totp.skew") {
+		config.skew = conf.GetInt("totp.skew")
+	}
+	if conf.IsSet("totp.challenge_ttl") {
+		config.challengeTTL = conf.GetDuration("totp.challenge_ttl")
+	}
+	if conf.IsSet("totp.max_attempts") {
+		config.maxAttempts = conf.GetInt("totp.max_attempts")
+	}
+	if conf.IsSet("totp.max_failures") {
+		config.maxFailures = conf.GetInt("totp.max_failures")
+	}
+	if conf.IsSet("totp.lockout") {
+		config.lockout = conf.GetDuration("totp.lockout")
+	}
+	if conf.IsSet("totp.recovery_codes") {
+		config.recoveryCodeCount = conf.GetInt("totp.recovery_codes")
+	}
+	return config
+}
+
+// EnrollTOTP 生成新的身份验证器密钥，调用 ConfirmTOTP 校验通过后才开启两步验证
+func (s UserServiceServer) EnrollTOTP(ctx context.Context, req *emptypb.Empty) (*pb.EnrollTOTPResponse, error) {
+	s.logger.Info("EnrollTOTP called")
+
+	// 1.校验登录状态
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.EnrollTOTP")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.生成密钥，加密后保存
+	user, err := s.userRepo.FindByUserID(ctx, userId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Errorf(codes.NotFound, pkg.ErrUserNotFound)
+		}
+		s.logger.Error("Failed to query user", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	secret, err := pkg.GenerateTOTPSecret()
+	if err != nil {
+		s.logger.Error("Failed to generate totp secret", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	encrypted, err := s.cipher.Encrypt(secret)
+	if err != nil {
+		s.logger.Error("Failed to encrypt totp secret", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	saved, err := s.twoFactor.SavePendingSecret(ctx, userId, encrypted)
+	if err != nil {
+		s.logger.Error("Failed to save totp secret", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if !saved {
+		return nil, status.Errorf(codes.FailedPrecondition, pkg.ErrTOTPEnabled)
+	}
+
+	s.audit(ctx, userId, model.AuditActionEnrollTOTP, userId, model.AuditOutcomeSuccess, "")
+	return &pb.EnrollTOTPResponse{
+		Secret:     secret,
+		OtpauthUri: pkg.TOTPURI(newTOTPConfig(s.conf).issuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP 校验动态口令后开启两步验证，并生成一次性恢复码
+func (s UserServiceServer) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	s.logger.Info("ConfirmTOTP called")
+
+	// 1.校验登录状态
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.ConfirmTOTP")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.校验待确认密钥生成的口令
+	user, err := s.userRepo.FindByUserID(ctx, userId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Errorf(codes.NotFound, pkg.ErrUserNotFound)
+		}
+		s.logger.Error("Failed to query user", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if user.TOTPEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, pkg.ErrTOTPEnabled)
+	}
+	if user.TOTPSecret == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, pkg.ErrTOTPNotEnrolled)
+	}
+	if err := s.checkTOTP(ctx, user, req.Code); err != nil {
+		s.audit(ctx, userId, model.AuditActionEnableTOTP, userId, model.AuditOutcomeFailure, "invalid code")
+		return nil, err
+	}
+
+	// 4.生成恢复码，只保存哈希
+	resp := &pb.ConfirmTOTPResponse{}
+	var hashes []string
+	for i := 0; i < newTOTPConfig(s.conf).recoveryCodeCount; i++ {
+		code, err := pkg.GenerateRecoveryCode()
+		if err != nil {
+			s.logger.Error("Failed to generate recovery code", zap.Error(err))
+			return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+		}
+		resp.RecoveryCodes = append(resp.RecoveryCodes, code)
+		hashes = append(hashes, s.hashRecoveryCode(code))
+	}
+	if err := s.twoFactor.EnableTOTP(ctx, userId, hashes); err != nil {
+		s.logger.Error("Failed to enable totp", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	s.audit(ctx, userId, model.AuditActionEnableTOTP, userId, model.AuditOutcomeSuccess, "")
+	s.logger.Info("TOTP enabled", zap.String("user_id", userId))
+	return resp, nil
+}
+
+// VerifyTOTP 两步登录的第二步，校验通过后签发访问令牌
+func (s UserServiceServer) VerifyTOTP(ctx context.Context, req *pb.VerifyTOTPRequest) (*pb.LoginResponse, error) {
+	s.logger.Info("VerifyTOTP called")
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.VerifyTOTP")
+	defer span.Finish()
+
+	// 1.校验并占用挑战令牌，超过尝试次数后挑战作废，并发请求中只有一个能继续校验
+	config := newTOTPConfig(s.conf)
+	challengeHash := pkg.HashCode(req.ChallengeToken, s.jwt.JwtKey)
+	userId, err := s.twoFactor.CheckChallenge(ctx, challengeHash, config.maxAttempts)
+	if err != nil {
+		s.logger.Error("Failed to check challenge", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if userId == "" {
+		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrChallengeExpired)
+	}
+	span.SetTag("userId", userId)
+
+	user, err := s.userRepo.FindByUserID(ctx, userId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.deleteChallenge(ctx, challengeHash)
+			return nil, status.Errorf(codes.Unauthenticated, pkg.ErrChallengeExpired)
+		}
+		s.releaseChallenge(ctx, challengeHash)
+		s.logger.Error("Failed to query user", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if user.Disabled {
+		s.deleteChallenge(ctx, challengeHash)
+		s.audit(ctx, userId, model.AuditActionLogin, userId, model.AuditOutcomeDenied, "user disabled")
+		return nil, status.Errorf(codes.PermissionDenied, pkg.ErrUserDisabled)
+	}
+
+	// 2.同一用户累计失败次数过多时拒绝，防止不断重新登录换取新挑战来穷举口令
+	if err := s.checkTOTPLocked(ctx, userId, config); err != nil {
+		s.deleteChallenge(ctx, challengeHash)
+		return nil, err
+	}
+
+	// 3.校验动态口令或恢复码，失败时释放挑战并累加失败次数
+	method := "totp"
+	if req.RecoveryCode != "" {
+		method = "recovery_code"
+		err = s.consumeRecoveryCode(ctx, userId, req.RecoveryCode)
+	} else {
+		err = s.checkTOTP(ctx, user, req.Code)
+	}
+	if err != nil {
+		if status.Code(err) == codes.Unauthenticated {
+			if _, recordErr := s.twoFactor.RecordFailure(ctx, userId, config.lockout); recordErr != nil {
+				s.logger.Error("Failed to record totp failure", zap.Error(recordErr))
+			}
+		}
+		s.releaseChallenge(ctx, challengeHash)
+		s.audit(ctx, userId, model.AuditActionLogin, userId, model.AuditOutcomeFailure, "invalid "+method)
+		return nil, err
+	}
+
+	// 4.挑战只能使用一次，校验期间一直被本请求占用
+	if _, err := s.twoFactor.DeleteChallenge(ctx, challengeHash); err != nil {
+		s.logger.Error("Failed to delete challenge", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if err := s.twoFactor.ResetFailures(ctx, userId); err != nil {
+		s.logger.Warn("Failed to reset totp failures", zap.Error(err))
+	}
+	return s.issueToken(ctx, user, method)
+}
+
+// checkTOTPLocked 用户在 lockout 时间内累计失败达到 maxFailures 次时返回错误
+func (s UserServiceServer) checkTOTPLocked(ctx context.Context, userId string, config totpConfig) error {
+	failures, err := s.twoFactor.Failures(ctx, userId)
+	if err != nil {
+		s.logger.Error("Failed to query totp failures", zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if failures >= int64(config.maxFailures) {
+		s.audit(ctx, userId, model.AuditActionLogin, userId, model.AuditOutcomeDenied, "two-factor locked")
+		return status.Errorf(codes.ResourceExhausted, pkg.ErrTOTPLocked)
+	}
+	return nil
+}
+
+// releaseChallenge 释放挑战的占用，失败只记录日志，挑战过期后自动失效
+func (s UserServiceServer) releaseChallenge(ctx context.Context, challengeHash string) {
+	if err := s.twoFactor.ReleaseChallenge(ctx, challengeHash); err != nil {
+		s.logger.Error("Failed to release challenge", zap.Error(err))
+	}
+}
+
+// deleteChallenge 作废挑战，失败只记录日志
+func (s UserServiceServer) deleteChallenge(ctx context.Context, challengeHash string) {
+	if _, err := s.twoFactor.DeleteChallenge(ctx, challengeHash); err != nil {
+		s.logger.Error("Failed to delete challenge", zap.Error(err))
+	}
+}
+
+// createChallenge 密码校验通过后生成挑战令牌，Redis 中只保存哈希；两步验证被锁定时不再生成
+func (s UserServiceServer) createChallenge(ctx context.Context, user *model.User) (*pb.LoginResponse, error) {
+	config := newTOTPConfig(s.conf)
+	if err := s.checkTOTPLocked(ctx, user.UserID, config); err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		s.logger.Error("Failed to generate challenge", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(raw)
+	if err := s.twoFactor.CreateChallenge(ctx, pkg.HashCode(challenge, s.jwt.JwtKey), user.UserID, config.challengeTTL); err != nil {
+		s.logger.Error("Failed to save challenge", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	s.logger.Info("Two-factor challenge issued", zap.String("user_id", user.UserID))
+	return &pb.LoginResponse{
+		ExpiresIn:         int64(config.challengeTTL.Seconds()),
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	}, nil
+}
+
+// checkTOTP 校验动态口令，同一时间步的口令只能使用一次
+func (s UserServiceServer) checkTOTP(ctx context.Context, user *model.User, code string) error {
+	secret, err := s.cipher.Decrypt(user.TOTPSecret)
+	if err != nil {
+		s.logger.Error("Failed to decrypt totp secret", zap.String("user_id", user.UserID), zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	step, ok := pkg.ValidateTOTP(secret, code, time.Now(), newTOTPConfig(s.conf).skew)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, pkg.ErrInvalidTOTP)
+	}
+	fresh, err := s.twoFactor.UseTOTPStep(ctx, user.UserID, step)
+	if err != nil {
+		s.logger.Error("Failed to record totp step", zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if !fresh {
+		return status.Errorf(codes.Unauthenticated, pkg.ErrInvalidTOTP)
+	}
+	return nil
+}
+
+// consumeRecoveryCode 校验并作废恢复码
+func (s UserServiceServer) consumeRecoveryCode(ctx context.Context, userId string, code string) error {
+	used, err := s.twoFactor.ConsumeRecoveryCode(ctx, userId, s.hashRecoveryCode(code))
+	if err != nil {
+		s.logger.Error("Failed to consume recovery code", zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if !used {
+		return status.Errorf(codes.Unauthenticated, pkg.ErrInvalidTOTP)
+	}
+	return nil
+}
+
+func (s UserServiceServer) hashRecoveryCode(code string) string {
+	return pkg.HashCode("recovery:"+pkg.NormalizeRecoveryCode(code), s.jwt.JwtKey)
+}
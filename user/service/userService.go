@@ -9,47 +9,93 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
+	"time"
 	"tx-demo/pkg"
 	"tx-demo/repository"
 
 	"tx-demo/model"
 	pb "tx-demo/user/proto"
+	"tx-demo/user/worker"
 )
 
 type UserServiceServer struct {
 	pb.UnimplementedUserServiceServer
-	logger      *zap.Logger
-	jwt         *pkg.JWT
-	userRepo    repository.UserRepository
-	opentracing opentracing.Tracer
-	conf        *viper.Viper
-	rdb         *redis.Client
+	logger       *zap.Logger
+	jwt          *pkg.JWT
+	userRepo     repository.UserRepository
+	opentracing  opentracing.Tracer
+	conf         *viper.Viper
+	rdb          *redis.Client
+	queue        worker.EmbeddingQueue
+	feedCache    repository.FeedCache
+	interestRepo repository.InterestRepository
+	embedder     pkg.Embedder
+	tokenRepo    repository.TokenRepository
+	auditRepo    repository.AuditRepository
+	sessionRepo  repository.SessionRepository
+	verifyRepo   repository.VerificationRepository
+	sender       pkg.Sender
+	twoFactor    repository.TwoFactorRepository
+	cipher       *pkg.Cipher
+	fencing      repository.FencingRepository
+	lockNodes    repository.LockNodes
 }
 
-func NewUserServiceServer(logger *zap.Logger, jwt *pkg.JWT, userRepo repository.UserRepository, opentracing opentracing.Tracer, conf *viper.Viper, rdb *redis.Client) UserServiceServer {
+func NewUserServiceServer(logger *zap.Logger, jwt *pkg.JWT, userRepo repository.UserRepository, opentracing opentracing.Tracer, conf *viper.Viper, rdb *redis.Client, queue worker.EmbeddingQueue, feedCache repository.FeedCache, interestRepo repository.InterestRepository, embedder pkg.Embedder, tokenRepo repository.TokenRepository, auditRepo repository.AuditRepository, sessionRepo repository.SessionRepository, verifyRepo repository.VerificationRepository, sender pkg.Sender, twoFactor repository.TwoFactorRepository, cipher *pkg.Cipher, fencing repository.FencingRepository, lockNodes repository.LockNodes) UserServiceServer {
 	return UserServiceServer{
-		logger:      logger,
-		jwt:         jwt,
-		userRepo:    userRepo,
-		opentracing: opentracing,
-		conf:        conf,
-		rdb:         rdb,
+		logger:       logger,
+		jwt:          jwt,
+		userRepo:     userRepo,
+		opentracing:  opentracing,
+		conf:         conf,
+		rdb:          rdb,
+		queue:        queue,
+		feedCache:    feedCache,
+		interestRepo: interestRepo,
+		embedder:     embedder,
+		tokenRepo:    tokenRepo,
+		auditRepo:    auditRepo,
+		sessionRepo:  sessionRepo,
+		verifyRepo:   verifyRepo,
+		sender:       sender,
+		twoFactor:    twoFactor,
+		cipher:       cipher,
+		fencing:      fencing,
+		lockNodes:    lockNodes,
 	}
 }
 
+// lockFenceTTL 业务锁的 key 含用户输入，栅栏令牌计数器在最后一次加锁后过期，远大于锁的有效期
+const lockFenceTTL = time.Hour
+
+// newLock 配置了多个加锁节点时使用 Redlock，否则使用单节点锁，name 为固定的指标名
+func (s UserServiceServer) newLock(name string, key string) pkg.Locker {
+	config := pkg.DefaultLockConfig
+	config.MetricName = name
+	config.FenceTTL = lockFenceTTL
+	if len(s.lockNodes) > 0 {
+		return pkg.NewRedlock(s.lockNodes, s.logger, key, config)
+	}
+	return pkg.NewRedisLock(s.rdb, s.logger, key, config)
+}
+
 // Register 用户注册（幂等）
 func (s UserServiceServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
 	s.logger.Info("Register called", zap.String("username", req.Username))
 
+	email, phone, err := normalizeContact(req.Email, req.Phone)
+	if err != nil {
+		return nil, err
+	}
+
 	// 获取分布式锁保证幂等性
 	lockKey := fmt.Sprintf("register:lock:%s", req.Username)
-	lock := pkg.NewRedisLock(s.rdb, s.logger, lockKey, pkg.DefaultLockConfig)
-	acquired, err := lock.Lock(ctx)
+	lock := s.newLock("register", lockKey)
+	token, acquired, err := lock.Lock(ctx)
 	if err != nil {
 		// 如果获取锁失败，则记录日志并返回内部错误
 		s.logger.Error("Failed to acquire lock", zap.Error(err))
@@ -57,6 +103,7 @@ func (s UserServiceServer) Register(ctx context.Context, req *pb.RegisterRequest
 	}
 	if !acquired {
 		// 如果未能获取锁，则返回资源冲突错误
+		s.logger.Warn("Register lock busy", zap.String("username", req.Username))
 		return nil, status.Error(codes.ResourceExhausted, pkg.ErrServiceBusy)
 	}
 
@@ -74,6 +121,7 @@ func (s UserServiceServer) Register(ctx context.Context, req *pb.RegisterRequest
 	_, err = s.userRepo.FindByUsername(ctx, req.Username)
 	if err == nil {
 		// 如果用户名已存在，则返回错误
+		s.audit(ctx, "", model.AuditActionRegister, "", model.AuditOutcomeFailure, "username already in use: "+req.Username)
 		return nil, status.Errorf(codes.AlreadyExists, pkg.ErrAccountAlreadyUse)
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		// 如果查询过程中发生其他错误，则记录日志并返回内部错误
@@ -85,39 +133,59 @@ func (s UserServiceServer) Register(ctx context.Context, req *pb.RegisterRequest
 	userId := pkg.GenerateUUID()
 	// 加密
 	hashedPassword := pkg.HashPassword(req.Password)
-	// 将喜好嵌入向量
-	likeEmbedding, err := pkg.NewClient(s.conf.GetString("security.dashscope_api_key.key")).GetEmbeddings(req.Like, "text-embedding-v3", "1024")
-	if err != nil {
-		// 如果嵌入过程中发生错误，则记录日志并返回内部错误
-		s.logger.Error("Embedding failed", zap.Error(err))
-		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
-	}
 
 	// 3.使用jeager实现链路追踪
 	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.Register")
 	span.SetTag("userId", userId)
 	defer span.Finish()
 
+	// 喜好的嵌入向量由后台异步计算
 	newUser := &model.User{
-		UserID:        userId,
-		Username:      req.Username,
-		Password:      hashedPassword,
-		Like:          req.Like,
-		LikeEmbedding: pkg.ConvertToPGVector(likeEmbedding.Data[0].Embedding),
+		UserID:          userId,
+		Username:        req.Username,
+		Password:        hashedPassword,
+		Like:            req.Like,
+		EmbeddingStatus: model.EmbeddingStatusPending,
+		Email:           email,
+		Phone:           phone,
 	}
 
-	// 4.用户不存在,创建用户
-	err = s.userRepo.CreateUser(ctx, newUser)
+	// 4.用户不存在,创建用户，锁已丢失时放弃写入，避免与其他请求并发创建
+	select {
+	case <-lock.Lost():
+		s.logger.Error("Lock lost before creating user", zap.String("username", req.Username))
+		return nil, status.Error(codes.Aborted, pkg.ErrServiceBusy)
+	default:
+	}
+	// 携带栅栏令牌写入，锁过期后被他人重新获取时拒绝写入
+	err = s.fencing.FencedWrite(ctx, lockKey, token, func(ctx context.Context) error {
+		return s.userRepo.CreateUser(ctx, newUser)
+	})
+	if errors.Is(err, repository.ErrStaleFencingToken) {
+		s.logger.Error("Stale fencing token when creating user", zap.String("username", req.Username), zap.Int64("token", token))
+		return nil, status.Error(codes.Aborted, pkg.ErrServiceBusy)
+	}
 	if err != nil {
 		// 如果创建过程中发生其他错误，则记录日志并返回内部错误
 		s.logger.Error("Failed to create user", zap.Error(err))
+		s.audit(ctx, "", model.AuditActionRegister, "", model.AuditOutcomeFailure, "create user failed: "+req.Username)
 		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
 	}
+	// 用户已创建，之后的重复注册由用户名唯一索引拒绝，不再需要令牌记录
+	if err := s.fencing.Release(ctx, lockKey, token); err != nil {
+		s.logger.Warn("Failed to release fencing token", zap.String("username", req.Username), zap.Error(err))
+	}
 
-	s.logger.Info("User registered successfully", zap.String("user_id", req.Username))
+	// 5.投递嵌入任务，失败时用户保持 pending 状态，可由回填任务补齐
+	if err := s.queue.Enqueue(ctx, userId); err != nil {
+		s.logger.Error("Failed to enqueue embedding job", zap.String("user_id", userId), zap.Error(err))
+	}
+
+	s.audit(ctx, userId, model.AuditActionRegister, userId, model.AuditOutcomeSuccess, "")
+	s.logger.Info("User registered successfully", zap.String("user_id", userId), zap.String("username", req.Username))
 
 	return &pb.RegisterResponse{
-		UserId:  req.Username,
+		UserId:  userId,
 		Message: "注册成功！",
 	}, nil
 }
@@ -132,6 +200,7 @@ func (s UserServiceServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb
 	user, err := s.userRepo.FindByUsername(ctx, req.Username)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.audit(ctx, "", model.AuditActionLogin, "", model.AuditOutcomeFailure, "unknown username: "+req.Username)
 			return nil, status.Errorf(codes.NotFound, pkg.ErrUserNotFound)
 		}
 		// 如果查询过程中发生其他错误，则记录日志并返回内部错误
@@ -146,17 +215,39 @@ func (s UserServiceServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb
 
 	// 3.验证密码
 	if pkg.HashPassword(req.Password) != user.Password {
+		s.audit(ctx, user.UserID, model.AuditActionLogin, user.UserID, model.AuditOutcomeFailure, "wrong password")
 		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrPassword)
 	}
+	if user.Disabled {
+		s.audit(ctx, user.UserID, model.AuditActionLogin, user.UserID, model.AuditOutcomeDenied, "user disabled")
+		return nil, status.Errorf(codes.PermissionDenied, pkg.ErrUserDisabled)
+	}
+
+	// 4.开启两步验证时返回挑战令牌，通过 VerifyTOTP 完成登录
+	if user.TOTPEnabled {
+		return s.createChallenge(ctx, user)
+	}
+
+	// 5.记录会话并生成JWT令牌
+	return s.issueToken(ctx, user, "")
+}
+
+// issueToken 记录登录会话并生成与会话绑定的JWT令牌
+func (s UserServiceServer) issueToken(ctx context.Context, user *model.User, detail string) (*pb.LoginResponse, error) {
+	session, err := s.createSession(ctx, user.UserID)
+	if err != nil {
+		s.logger.Error("Failed to create session", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
 
-	// 4.生成JWT令牌
-	token, expiresIn, err := pkg.GenerateJWT(user.UserID, *s.jwt)
+	token, expiresIn, err := pkg.GenerateJWT(user.UserID, session.SessionID, *s.jwt)
 	if err != nil {
 		// 如果生成过程中发生错误，则记录日志并返回内部错误
 		s.logger.Error("Failed to generate token", zap.Error(err))
 		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
 	}
 
+	s.audit(ctx, user.UserID, model.AuditActionLogin, user.UserID, model.AuditOutcomeSuccess, detail)
 	s.logger.Info("User logged in successfully", zap.String("user_id", user.UserID))
 
 	return &pb.LoginResponse{
@@ -170,22 +261,9 @@ func (s UserServiceServer) GetUserInfo(ctx context.Context, req *emptypb.Empty)
 	s.logger.Info("GetUserInfo called")
 
 	// 1.从metadata获取token
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrUnauthorized)
-	}
-
-	tokens := md.Get("token")
-	if len(tokens) == 0 {
-		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrUnauthorized)
-	}
-	token := tokens[0]
-
-	userId, err := pkg.ParseJWT(token, *s.jwt)
+	userId, err := s.authenticate(ctx)
 	if err != nil {
-		// 如果解析过程中发生错误，则记录日志并返回错误
-		s.logger.Info("Parsing failed", zap.Error(err))
-		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+		return nil, err
 	}
 
 	// 2.使用jeager实现链路追踪
@@ -209,10 +287,67 @@ func (s UserServiceServer) GetUserInfo(ctx context.Context, req *emptypb.Empty)
 	s.logger.Info("user info retrieved successfully", zap.String("user_id", user.UserID))
 
 	return &pb.UserInfoResponse{
-		UserId:   user.UserID,
-		Username: user.Username,
-		Like:     user.Like,
-		CreateAt: timestamppb.New(user.CreatedAt),
-		UpdateAt: timestamppb.New(user.UpdatedAt),
+		UserId:          user.UserID,
+		Username:        user.Username,
+		Like:            user.Like,
+		CreateAt:        timestamppb.New(user.CreatedAt),
+		UpdateAt:        timestamppb.New(user.UpdatedAt),
+		EmbeddingStatus: user.EmbeddingStatus,
+		Email:           user.Email,
+		Phone:           user.Phone,
+		EmailVerified:   user.EmailVerified,
+		PhoneVerified:   user.PhoneVerified,
 	}, nil
 }
+
+// authenticate 从metadata获取token并解析，返回当前登录的用户ID
+func (s UserServiceServer) authenticate(ctx context.Context) (string, error) {
+	claims, err := s.authenticateClaims(ctx)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// authenticateClaims 解析并校验token，返回令牌声明
+func (s UserServiceServer) authenticateClaims(ctx context.Context) (*pkg.Claims, error) {
+	claims, err := pkg.AuthenticateToken(ctx, *s.jwt, s.tokenRepo, s.sessionRepo)
+	switch {
+	case err == nil:
+		return claims, nil
+	case errors.Is(err, pkg.ErrAuthMissingToken):
+		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrUnauthorized)
+	case errors.Is(err, pkg.ErrAuthInvalidToken):
+		// 如果解析过程中发生错误，则记录日志并返回错误
+		s.logger.Info("Parsing failed", zap.Error(err))
+		s.audit(ctx, "", model.AuditActionTokenInvalid, "", model.AuditOutcomeFailure, err.Error())
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	case errors.Is(err, pkg.ErrAuthTokenRevoked):
+		// 被强制下线或禁用后，此前签发的令牌失效
+		s.audit(ctx, claims.Subject, model.AuditActionTokenRevoked, claims.Subject, model.AuditOutcomeDenied, "")
+		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrTokenRevoked)
+	case errors.Is(err, pkg.ErrAuthSessionRevoked):
+		// 会话被吊销或过期后，绑定的令牌失效
+		s.audit(ctx, claims.Subject, model.AuditActionTokenRevoked, claims.Subject, model.AuditOutcomeDenied, "session revoked: "+claims.SessionID)
+		return nil, status.Errorf(codes.Unauthenticated, pkg.ErrTokenRevoked)
+	default:
+		s.logger.Error("Failed to authenticate token", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+}
+
+// audit 记录安全相关事件，写入失败只记录日志，不影响请求结果
+func (s UserServiceServer) audit(ctx context.Context, actorID, action, targetID, outcome, detail string) {
+	event := &model.AuditEvent{
+		ActorID:  actorID,
+		Action:   action,
+		TargetID: targetID,
+		Outcome:  outcome,
+		IP:       pkg.ClientIP(ctx),
+		TraceID:  pkg.TraceIDFromContext(ctx),
+		Detail:   detail,
+	}
+	if err := s.auditRepo.CreateEvent(ctx, event); err != nil {
+		s.logger.Error("Failed to write audit event", zap.String("action", action), zap.Error(err))
+	}
+}
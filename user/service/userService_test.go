@@ -0,0 +1,803 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+	pb "tx-demo/user/proto"
+)
+
+// testEnv 通过 bufconn 在内存中启动的 UserService
+type testEnv struct {
+	client    pb.UserServiceClient
+	users     *fakeUserRepository
+	interests *fakeInterestRepository
+	queue     *fakeEmbeddingQueue
+	tokens    repository.TokenRepository
+	audits    *fakeAuditRepository
+	sessions  *fakeSessionRepository
+	sender    *fakeSender
+	fencing   *fakeFencingRepository
+	embedder  pkg.Embedder
+	redis     *miniredis.Miniredis
+	jwt       *pkg.JWT
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	conf := viper.New()
+	conf.Set("security.jwt.key", "test-key")
+	conf.Set("security.encryption.key", "test-encryption-key")
+	jwt := pkg.NewJwt(conf)
+	cipher, err := pkg.NewCipher(conf)
+	if err != nil {
+		t.Fatalf("NewCipher() error = %v", err)
+	}
+
+	env := &testEnv{
+		interests: &fakeInterestRepository{},
+		queue:     &fakeEmbeddingQueue{},
+		tokens:    repository.NewTokenRepository(repository.NewRepository(nil, rdb)),
+		audits:    &fakeAuditRepository{},
+		sessions:  &fakeSessionRepository{},
+		sender:    &fakeSender{},
+		fencing:   &fakeFencingRepository{tokens: make(map[string]int64)},
+		embedder:  pkg.NewLocalEmbedder(64),
+		redis:     mr,
+		jwt:       jwt,
+	}
+	env.users = newFakeUserRepository(env.interests)
+	twoFactor := &fakeTwoFactorRepository{
+		TwoFactorRepository: repository.NewTwoFactorRepository(repository.NewRepository(nil, rdb)),
+		users:               env.users,
+		codes:               make(map[string][]string),
+	}
+	feedCache := &fakeFeedCache{feeds: make(map[string][]repository.FeedItem)}
+
+	svc := NewUserServiceServer(zap.NewNop(), jwt, env.users, opentracing.NoopTracer{}, conf, rdb, env.queue, feedCache, env.interests, env.embedder, env.tokens, env.audits, env.sessions, repository.NewVerificationRepository(repository.NewRepository(nil, rdb)), env.sender, twoFactor, cipher, env.fencing, nil)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(pkg.JaegerServerInterceptor(opentracing.NoopTracer{})))
+	pb.RegisterUserServiceServer(server, &svc)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	env.client = pb.NewUserServiceClient(conn)
+	return env
+}
+
+// register 注册并登录，返回用户ID和携带 token 的 ctx
+func (e *testEnv) register(t *testing.T, username, like string) (string, context.Context) {
+	t.Helper()
+	ctx := context.Background()
+	resp, err := e.client.Register(ctx, &pb.RegisterRequest{Username: username, Password: "secret", Like: like})
+	if err != nil {
+		t.Fatalf("Register(%s) error = %v", username, err)
+	}
+	login, err := e.client.Login(ctx, &pb.LoginRequest{Username: username, Password: "secret"})
+	if err != nil {
+		t.Fatalf("Login(%s) error = %v", username, err)
+	}
+	return resp.UserId, metadata.AppendToOutgoingContext(ctx, "token", login.AccessToken)
+}
+
+// embed 模拟后台任务完成喜好向量的计算
+func (e *testEnv) embed(t *testing.T, userID, like string) {
+	t.Helper()
+	embedding, err := e.embedder.Embed(context.Background(), like)
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if err := e.users.UpdateLikeEmbedding(context.Background(), userID, pkg.NewVector(embedding)); err != nil {
+		t.Fatalf("UpdateLikeEmbedding() error = %v", err)
+	}
+}
+
+func assertCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("error code = %v (%v), want %v", got, err, want)
+	}
+}
+
+func TestUserService_Register(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	resp, err := env.client.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "secret", Like: "hiking"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := uuid.Parse(resp.UserId); err != nil {
+		t.Errorf("Register() user_id = %q, want a UUID", resp.UserId)
+	}
+	user, err := env.users.FindByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatalf("FindByUsername() error = %v", err)
+	}
+	if user.UserID != resp.UserId {
+		t.Errorf("Register() user_id = %s, stored %s", resp.UserId, user.UserID)
+	}
+	if user.EmbeddingStatus != model.EmbeddingStatusPending {
+		t.Errorf("EmbeddingStatus = %s, want pending", user.EmbeddingStatus)
+	}
+	if got := env.queue.enqueued(); len(got) != 1 || got[0] != resp.UserId {
+		t.Errorf("enqueued = %v, want [%s]", got, resp.UserId)
+	}
+	if env.redis.Exists("lock:register:lock:alice") {
+		t.Errorf("register lock not released")
+	}
+
+	_, err = env.client.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "other"})
+	assertCode(t, err, codes.AlreadyExists)
+}
+
+func TestUserService_Login(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	userID, _ := env.register(t, "alice", "hiking")
+
+	resp, err := env.client.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	subject, err := pkg.ParseJWT(resp.AccessToken, *env.jwt)
+	if err != nil || subject != userID {
+		t.Errorf("token subject = %s, %v, want %s", subject, err, userID)
+	}
+
+	_, err = env.client.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "wrong"})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = env.client.Login(ctx, &pb.LoginRequest{Username: "bob", Password: "secret"})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestUserService_AuditEvents(t *testing.T) {
+	env := newTestEnv(t)
+	userID, _ := env.register(t, "alice", "hiking")
+	ctx := context.Background()
+	_, _ = env.client.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "wrong"})
+	_, _ = env.client.Login(ctx, &pb.LoginRequest{Username: "bob", Password: "secret"})
+	badToken := metadata.AppendToOutgoingContext(ctx, "token", "not-a-jwt")
+	_, _ = env.client.GetUserInfo(badToken, &emptypb.Empty{})
+
+	want := []struct {
+		actorID string
+		action  string
+		outcome string
+	}{
+		{userID, model.AuditActionRegister, model.AuditOutcomeSuccess},
+		{userID, model.AuditActionLogin, model.AuditOutcomeSuccess},
+		{userID, model.AuditActionLogin, model.AuditOutcomeFailure},
+		{"", model.AuditActionLogin, model.AuditOutcomeFailure},
+		{"", model.AuditActionTokenInvalid, model.AuditOutcomeFailure},
+	}
+	events := env.audits.events
+	if len(events) != len(want) {
+		t.Fatalf("recorded %d audit events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		event := events[i]
+		if event.ActorID != w.actorID || event.Action != w.action || event.Outcome != w.outcome {
+			t.Errorf("event[%d] = %s/%s/%s, want %s/%s/%s", i, event.ActorID, event.Action, event.Outcome, w.actorID, w.action, w.outcome)
+		}
+		if event.IP == "" {
+			t.Errorf("event[%d] has no client IP", i)
+		}
+	}
+}
+
+func TestUserService_DisabledUser(t *testing.T) {
+	env := newTestEnv(t)
+	userID, _ := env.register(t, "alice", "hiking")
+	if err := env.users.UpdateDisabled(context.Background(), userID, true); err != nil {
+		t.Fatalf("UpdateDisabled() error = %v", err)
+	}
+
+	_, err := env.client.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+	assertCode(t, err, codes.PermissionDenied)
+}
+
+func TestUserService_RevokedToken(t *testing.T) {
+	env := newTestEnv(t)
+	userID, ctx := env.register(t, "alice", "hiking")
+	time.Sleep(2 * time.Millisecond)
+	if err := env.tokens.RevokeUserTokens(context.Background(), userID); err != nil {
+		t.Fatalf("RevokeUserTokens() error = %v", err)
+	}
+
+	_, err := env.client.GetUserInfo(ctx, &emptypb.Empty{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// 吊销后立即重新登录（与吊销在同一秒内），新令牌有效
+	login, err := env.client.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "token", login.AccessToken)
+	if _, err := env.client.GetUserInfo(ctx, &emptypb.Empty{}); err != nil {
+		t.Errorf("GetUserInfo() with the new token error = %v", err)
+	}
+}
+
+func TestUserService_Sessions(t *testing.T) {
+	env := newTestEnv(t)
+	_, phone := env.register(t, "alice", "hiking")
+
+	login, err := env.client.Login(metadata.AppendToOutgoingContext(context.Background(), "device", "laptop"),
+		&pb.LoginRequest{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	laptop := metadata.AppendToOutgoingContext(context.Background(), "token", login.AccessToken)
+
+	resp, err := env.client.ListSessions(phone, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(resp.Sessions) != 2 {
+		t.Fatalf("ListSessions() returned %d sessions, want 2", len(resp.Sessions))
+	}
+	var laptopSession string
+	for _, session := range resp.Sessions {
+		if session.Device == "laptop" {
+			laptopSession = session.SessionId
+			if session.Current {
+				t.Errorf("laptop session marked as current")
+			}
+		} else if !session.Current {
+			t.Errorf("phone session not marked as current")
+		}
+		if session.UserAgent == "" || session.Ip == "" {
+			t.Errorf("session missing client info: %v", session)
+		}
+	}
+
+	// 吊销后该会话的令牌失效，其他会话不受影响
+	if _, err := env.client.RevokeSession(phone, &pb.RevokeSessionRequest{SessionId: laptopSession}); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	_, err = env.client.GetUserInfo(laptop, &emptypb.Empty{})
+	assertCode(t, err, codes.Unauthenticated)
+	if _, err := env.client.GetUserInfo(phone, &emptypb.Empty{}); err != nil {
+		t.Errorf("GetUserInfo() error = %v", err)
+	}
+
+	_, err = env.client.RevokeSession(phone, &pb.RevokeSessionRequest{SessionId: laptopSession})
+	assertCode(t, err, codes.NotFound)
+
+	// 不能吊销其他用户的会话
+	_, bob := env.register(t, "bob", "jazz")
+	bobSessions, err := env.client.ListSessions(bob, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	_, err = env.client.RevokeSession(phone, &pb.RevokeSessionRequest{SessionId: bobSessions.Sessions[0].SessionId})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestUserService_ContactVerification(t *testing.T) {
+	env := newTestEnv(t)
+	userID, ctx := env.register(t, "alice", "hiking")
+
+	_, err := env.client.UpdateContact(ctx, &pb.UpdateContactRequest{Email: "not-an-email"})
+	assertCode(t, err, codes.InvalidArgument)
+	_, err = env.client.RequestContactVerification(ctx, &pb.ContactVerificationRequest{Channel: pkg.ChannelEmail})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	if _, err := env.client.UpdateContact(ctx, &pb.UpdateContactRequest{Email: " Alice@Example.com ", Phone: "+86 138-0000-0000"}); err != nil {
+		t.Fatalf("UpdateContact() error = %v", err)
+	}
+	if _, err := env.client.RequestContactVerification(ctx, &pb.ContactVerificationRequest{Channel: pkg.ChannelEmail}); err != nil {
+		t.Fatalf("RequestContactVerification() error = %v", err)
+	}
+	code := env.sender.lastCode()
+
+	// 冷却期内不能重复发送
+	_, err = env.client.RequestContactVerification(ctx, &pb.ContactVerificationRequest{Channel: pkg.ChannelEmail})
+	assertCode(t, err, codes.ResourceExhausted)
+
+	_, err = env.client.ConfirmContactVerification(ctx, &pb.ConfirmContactVerificationRequest{Channel: pkg.ChannelEmail, Code: "000000x"})
+	assertCode(t, err, codes.InvalidArgument)
+	if _, err := env.client.ConfirmContactVerification(ctx, &pb.ConfirmContactVerificationRequest{Channel: pkg.ChannelEmail, Code: code}); err != nil {
+		t.Fatalf("ConfirmContactVerification() error = %v", err)
+	}
+	info, err := env.client.GetUserInfo(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("GetUserInfo() error = %v", err)
+	}
+	if info.Email != "alice@example.com" || info.Phone != "+8613800000000" || !info.EmailVerified || info.PhoneVerified {
+		t.Errorf("GetUserInfo() = %v, want verified normalized email", info)
+	}
+
+	// 验证码只能使用一次
+	_, err = env.client.ConfirmContactVerification(ctx, &pb.ConfirmContactVerificationRequest{Channel: pkg.ChannelEmail, Code: code})
+	assertCode(t, err, codes.InvalidArgument)
+
+	// 修改邮箱后需要重新验证
+	if _, err := env.client.UpdateContact(ctx, &pb.UpdateContactRequest{Email: "alice@example.org", Phone: "+8613800000000"}); err != nil {
+		t.Fatalf("UpdateContact() error = %v", err)
+	}
+	user, _ := env.users.FindByUserID(context.Background(), userID)
+	if user.EmailVerified {
+		t.Errorf("email still verified after change")
+	}
+}
+
+func TestUserService_PasswordReset(t *testing.T) {
+	env := newTestEnv(t)
+	_, ctx := env.register(t, "alice", "hiking")
+
+	// 没有已验证的联系方式时同样返回成功，但不发送验证码
+	if _, err := env.client.RequestPasswordReset(context.Background(), &pb.RequestPasswordResetRequest{Username: "alice"}); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	if _, err := env.client.RequestPasswordReset(context.Background(), &pb.RequestPasswordResetRequest{Username: "nobody"}); err != nil {
+		t.Fatalf("RequestPasswordReset(unknown) error = %v", err)
+	}
+	if len(env.sender.messages) != 0 {
+		t.Fatalf("sent %d messages, want 0", len(env.sender.messages))
+	}
+
+	if _, err := env.client.UpdateContact(ctx, &pb.UpdateContactRequest{Phone: "13800000000"}); err != nil {
+		t.Fatalf("UpdateContact() error = %v", err)
+	}
+	if _, err := env.client.RequestContactVerification(ctx, &pb.ContactVerificationRequest{Channel: pkg.ChannelPhone}); err != nil {
+		t.Fatalf("RequestContactVerification() error = %v", err)
+	}
+	if _, err := env.client.ConfirmContactVerification(ctx, &pb.ConfirmContactVerificationRequest{Channel: pkg.ChannelPhone, Code: env.sender.lastCode()}); err != nil {
+		t.Fatalf("ConfirmContactVerification() error = %v", err)
+	}
+
+	// 错误次数过多后验证码作废
+	if _, err := env.client.RequestPasswordReset(context.Background(), &pb.RequestPasswordResetRequest{Username: "alice"}); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	code := env.sender.lastCode()
+	wrong := &pb.ConfirmPasswordResetRequest{Username: "alice", Code: "wrong", NewPassword: "new-secret"}
+	for i := 0; i < 4; i++ {
+		_, err := env.client.ConfirmPasswordReset(context.Background(), wrong)
+		assertCode(t, err, codes.InvalidArgument)
+	}
+	_, err := env.client.ConfirmPasswordReset(context.Background(), wrong)
+	assertCode(t, err, codes.ResourceExhausted)
+	_, err = env.client.ConfirmPasswordReset(context.Background(), &pb.ConfirmPasswordResetRequest{Username: "alice", Code: code, NewPassword: "new-secret"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	env.redis.FastForward(time.Minute)
+	if _, err := env.client.RequestPasswordReset(context.Background(), &pb.RequestPasswordResetRequest{Username: "alice"}); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	if msg := env.sender.messages[len(env.sender.messages)-1]; msg.Channel != pkg.ChannelPhone || msg.To != "13800000000" {
+		t.Errorf("reset code sent to %s %s, want verified phone", msg.Channel, msg.To)
+	}
+	_, err = env.client.ConfirmPasswordReset(context.Background(), &pb.ConfirmPasswordResetRequest{Username: "alice", Code: env.sender.lastCode()})
+	assertCode(t, err, codes.InvalidArgument)
+	if _, err := env.client.ConfirmPasswordReset(context.Background(), &pb.ConfirmPasswordResetRequest{Username: "alice", Code: env.sender.lastCode(), NewPassword: "new-secret"}); err != nil {
+		t.Fatalf("ConfirmPasswordReset() error = %v", err)
+	}
+
+	// 重置后旧令牌失效，只能使用新密码登录
+	_, err = env.client.GetUserInfo(ctx, &emptypb.Empty{})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = env.client.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+	assertCode(t, err, codes.Unauthenticated)
+	if _, err := env.client.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "new-secret"}); err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+}
+
+func TestUserService_TOTP(t *testing.T) {
+	env := newTestEnv(t)
+	_, ctx := env.register(t, "alice", "hiking")
+
+	enroll, err := env.client.EnrollTOTP(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("EnrollTOTP() error = %v", err)
+	}
+	if !strings.HasPrefix(enroll.OtpauthUri, "otpauth://totp/tx-demo:alice?") {
+		t.Errorf("OtpauthUri = %s", enroll.OtpauthUri)
+	}
+	user, _ := env.users.FindByUsername(context.Background(), "alice")
+	if user.TOTPSecret == "" || strings.Contains(user.TOTPSecret, enroll.Secret) {
+		t.Errorf("secret not stored encrypted: %q", user.TOTPSecret)
+	}
+	code := func(offset int64) string {
+		code, err := pkg.TOTPCode(enroll.Secret, pkg.TOTPStep(time.Now())+offset)
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		return code
+	}
+
+	// 确认前登录不需要动态口令
+	_, err = env.client.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{Code: "000000x"})
+	assertCode(t, err, codes.Unauthenticated)
+	login, err := env.client.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+	if err != nil || login.TwoFactorRequired {
+		t.Fatalf("Login() = %v, %v before confirm", login, err)
+	}
+
+	confirm, err := env.client.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{Code: code(0)})
+	if err != nil {
+		t.Fatalf("ConfirmTOTP() error = %v", err)
+	}
+	if len(confirm.RecoveryCodes) != 10 {
+		t.Fatalf("ConfirmTOTP() returned %d recovery codes, want 10", len(confirm.RecoveryCodes))
+	}
+	_, err = env.client.EnrollTOTP(ctx, &emptypb.Empty{})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	challenge := func() string {
+		login, err := env.client.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		if !login.TwoFactorRequired || login.AccessToken != "" || login.ChallengeToken == "" {
+			t.Fatalf("Login() = %v, want challenge", login)
+		}
+		return login.ChallengeToken
+	}
+
+	// 已使用过的口令不能重放
+	token := challenge()
+	_, err = env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: token, Code: code(0)})
+	assertCode(t, err, codes.Unauthenticated)
+	verified, err := env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: token, Code: code(1)})
+	if err != nil {
+		t.Fatalf("VerifyTOTP() error = %v", err)
+	}
+	authed := metadata.AppendToOutgoingContext(context.Background(), "token", verified.AccessToken)
+	if _, err := env.client.GetUserInfo(authed, &emptypb.Empty{}); err != nil {
+		t.Errorf("GetUserInfo() error = %v", err)
+	}
+
+	// 挑战只能使用一次
+	_, err = env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: token, RecoveryCode: confirm.RecoveryCodes[0]})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// 恢复码只能使用一次，忽略大小写
+	if _, err := env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: challenge(), RecoveryCode: strings.ToUpper(confirm.RecoveryCodes[0])}); err != nil {
+		t.Fatalf("VerifyTOTP(recovery code) error = %v", err)
+	}
+	_, err = env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: challenge(), RecoveryCode: confirm.RecoveryCodes[0]})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// 超过尝试次数后挑战作废
+	token = challenge()
+	for i := 0; i < 5; i++ {
+		_, err := env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: token, Code: "000000"})
+		assertCode(t, err, codes.Unauthenticated)
+	}
+	_, err = env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: token, RecoveryCode: confirm.RecoveryCodes[1]})
+	if status.Convert(err).Message() != pkg.ErrChallengeExpired {
+		t.Errorf("VerifyTOTP() after too many attempts error = %v", err)
+	}
+}
+
+func TestUserService_TOTPLockout(t *testing.T) {
+	env := newTestEnv(t)
+	_, ctx := env.register(t, "alice", "hiking")
+	enroll, err := env.client.EnrollTOTP(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("EnrollTOTP() error = %v", err)
+	}
+	code, _ := pkg.TOTPCode(enroll.Secret, pkg.TOTPStep(time.Now()))
+	if _, err := env.client.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{Code: code}); err != nil {
+		t.Fatalf("ConfirmTOTP() error = %v", err)
+	}
+	challenge := func() (string, error) {
+		login, err := env.client.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+		if err != nil {
+			return "", err
+		}
+		return login.ChallengeToken, nil
+	}
+
+	// 每个挑战最多尝试 5 次，但失败次数按用户跨挑战累计，重新登录换取新挑战不能继续穷举
+	pending, _ := challenge()
+	for i := 0; i < 2; i++ {
+		token, err := challenge()
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		for j := 0; j < 5; j++ {
+			_, err := env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: token, Code: "000000"})
+			assertCode(t, err, codes.Unauthenticated)
+		}
+	}
+	_, err = challenge()
+	assertCode(t, err, codes.ResourceExhausted)
+
+	// 锁定前取得的挑战同样被拒绝，即使口令正确
+	next, _ := pkg.TOTPCode(enroll.Secret, pkg.TOTPStep(time.Now())+1)
+	_, err = env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: pending, Code: next})
+	assertCode(t, err, codes.ResourceExhausted)
+
+	// 锁定到期后恢复
+	env.redis.FastForward(15 * time.Minute)
+	token, err := challenge()
+	if err != nil {
+		t.Fatalf("Login() after lockout error = %v", err)
+	}
+	if _, err := env.client.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{ChallengeToken: token, Code: next}); err != nil {
+		t.Fatalf("VerifyTOTP() after lockout error = %v", err)
+	}
+}
+
+func TestUserService_GetUserInfo(t *testing.T) {
+	env := newTestEnv(t)
+	userID, ctx := env.register(t, "alice", "hiking")
+
+	resp, err := env.client.GetUserInfo(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("GetUserInfo() error = %v", err)
+	}
+	if resp.UserId != userID || resp.Username != "alice" || resp.Like != "hiking" {
+		t.Errorf("GetUserInfo() = %v", resp)
+	}
+	if resp.EmbeddingStatus != model.EmbeddingStatusPending {
+		t.Errorf("EmbeddingStatus = %s, want pending", resp.EmbeddingStatus)
+	}
+
+	_, err = env.client.GetUserInfo(context.Background(), &emptypb.Empty{})
+	assertCode(t, err, codes.Unauthenticated)
+}
+
+func TestUserService_Interests(t *testing.T) {
+	env := newTestEnv(t)
+	_, ctx := env.register(t, "alice", "hiking")
+
+	resp, err := env.client.AddInterest(ctx, &pb.AddInterestRequest{Tag: "  photography "})
+	if err != nil {
+		t.Fatalf("AddInterest() error = %v", err)
+	}
+	resp, err = env.client.AddInterest(ctx, &pb.AddInterestRequest{Tag: "jazz"})
+	if err != nil {
+		t.Fatalf("AddInterest() error = %v", err)
+	}
+	if len(resp.Tags) != 2 || resp.Tags[0] != "photography" || resp.Tags[1] != "jazz" {
+		t.Errorf("AddInterest() tags = %v", resp.Tags)
+	}
+
+	_, err = env.client.AddInterest(ctx, &pb.AddInterestRequest{Tag: "jazz"})
+	assertCode(t, err, codes.AlreadyExists)
+	_, err = env.client.AddInterest(ctx, &pb.AddInterestRequest{Tag: " "})
+	assertCode(t, err, codes.InvalidArgument)
+
+	resp, err = env.client.RemoveInterest(ctx, &pb.RemoveInterestRequest{Tag: "photography"})
+	if err != nil {
+		t.Fatalf("RemoveInterest() error = %v", err)
+	}
+	if len(resp.Tags) != 1 || resp.Tags[0] != "jazz" {
+		t.Errorf("RemoveInterest() tags = %v", resp.Tags)
+	}
+	_, err = env.client.RemoveInterest(ctx, &pb.RemoveInterestRequest{Tag: "photography"})
+	assertCode(t, err, codes.NotFound)
+
+	list, err := env.client.ListInterests(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("ListInterests() error = %v", err)
+	}
+	if len(list.Tags) != 1 || list.Tags[0] != "jazz" {
+		t.Errorf("ListInterests() tags = %v", list.Tags)
+	}
+}
+
+func TestUserService_AddInterestConcurrent(t *testing.T) {
+	env := newTestEnv(t)
+	userID, ctx := env.register(t, "alice", "hiking")
+
+	// 并发添加时数量上限和重复检查仍然生效
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		got = make(map[codes.Code]int)
+	)
+	for i := 0; i < maxInterestsPerUser+5; i++ {
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func(tag string) {
+				defer wg.Done()
+				_, err := env.client.AddInterest(ctx, &pb.AddInterestRequest{Tag: tag})
+				mu.Lock()
+				got[status.Code(err)]++
+				mu.Unlock()
+			}(fmt.Sprintf("tag-%d", i))
+		}
+	}
+	wg.Wait()
+
+	interests, _ := env.interests.ListByUserID(context.Background(), userID)
+	if len(interests) != maxInterestsPerUser {
+		t.Errorf("stored interests = %d, want %d", len(interests), maxInterestsPerUser)
+	}
+	if got[codes.OK] != maxInterestsPerUser {
+		t.Errorf("successful AddInterest = %d, want %d (codes %v)", got[codes.OK], maxInterestsPerUser, got)
+	}
+	for code := range got {
+		if code != codes.OK && code != codes.AlreadyExists && code != codes.FailedPrecondition {
+			t.Errorf("unexpected code %v", code)
+		}
+	}
+}
+
+func TestUserService_RecommendUsers(t *testing.T) {
+	env := newTestEnv(t)
+	aliceID, ctx := env.register(t, "alice", "hiking in the mountains")
+
+	// 喜好向量尚未计算且没有兴趣标签
+	stream, err := env.client.RecommendUsers(ctx, &pb.RecommendUsersRequest{})
+	if err != nil {
+		t.Fatalf("RecommendUsers() error = %v", err)
+	}
+	_, err = stream.Recv()
+	assertCode(t, err, codes.FailedPrecondition)
+
+	env.embed(t, aliceID, "hiking in the mountains")
+	likes := map[string]string{
+		"bob":   "hiking in the mountains",
+		"carol": "baking bread",
+		"dave":  "mountain hiking trips",
+	}
+	for username, like := range likes {
+		userID, _ := env.register(t, username, like)
+		env.embed(t, userID, like)
+	}
+
+	var got []*pb.RecommendedUser
+	pageToken := ""
+	for {
+		stream, err := env.client.RecommendUsers(ctx, &pb.RecommendUsersRequest{PageSize: 2, PageToken: pageToken})
+		if err != nil {
+			t.Fatalf("RecommendUsers() error = %v", err)
+		}
+		pageToken = ""
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Recv() error = %v", err)
+			}
+			got = append(got, resp)
+			pageToken = resp.NextPageToken
+		}
+		if pageToken == "" {
+			break
+		}
+	}
+
+	if len(got) != len(likes) {
+		t.Fatalf("RecommendUsers() returned %d users, want %d", len(got), len(likes))
+	}
+	if got[0].Username != "bob" {
+		t.Errorf("first recommendation = %s, want bob", got[0].Username)
+	}
+	for i, user := range got {
+		if user.UserId == aliceID {
+			t.Errorf("RecommendUsers() returned the current user")
+		}
+		if int(user.Rank) != i {
+			t.Errorf("rank = %d, want %d", user.Rank, i)
+		}
+	}
+
+	stream, err = env.client.RecommendUsers(ctx, &pb.RecommendUsersRequest{PageToken: "bad"})
+	if err != nil {
+		t.Fatalf("RecommendUsers() error = %v", err)
+	}
+	_, err = stream.Recv()
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestUserService_DiversityVectors(t *testing.T) {
+	interests := &fakeInterestRepository{}
+	_ = interests.CreateInterest(context.Background(), &model.UserInterest{UserID: "b", Tag: "x", Embedding: pkg.Vector{1, 0}}, maxInterestsPerUser)
+	_ = interests.CreateInterest(context.Background(), &model.UserInterest{UserID: "b", Tag: "y", Embedding: pkg.Vector{0, 1}}, maxInterestsPerUser)
+	s := UserServiceServer{interestRepo: interests}
+
+	candidates := []*repository.SimilarUser{
+		{User: model.User{UserID: "a", LikeEmbedding: pkg.Vector{1, 1}, EmbeddingStatus: model.EmbeddingStatusReady}},
+		// 喜好已修改、向量等待重新计算，使用兴趣向量
+		{User: model.User{UserID: "b", LikeEmbedding: pkg.Vector{9, 9}, EmbeddingStatus: model.EmbeddingStatusPending}},
+		{User: model.User{UserID: "c", EmbeddingStatus: model.EmbeddingStatusPending}},
+	}
+	vectors, err := s.diversityVectors(context.Background(), candidates)
+	if err != nil {
+		t.Fatalf("diversityVectors() error = %v", err)
+	}
+	if v := vectors["a"]; len(v) != 2 || v[0] != 1 || v[1] != 1 {
+		t.Errorf("vectors[a] = %v, want like embedding", v)
+	}
+	if v := vectors["b"]; len(v) != 2 || v[0] != 0.5 || v[1] != 0.5 {
+		t.Errorf("vectors[b] = %v, want mean of interests", v)
+	}
+	if v, ok := vectors["c"]; ok {
+		t.Errorf("vectors[c] = %v, want none", v)
+	}
+}
+
+func TestUserService_RegisterLockMetrics(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	// 用户名中的 ':' 不会让锁指标按用户名增长
+	for _, username := range []string{"bob:smith", "carol:x:y"} {
+		if _, err := env.client.Register(ctx, &pb.RegisterRequest{Username: username, Password: "secret"}); err != nil {
+			t.Fatalf("Register(%q) error = %v", username, err)
+		}
+	}
+	metrics, ok := expvar.Get("distributed_lock").(*expvar.Map)
+	if !ok {
+		t.Fatal("distributed_lock metrics not published")
+	}
+	if metrics.Get("register.outcome.acquired") == nil {
+		t.Errorf("register.outcome.acquired not recorded")
+	}
+	metrics.Do(func(kv expvar.KeyValue) {
+		if strings.Contains(kv.Key, "bob") || strings.Contains(kv.Key, "carol") || strings.HasPrefix(kv.Key, "register:") {
+			t.Errorf("lock metric %q derived from the username", kv.Key)
+		}
+	})
+}
+
+func TestUserService_RegisterFencingCleanup(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	if _, err := env.client.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "secret"}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	_, err := env.client.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "other"})
+	assertCode(t, err, codes.AlreadyExists)
+
+	// 用户创建后删除令牌记录，重复注册也不会留下记录
+	if n := env.fencing.size(); n != 0 {
+		t.Errorf("fencing records = %d, want 0", n)
+	}
+	// 栅栏令牌计数器按用户名加锁，但会过期
+	if ttl := env.redis.TTL("lock:register:lock:alice:fence"); ttl <= 0 || ttl > lockFenceTTL {
+		t.Errorf("fence counter ttl = %v, want (0, %v]", ttl, lockFenceTTL)
+	}
+}
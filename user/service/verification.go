@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+	pb "tx-demo/user/proto"
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{6,20}$`)
+
+// verificationConfig 验证码参数
+type verificationConfig struct {
+	codeLength     int
+	codeTTL        time.Duration
+	maxAttempts    int
+	resendInterval time.Duration
+}
+
+func newVerificationConfig(conf *viper.Viper) verificationConfig {
+	config := verificationConfig{
+		codeLength:     6,
+		codeTTL:        10 * time.Minute,
+		maxAttempts:    5,
+		resendInterval: time.Minute,
+	}
+	if conf.IsSet("verification.code_length") {
+		config.codeLength = conf.GetInt("verification.code_length")
+	}
+	if conf.IsSet("verification.code_ttl") {
+		config.codeTTL = conf.GetDuration("verification.code_ttl")
+	}
+	if conf.IsSet("verification.max_attempts") {
+		config.maxAttempts = conf.GetInt("verification.max_attempts")
+	}
+	if conf.IsSet("verification.resend_interval") {
+		config.resendInterval = conf.GetDuration("verification.resend_interval")
+	}
+	return config
+}
+
+// UpdateContact 修改邮箱和手机号，发生变化的联系方式需要重新验证
+func (s UserServiceServer) UpdateContact(ctx context.Context, req *pb.UpdateContactRequest) (*emptypb.Empty, error) {
+	s.logger.Info("UpdateContact called")
+
+	// 1.校验登录状态和联系方式格式
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	email, phone, err := normalizeContact(req.Email, req.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.UpdateContact")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.更新联系方式
+	if err := s.userRepo.UpdateContact(ctx, userId, email, phone); err != nil {
+		s.logger.Error("Failed to update contact", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	s.audit(ctx, userId, model.AuditActionUpdateContact, userId, model.AuditOutcomeSuccess, "")
+	return &emptypb.Empty{}, nil
+}
+
+// RequestContactVerification 向邮箱或手机号发送验证码
+func (s UserServiceServer) RequestContactVerification(ctx context.Context, req *pb.ContactVerificationRequest) (*emptypb.Empty, error) {
+	s.logger.Info("RequestContactVerification called", zap.String("channel", req.Channel))
+
+	// 1.校验登录状态
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.RequestContactVerification")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.生成验证码并发送，验证码哈希与联系方式绑定，修改联系方式后旧验证码失效
+	contact, err := s.contactOf(ctx, userId, req.Channel)
+	if err != nil {
+		return nil, err
+	}
+	code, err := s.sendCode(ctx, repository.VerificationPurposeContact, userId+":"+req.Channel, req.Channel+":"+contact)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.notify(ctx, req.Channel, contact, "联系方式验证", "您的验证码为 "+code); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// ConfirmContactVerification 校验验证码，通过后将联系方式标记为已验证
+func (s UserServiceServer) ConfirmContactVerification(ctx context.Context, req *pb.ConfirmContactVerificationRequest) (*emptypb.Empty, error) {
+	s.logger.Info("ConfirmContactVerification called", zap.String("channel", req.Channel))
+
+	// 1.校验登录状态
+	userId, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.使用jeager实现链路追踪
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.ConfirmContactVerification")
+	span.SetTag("userId", userId)
+	defer span.Finish()
+
+	// 3.校验验证码
+	contact, err := s.contactOf(ctx, userId, req.Channel)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifyCode(ctx, repository.VerificationPurposeContact, userId+":"+req.Channel, req.Channel+":"+contact, req.Code); err != nil {
+		s.audit(ctx, userId, model.AuditActionVerifyContact, userId, model.AuditOutcomeFailure, req.Channel)
+		return nil, err
+	}
+	verified, err := s.userRepo.MarkContactVerified(ctx, userId, req.Channel, contact)
+	if err != nil {
+		s.logger.Error("Failed to mark contact verified", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if !verified {
+		// 校验期间联系方式被修改
+		return nil, status.Errorf(codes.InvalidArgument, pkg.ErrInvalidCode)
+	}
+
+	s.audit(ctx, userId, model.AuditActionVerifyContact, userId, model.AuditOutcomeSuccess, req.Channel)
+	return &emptypb.Empty{}, nil
+}
+
+// RequestPasswordReset 向已验证的联系方式发送重置密码验证码
+// 用户不存在或没有已验证的联系方式时同样返回成功，避免泄露账号是否存在
+func (s UserServiceServer) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	s.logger.Info("RequestPasswordReset called", zap.String("username", req.Username))
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.RequestPasswordReset")
+	defer span.Finish()
+
+	user, err := s.userRepo.FindByUsername(ctx, req.Username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.audit(ctx, "", model.AuditActionResetRequest, "", model.AuditOutcomeFailure, "unknown username: "+req.Username)
+			return &emptypb.Empty{}, nil
+		}
+		s.logger.Error("Failed to query user", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	span.SetTag("userId", user.UserID)
+
+	// 优先使用邮箱
+	channel, contact := "", ""
+	switch {
+	case user.EmailVerified && user.Email != "":
+		channel, contact = pkg.ChannelEmail, user.Email
+	case user.PhoneVerified && user.Phone != "":
+		channel, contact = pkg.ChannelPhone, user.Phone
+	}
+	if channel == "" || user.Disabled {
+		s.audit(ctx, user.UserID, model.AuditActionResetRequest, user.UserID, model.AuditOutcomeDenied, "no verified contact or user disabled")
+		return &emptypb.Empty{}, nil
+	}
+
+	code, err := s.sendCode(ctx, repository.VerificationPurposePasswordReset, user.UserID, user.UserID)
+	if err != nil {
+		if status.Code(err) == codes.ResourceExhausted {
+			// 冷却期内不重复发送，也不暴露账号状态
+			return &emptypb.Empty{}, nil
+		}
+		return nil, err
+	}
+	if err := s.notify(ctx, channel, contact, "重置密码", "您正在重置密码，验证码为 "+code+"，如非本人操作请忽略"); err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, user.UserID, model.AuditActionResetRequest, user.UserID, model.AuditOutcomeSuccess, channel)
+	return &emptypb.Empty{}, nil
+}
+
+// ConfirmPasswordReset 校验验证码并重置密码，成功后吊销该用户已签发的令牌
+func (s UserServiceServer) ConfirmPasswordReset(ctx context.Context, req *pb.ConfirmPasswordResetRequest) (*emptypb.Empty, error) {
+	s.logger.Info("ConfirmPasswordReset called", zap.String("username", req.Username))
+
+	if req.NewPassword == "" {
+		return nil, status.Errorf(codes.InvalidArgument, pkg.ErrInvalidPassword)
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UserService.ConfirmPasswordReset")
+	defer span.Finish()
+
+	user, err := s.userRepo.FindByUsername(ctx, req.Username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Errorf(codes.InvalidArgument, pkg.ErrInvalidCode)
+		}
+		s.logger.Error("Failed to query user", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	span.SetTag("userId", user.UserID)
+
+	if err := s.verifyCode(ctx, repository.VerificationPurposePasswordReset, user.UserID, user.UserID, req.Code); err != nil {
+		s.audit(ctx, user.UserID, model.AuditActionResetPassword, user.UserID, model.AuditOutcomeFailure, "invalid code")
+		return nil, err
+	}
+	if err := s.userRepo.UpdatePassword(ctx, user.UserID, pkg.HashPassword(req.NewPassword)); err != nil {
+		s.logger.Error("Failed to update password", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if err := s.tokenRepo.RevokeUserTokens(ctx, user.UserID); err != nil {
+		s.logger.Error("Failed to revoke tokens", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+
+	s.audit(ctx, user.UserID, model.AuditActionResetPassword, user.UserID, model.AuditOutcomeSuccess, "")
+	s.logger.Info("Password reset successfully", zap.String("user_id", user.UserID))
+	return &emptypb.Empty{}, nil
+}
+
+// contactOf 返回用户在该渠道的联系方式
+func (s UserServiceServer) contactOf(ctx context.Context, userId string, channel string) (string, error) {
+	if channel != pkg.ChannelEmail && channel != pkg.ChannelPhone {
+		return "", status.Errorf(codes.InvalidArgument, pkg.ErrInvalidChannel)
+	}
+	user, err := s.userRepo.FindByUserID(ctx, userId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", status.Errorf(codes.NotFound, pkg.ErrUserNotFound)
+		}
+		s.logger.Error("Failed to query user", zap.Error(err))
+		return "", status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	contact := user.Email
+	if channel == pkg.ChannelPhone {
+		contact = user.Phone
+	}
+	if contact == "" {
+		return "", status.Errorf(codes.FailedPrecondition, pkg.ErrContactNotSet)
+	}
+	return contact, nil
+}
+
+// sendCode 生成验证码并保存其哈希，binding 参与哈希计算，校验时必须一致
+func (s UserServiceServer) sendCode(ctx context.Context, purpose, subject, binding string) (string, error) {
+	config := newVerificationConfig(s.conf)
+	code, err := pkg.GenerateCode(config.codeLength)
+	if err != nil {
+		s.logger.Error("Failed to generate code", zap.Error(err))
+		return "", status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	saved, err := s.verifyRepo.SaveCode(ctx, purpose, subject, pkg.HashCode(binding+":"+code, s.jwt.JwtKey), config.codeTTL, config.resendInterval)
+	if err != nil {
+		s.logger.Error("Failed to save code", zap.Error(err))
+		return "", status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	if !saved {
+		return "", status.Errorf(codes.ResourceExhausted, pkg.ErrCodeTooFrequent)
+	}
+	return code, nil
+}
+
+// verifyCode 校验验证码，通过后验证码作废
+func (s UserServiceServer) verifyCode(ctx context.Context, purpose, subject, binding, code string) error {
+	config := newVerificationConfig(s.conf)
+	result, err := s.verifyRepo.VerifyCode(ctx, purpose, subject, pkg.HashCode(binding+":"+code, s.jwt.JwtKey), config.maxAttempts)
+	if err != nil {
+		s.logger.Error("Failed to verify code", zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	switch result {
+	case repository.VerifyOK:
+		return nil
+	case repository.VerifyTooManyAttempts:
+		return status.Errorf(codes.ResourceExhausted, pkg.ErrTooManyAttempts)
+	default:
+		return status.Errorf(codes.InvalidArgument, pkg.ErrInvalidCode)
+	}
+}
+
+// notify 发送通知
+func (s UserServiceServer) notify(ctx context.Context, channel, to, subject, body string) error {
+	err := s.sender.Send(ctx, pkg.Message{Channel: channel, To: to, Subject: subject, Body: body})
+	if err != nil {
+		s.logger.Error("Failed to send notification", zap.String("channel", channel), zap.Error(err))
+		return status.Errorf(codes.Internal, pkg.ErrInternalServerError)
+	}
+	return nil
+}
+
+// normalizeContact 校验并规范化邮箱和手机号，为空表示不设置
+func normalizeContact(email, phone string) (string, string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Address != email || len(email) > 255 {
+			return "", "", status.Errorf(codes.InvalidArgument, pkg.ErrInvalidEmail)
+		}
+	}
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(phone)
+	if phone != "" && !phonePattern.MatchString(phone) {
+		return "", "", status.Errorf(codes.InvalidArgument, pkg.ErrInvalidPhone)
+	}
+	return email, phone, nil
+}
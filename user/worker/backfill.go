@@ -0,0 +1,207 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+)
+
+// backfillCheckpointKeyPrefix 断点 key 前缀，后接模型和维度，切换模型后自动从头开始
+const backfillCheckpointKeyPrefix = "user:embedding:backfill:checkpoint:"
+
+// BackfillConfig 回填任务配置
+type BackfillConfig struct {
+	// 每批处理的用户数
+	BatchSize int
+	// 每秒最多嵌入的文本条数，0 表示不限速
+	Rate float64
+	// 只处理这些嵌入状态的用户，为空表示全部
+	Statuses []string
+	// 忽略已有断点，从头开始
+	Reset bool
+}
+
+// Backfill 分页重新计算用户喜好和兴趣标签的嵌入向量，支持断点续跑
+type Backfill struct {
+	userRepo     repository.UserRepository
+	interestRepo repository.InterestRepository
+	tx           repository.Transaction
+	embedder     pkg.Embedder
+	rdb          *redis.Client
+	logger       *zap.Logger
+	config       BackfillConfig
+}
+
+func NewBackfill(userRepo repository.UserRepository, interestRepo repository.InterestRepository, tx repository.Transaction, embedder pkg.Embedder, rdb *redis.Client, logger *zap.Logger, config BackfillConfig) *Backfill {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 50
+	}
+	return &Backfill{
+		userRepo:     userRepo,
+		interestRepo: interestRepo,
+		tx:           tx,
+		embedder:     embedder,
+		rdb:          rdb,
+		logger:       logger,
+		config:       config,
+	}
+}
+
+// Run 执行回填，每批写入成功后保存断点，全部完成后删除断点
+func (b *Backfill) Run(ctx context.Context) error {
+	checkpointKey := b.checkpointKey()
+	if b.config.Reset {
+		if err := b.rdb.Del(ctx, checkpointKey).Err(); err != nil {
+			return fmt.Errorf("failed to reset checkpoint: %w", err)
+		}
+	}
+
+	// 1.读取断点
+	var lastID int64
+	checkpoint, err := b.rdb.Get(ctx, checkpointKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	if checkpoint != "" {
+		if lastID, err = strconv.ParseInt(checkpoint, 10, 64); err != nil {
+			return fmt.Errorf("invalid checkpoint %q: %w", checkpoint, err)
+		}
+	}
+
+	total, err := b.userRepo.CountUsersAfterID(ctx, lastID, b.config.Statuses)
+	if err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	b.logger.Info("backfill started",
+		zap.String("model", b.embedder.Model()),
+		zap.Int("dimension", b.embedder.Dimension()),
+		zap.Int64("resume_after_id", lastID),
+		zap.Int64("total", total))
+
+	var processed, skipped int64
+	startTime := time.Now()
+	for {
+		users, err := b.userRepo.ListUsersAfterID(ctx, lastID, b.config.BatchSize, b.config.Statuses)
+		if err != nil {
+			return fmt.Errorf("failed to list users: %w", err)
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		// 2.收集喜好和兴趣标签，喜好为空的用户只处理兴趣
+		userIDs := make([]string, 0, len(users))
+		for _, user := range users {
+			userIDs = append(userIDs, user.UserID)
+		}
+		interests, err := b.interestRepo.ListByUserIDs(ctx, userIDs)
+		if err != nil {
+			return fmt.Errorf("failed to list interests after id %d: %w", lastID, err)
+		}
+		var (
+			texts   []string
+			targets []*model.User
+		)
+		for _, user := range users {
+			if user.Like == "" {
+				skipped++
+				continue
+			}
+			texts = append(texts, user.Like)
+			targets = append(targets, user)
+		}
+		for _, interest := range interests {
+			texts = append(texts, interest.Tag)
+		}
+
+		if len(texts) > 0 {
+			// 3.每批请求前按文本条数限速，重启后的第一批同样等待
+			if err := b.wait(ctx, len(texts)); err != nil {
+				return err
+			}
+			embeddings, err := b.embedder.EmbedBatch(ctx, texts)
+			if err != nil {
+				return fmt.Errorf("failed to embed batch after id %d: %w", lastID, err)
+			}
+
+			// 4.整批在一个事务中写入
+			err = b.tx.Transaction(ctx, func(ctx context.Context) error {
+				for i, user := range targets {
+					if err := b.userRepo.UpdateLikeEmbedding(ctx, user.UserID, pkg.NewVector(embeddings[i])); err != nil {
+						return err
+					}
+				}
+				for i, interest := range interests {
+					if err := b.interestRepo.UpdateEmbedding(ctx, interest, pkg.NewVector(embeddings[len(targets)+i])); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to save batch after id %d: %w", lastID, err)
+			}
+		}
+
+		// 5.保存断点并汇报进度
+		lastID = users[len(users)-1].ID
+		if err := b.rdb.Set(ctx, checkpointKey, lastID, 0).Err(); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		processed += int64(len(users))
+
+		elapsed := time.Since(startTime)
+		fields := []zap.Field{
+			zap.Int64("processed", processed),
+			zap.Int64("skipped", skipped),
+			zap.Int64("total", total),
+			zap.Int64("last_id", lastID),
+			zap.Duration("elapsed", elapsed.Round(time.Second)),
+		}
+		if total > 0 {
+			fields = append(fields, zap.String("progress", fmt.Sprintf("%.1f%%", float64(processed)*100/float64(total))))
+			if remaining := total - processed; remaining > 0 {
+				eta := time.Duration(float64(elapsed) / float64(processed) * float64(remaining))
+				fields = append(fields, zap.Duration("eta", eta.Round(time.Second)))
+			}
+		}
+		b.logger.Info("backfill progress", fields...)
+	}
+
+	if err := b.rdb.Del(ctx, checkpointKey).Err(); err != nil {
+		b.logger.Warn("Failed to delete backfill checkpoint", zap.Error(err))
+	}
+	b.logger.Info("backfill finished",
+		zap.Int64("processed", processed),
+		zap.Int64("skipped", skipped),
+		zap.Duration("elapsed", time.Since(startTime).Round(time.Second)))
+	return nil
+}
+
+// checkpointKey 断点 key，包含模型和维度
+func (b *Backfill) checkpointKey() string {
+	return fmt.Sprintf("%s%s:%d", backfillCheckpointKeyPrefix, b.embedder.Model(), b.embedder.Dimension())
+}
+
+// wait 按配置的速率等待 n 条文本的配额，Rate 为 0 时不等待
+func (b *Backfill) wait(ctx context.Context, n int) error {
+	if b.config.Rate <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(float64(n) / b.config.Rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
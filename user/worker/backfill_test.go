@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"tx-demo/model"
+)
+
+func newTestBackfill(t *testing.T, config BackfillConfig) (*Backfill, *fakeUserRepository, *fakeInterestRepository, *fakeTransaction, *fakeEmbedder) {
+	t.Helper()
+	_, rdb := newTestRedis(t)
+	var users []*model.User
+	for i := 1; i <= 4; i++ {
+		users = append(users, &model.User{
+			ID:              int64(i),
+			UserID:          fmt.Sprintf("u%d", i),
+			Like:            fmt.Sprintf("like %d", i),
+			EmbeddingStatus: model.EmbeddingStatusPending,
+		})
+	}
+	userRepo := newFakeUserRepository(users...)
+	interestRepo := &fakeInterestRepository{interests: []*model.UserInterest{
+		{ID: 1, UserID: "u1", Tag: "music"},
+		{ID: 2, UserID: "u3", Tag: "football"},
+	}}
+	tx := &fakeTransaction{users: userRepo, interests: interestRepo}
+	embedder := newFakeEmbedder(0)
+	return NewBackfill(userRepo, interestRepo, tx, embedder, rdb, zap.NewNop(), config), userRepo, interestRepo, tx, embedder
+}
+
+func TestBackfill_EmbedsLikesAndInterests(t *testing.T) {
+	b, users, interests, tx, _ := newTestBackfill(t, BackfillConfig{BatchSize: 2})
+	if err := b.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for i := 1; i <= 4; i++ {
+		if user := users.user(fmt.Sprintf("u%d", i)); user.EmbeddingStatus != model.EmbeddingStatusReady {
+			t.Errorf("u%d status = %s, want ready", i, user.EmbeddingStatus)
+		}
+	}
+	for _, interest := range interests.interests {
+		if len(interest.Embedding.Float64s()) != 8 {
+			t.Errorf("interest %s was not re-embedded", interest.Tag)
+		}
+	}
+	if tx.calls != 2 {
+		t.Errorf("transactions = %d, want one per batch", tx.calls)
+	}
+	if n, _ := b.rdb.Exists(context.Background(), b.checkpointKey()).Result(); n != 0 {
+		t.Errorf("checkpoint was not deleted after completion")
+	}
+}
+
+func TestBackfill_RollbackAndResume(t *testing.T) {
+	b, users, interests, _, embedder := newTestBackfill(t, BackfillConfig{BatchSize: 2})
+	ctx := context.Background()
+
+	// 第二批写入兴趣失败，整批回滚，断点停在第一批
+	interests.failUserID = "u3"
+	if err := b.Run(ctx); err == nil {
+		t.Fatal("Run() error = nil, want write failure")
+	}
+	if got := b.rdb.Get(ctx, b.checkpointKey()).Val(); got != "2" {
+		t.Fatalf("checkpoint = %q, want 2", got)
+	}
+	if user := users.user("u3"); user.EmbeddingStatus != model.EmbeddingStatusPending {
+		t.Errorf("u3 status = %s, want rolled back to pending", user.EmbeddingStatus)
+	}
+	if user := users.user("u1"); user.EmbeddingStatus != model.EmbeddingStatusReady {
+		t.Errorf("u1 status = %s, want ready", user.EmbeddingStatus)
+	}
+
+	// 再次运行从断点继续，不重复处理第一批
+	interests.failUserID = ""
+	embedder.texts = nil
+	if err := b.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	want := []string{"like 3", "like 4", "football"}
+	if fmt.Sprint(embedder.texts) != fmt.Sprint(want) {
+		t.Errorf("embedded texts = %v, want %v", embedder.texts, want)
+	}
+	if user := users.user("u3"); user.EmbeddingStatus != model.EmbeddingStatusReady {
+		t.Errorf("u3 status = %s, want ready", user.EmbeddingStatus)
+	}
+}
+
+func TestBackfill_RateLimitsFirstBatch(t *testing.T) {
+	// 一批 4 条喜好和 2 个兴趣，共 6 条文本，速率 100 条/秒时第一批之前也要等待 60ms
+	b, _, _, _, _ := newTestBackfill(t, BackfillConfig{BatchSize: 4, Rate: 100})
+	start := time.Now()
+	if err := b.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Run() elapsed = %v, want at least 60ms", elapsed)
+	}
+}
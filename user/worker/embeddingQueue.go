@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+)
+
+// 嵌入任务使用的 Redis Stream
+const (
+	// EmbeddingStream 待计算嵌入向量的任务
+	EmbeddingStream = "user:embedding:stream"
+	// EmbeddingDeadLetterStream 多次重试仍失败的任务
+	EmbeddingDeadLetterStream = "user:embedding:dead"
+	// embeddingGroup 消费者组
+	embeddingGroup = "embedding-workers"
+	// embeddingStreamMaxLen 任务流的近似最大长度
+	embeddingStreamMaxLen = 100000
+)
+
+// EmbeddingQueue 嵌入任务队列
+type EmbeddingQueue interface {
+	// Enqueue 投递一个计算用户喜好嵌入向量的任务
+	Enqueue(ctx context.Context, userID string) error
+}
+
+type embeddingQueue struct {
+	rdb *redis.Client
+}
+
+func NewEmbeddingQueue(rdb *redis.Client) EmbeddingQueue {
+	return &embeddingQueue{
+		rdb: rdb,
+	}
+}
+
+// Enqueue 投递一个计算用户喜好嵌入向量的任务
+func (q *embeddingQueue) Enqueue(ctx context.Context, userID string) error {
+	return q.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: EmbeddingStream,
+		MaxLen: embeddingStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"user_id": userID},
+	}).Err()
+}
@@ -0,0 +1,349 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+)
+
+// EmbeddingWorkerConfig 嵌入任务消费者配置
+type EmbeddingWorkerConfig struct {
+	// 消费者名称，同一消费者组内需唯一
+	Consumer string
+	// 每次读取的任务数，同时也是一次批量嵌入的条数
+	BatchSize int64
+	// 没有新任务时阻塞等待的时间
+	Block time.Duration
+	// 超过该投递次数后转入死信流
+	MaxRetries int64
+	// 未确认的任务空闲多久后重新投递
+	RetryIdle time.Duration
+}
+
+// DefaultEmbeddingWorkerConfig 默认配置
+var DefaultEmbeddingWorkerConfig = EmbeddingWorkerConfig{
+	BatchSize:  10,
+	Block:      5 * time.Second,
+	MaxRetries: 5,
+	RetryIdle:  30 * time.Second,
+}
+
+// EmbeddingWorker 从 Redis Stream 消费任务，计算并保存用户喜好的嵌入向量
+type EmbeddingWorker struct {
+	rdb      *redis.Client
+	userRepo repository.UserRepository
+	embedder pkg.Embedder
+	logger   *zap.Logger
+	config   EmbeddingWorkerConfig
+
+	// mu 保护 cancel 和 done，竞选协程和应用停止时的回调会并发调用 Start/Stop
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	// 本次运行结束后关闭，未运行过时为 nil
+	done chan struct{}
+}
+
+func NewEmbeddingWorker(rdb *redis.Client, userRepo repository.UserRepository, embedder pkg.Embedder, logger *zap.Logger, conf *viper.Viper) *EmbeddingWorker {
+	config := DefaultEmbeddingWorkerConfig
+	config.Consumer = conf.GetString("embedding.worker.consumer")
+	if config.Consumer == "" {
+		// 默认使用主机名，保证多副本之间不重复
+		config.Consumer, _ = os.Hostname()
+	}
+	if conf.IsSet("embedding.worker.batch_size") {
+		config.BatchSize = conf.GetInt64("embedding.worker.batch_size")
+	}
+	if conf.IsSet("embedding.worker.block") {
+		config.Block = conf.GetDuration("embedding.worker.block")
+	}
+	if conf.IsSet("embedding.worker.max_retries") {
+		config.MaxRetries = conf.GetInt64("embedding.worker.max_retries")
+	}
+	if conf.IsSet("embedding.worker.retry_idle") {
+		config.RetryIdle = conf.GetDuration("embedding.worker.retry_idle")
+	}
+
+	return &EmbeddingWorker{
+		rdb:      rdb,
+		userRepo: userRepo,
+		embedder: embedder,
+		logger:   logger,
+		config:   config,
+	}
+}
+
+// StartEmbeddingWorker 只在 leader 副本上运行嵌入任务消费者，失去领导权或应用停止时停止消费
+func StartEmbeddingWorker(lc fx.Lifecycle, w *EmbeddingWorker, elector *pkg.LeaderElector) {
+	// 消费随任期的 ctx 结束，失去领导权时立即停止读取新任务
+	elector.OnElected(func(ctx context.Context) {
+		w.Start(ctx)
+	})
+	elector.OnRevoked(func() {
+		w.logger.Info("stopping embedding worker, leadership revoked")
+		if err := w.Stop(context.Background()); err != nil {
+			w.logger.Error("Failed to stop embedding worker", zap.Error(err))
+		}
+	})
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			w.logger.Info("stopping embedding worker")
+			return w.Stop(ctx)
+		},
+	})
+}
+
+// Start 在后台开始消费，ctx 结束或调用 Stop 时停止，已在运行时不做任何事
+func (w *EmbeddingWorker) Start(ctx context.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running() {
+		return
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	w.logger.Info("starting embedding worker", zap.String("consumer", w.config.Consumer))
+	go w.run(runCtx)
+}
+
+// Stop 停止消费并等待正在处理的任务结束，可重复调用
+func (w *EmbeddingWorker) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	cancel, done := w.cancel, w.done
+	w.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// running 本次运行是否尚未结束，调用方需持有 w.mu
+func (w *EmbeddingWorker) running() bool {
+	if w.done == nil {
+		return false
+	}
+	select {
+	case <-w.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (w *EmbeddingWorker) run(ctx context.Context) {
+	defer close(w.done)
+
+	groupReady := false
+	for ctx.Err() == nil {
+		if !groupReady {
+			if err := w.ensureGroup(ctx); err != nil {
+				w.logger.Error("Failed to create embedding consumer group", zap.Error(err))
+				w.sleep(ctx, time.Second)
+				continue
+			}
+			groupReady = true
+		}
+
+		// 1.重新处理超时未确认的任务
+		if err := w.retryPending(ctx); err != nil && ctx.Err() == nil {
+			w.logger.Error("Failed to retry pending embedding jobs", zap.Error(err))
+		}
+
+		// 2.读取新任务
+		streams, err := w.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    embeddingGroup,
+			Consumer: w.config.Consumer,
+			Streams:  []string{EmbeddingStream, ">"},
+			Count:    w.config.BatchSize,
+			Block:    w.config.Block,
+		}).Result()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, redis.Nil) {
+				continue
+			}
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				// 任务流被删除，重新创建消费者组
+				groupReady = false
+				continue
+			}
+			w.logger.Error("Failed to read embedding jobs", zap.Error(err))
+			w.sleep(ctx, time.Second)
+			continue
+		}
+		for _, stream := range streams {
+			w.process(ctx, stream.Messages)
+		}
+	}
+}
+
+// ensureGroup 创建消费者组，已存在时忽略
+func (w *EmbeddingWorker) ensureGroup(ctx context.Context) error {
+	err := w.rdb.XGroupCreateMkStream(ctx, EmbeddingStream, embeddingGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// retryPending 认领空闲超时的任务重新处理，投递次数过多的转入死信流
+func (w *EmbeddingWorker) retryPending(ctx context.Context) error {
+	pending, err := w.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: EmbeddingStream,
+		Group:  embeddingGroup,
+		Idle:   w.config.RetryIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  w.config.BatchSize,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	var retry []string
+	for _, p := range pending {
+		if p.RetryCount >= w.config.MaxRetries {
+			if err := w.deadLetter(ctx, p); err != nil {
+				w.logger.Error("Failed to move embedding job to dead letter stream", zap.String("message_id", p.ID), zap.Error(err))
+			}
+			continue
+		}
+		retry = append(retry, p.ID)
+	}
+	if len(retry) == 0 {
+		return nil
+	}
+
+	messages, err := w.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   EmbeddingStream,
+		Group:    embeddingGroup,
+		Consumer: w.config.Consumer,
+		MinIdle:  w.config.RetryIdle,
+		Messages: retry,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	w.process(ctx, messages)
+	return nil
+}
+
+// process 批量计算一组任务的嵌入向量，成功写入后确认任务
+// 失败的任务不确认，空闲超时后由 retryPending 重新处理
+func (w *EmbeddingWorker) process(ctx context.Context, messages []redis.XMessage) {
+	if len(messages) == 0 {
+		return
+	}
+
+	userIDs := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		userID, _ := msg.Values["user_id"].(string)
+		userIDs = append(userIDs, userID)
+	}
+	users, err := w.userRepo.FindByUserIDs(ctx, userIDs)
+	if err != nil {
+		w.logger.Error("Failed to query users for embedding", zap.Error(err))
+		return
+	}
+	byID := make(map[string]*model.User, len(users))
+	for _, user := range users {
+		byID[user.UserID] = user
+	}
+
+	var (
+		texts   []string
+		targets []redis.XMessage
+		done    []string
+	)
+	for i, msg := range messages {
+		user, ok := byID[userIDs[i]]
+		if !ok || user.EmbeddingStatus == model.EmbeddingStatusReady {
+			// 用户已删除或已计算过，直接确认
+			done = append(done, msg.ID)
+			continue
+		}
+		if strings.TrimSpace(user.Like) == "" {
+			// 没有喜好文本，不请求嵌入，避免空输入让整批请求失败
+			done = append(done, msg.ID)
+			continue
+		}
+		texts = append(texts, user.Like)
+		targets = append(targets, msg)
+	}
+
+	if len(texts) > 0 {
+		embeddings, err := w.embedder.EmbedBatch(ctx, texts)
+		if err != nil {
+			w.logger.Warn("Embedding failed, jobs will be retried", zap.Int("count", len(texts)), zap.Error(err))
+		} else {
+			for i, msg := range targets {
+				userID, _ := msg.Values["user_id"].(string)
+				if err := w.userRepo.UpdateLikeEmbedding(ctx, userID, pkg.NewVector(embeddings[i])); err != nil {
+					w.logger.Error("Failed to save like embedding", zap.String("user_id", userID), zap.Error(err))
+					continue
+				}
+				done = append(done, msg.ID)
+			}
+		}
+	}
+
+	if len(done) > 0 {
+		if err := w.rdb.XAck(ctx, EmbeddingStream, embeddingGroup, done...).Err(); err != nil {
+			w.logger.Error("Failed to ack embedding jobs", zap.Error(err))
+		}
+	}
+}
+
+// deadLetter 将任务转入死信流并把用户的嵌入状态置为 failed
+func (w *EmbeddingWorker) deadLetter(ctx context.Context, p redis.XPendingExt) error {
+	messages, err := w.rdb.XRangeN(ctx, EmbeddingStream, p.ID, p.ID, 1).Result()
+	if err != nil {
+		return err
+	}
+	if len(messages) > 0 {
+		userID, _ := messages[0].Values["user_id"].(string)
+		if err := w.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: EmbeddingDeadLetterStream,
+			MaxLen: embeddingStreamMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{
+				"user_id":    userID,
+				"message_id": p.ID,
+				"retries":    p.RetryCount,
+			},
+		}).Err(); err != nil {
+			return err
+		}
+		if err := w.userRepo.UpdateEmbeddingStatus(ctx, userID, model.EmbeddingStatusFailed); err != nil {
+			return err
+		}
+		w.logger.Warn("Embedding job moved to dead letter stream", zap.String("user_id", userID), zap.Int64("retries", p.RetryCount))
+	}
+	return w.rdb.XAck(ctx, EmbeddingStream, embeddingGroup, p.ID).Err()
+}
+
+// sleep 可被 ctx 打断的等待
+func (w *EmbeddingWorker) sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
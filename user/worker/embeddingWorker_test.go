@@ -0,0 +1,256 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+	"tx-demo/model"
+	"tx-demo/pkg"
+)
+
+func newTestWorker(t *testing.T, users *fakeUserRepository, embedder *fakeEmbedder, config EmbeddingWorkerConfig) *EmbeddingWorker {
+	t.Helper()
+	_, rdb := newTestRedis(t)
+	w := &EmbeddingWorker{
+		rdb:      rdb,
+		userRepo: users,
+		embedder: embedder,
+		logger:   zap.NewNop(),
+		config:   config,
+	}
+	w.Start(context.Background())
+	t.Cleanup(func() { _ = w.Stop(context.Background()) })
+	return w
+}
+
+var testWorkerConfig = EmbeddingWorkerConfig{
+	Consumer:   "test",
+	BatchSize:  10,
+	Block:      10 * time.Millisecond,
+	MaxRetries: 3,
+	RetryIdle:  20 * time.Millisecond,
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func pendingCount(t *testing.T, w *EmbeddingWorker) int64 {
+	t.Helper()
+	pending, err := w.rdb.XPending(context.Background(), EmbeddingStream, embeddingGroup).Result()
+	if err != nil {
+		t.Fatalf("XPending() error = %v", err)
+	}
+	return pending.Count
+}
+
+func TestEmbeddingWorker_AckOnSuccess(t *testing.T) {
+	users := newFakeUserRepository(
+		&model.User{ID: 1, UserID: "u1", Like: "music", EmbeddingStatus: model.EmbeddingStatusPending},
+		&model.User{ID: 2, UserID: "u2", Like: "sports", EmbeddingStatus: model.EmbeddingStatusReady},
+	)
+	embedder := newFakeEmbedder(0)
+	w := newTestWorker(t, users, embedder, testWorkerConfig)
+	queue := NewEmbeddingQueue(w.rdb)
+	ctx := context.Background()
+	for _, id := range []string{"u1", "u2", "deleted"} {
+		if err := queue.Enqueue(ctx, id); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	waitFor(t, "embedding saved", func() bool { return users.user("u1").EmbeddingStatus == model.EmbeddingStatusReady })
+	waitFor(t, "jobs acked", func() bool { return pendingCount(t, w) == 0 })
+	// 已计算过和已删除的用户不再请求嵌入
+	if len(embedder.texts) != 1 || embedder.texts[0] != "music" {
+		t.Errorf("embedded texts = %v, want [music]", embedder.texts)
+	}
+}
+
+func TestEmbeddingWorker_SkipEmptyLike(t *testing.T) {
+	users := newFakeUserRepository(
+		&model.User{ID: 1, UserID: "u1", Like: "music", EmbeddingStatus: model.EmbeddingStatusPending},
+		&model.User{ID: 2, UserID: "u2", Like: " ", EmbeddingStatus: model.EmbeddingStatusPending},
+	)
+	embedder := newFakeEmbedder(0)
+	w := newTestWorker(t, users, embedder, testWorkerConfig)
+	queue := NewEmbeddingQueue(w.rdb)
+	for _, id := range []string{"u2", "u1"} {
+		if err := queue.Enqueue(context.Background(), id); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	// 空喜好直接确认，不影响同一批的其他任务
+	waitFor(t, "embedding saved", func() bool { return users.user("u1").EmbeddingStatus == model.EmbeddingStatusReady })
+	waitFor(t, "jobs acked", func() bool { return pendingCount(t, w) == 0 })
+	if len(embedder.texts) != 1 || embedder.texts[0] != "music" {
+		t.Errorf("embedded texts = %q, want [music]", embedder.texts)
+	}
+	if status := users.user("u2").EmbeddingStatus; status != model.EmbeddingStatusPending {
+		t.Errorf("EmbeddingStatus of empty like = %q, want pending", status)
+	}
+}
+
+func TestEmbeddingWorker_RetryPending(t *testing.T) {
+	users := newFakeUserRepository(&model.User{ID: 1, UserID: "u1", Like: "music", EmbeddingStatus: model.EmbeddingStatusPending})
+	embedder := newFakeEmbedder(1)
+	w := newTestWorker(t, users, embedder, testWorkerConfig)
+	if err := NewEmbeddingQueue(w.rdb).Enqueue(context.Background(), "u1"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// 第一次失败不确认，空闲超时后被重新认领并成功
+	waitFor(t, "retried embedding saved", func() bool { return users.user("u1").EmbeddingStatus == model.EmbeddingStatusReady })
+	waitFor(t, "job acked", func() bool { return pendingCount(t, w) == 0 })
+	if calls := embedder.callCount(); calls != 2 {
+		t.Errorf("EmbedBatch calls = %d, want 2", calls)
+	}
+	if n, _ := w.rdb.XLen(context.Background(), EmbeddingDeadLetterStream).Result(); n != 0 {
+		t.Errorf("dead letter stream length = %d, want 0", n)
+	}
+}
+
+func TestEmbeddingWorker_DeadLetter(t *testing.T) {
+	users := newFakeUserRepository(&model.User{ID: 1, UserID: "u1", Like: "music", EmbeddingStatus: model.EmbeddingStatusPending})
+	embedder := newFakeEmbedder(1000)
+	w := newTestWorker(t, users, embedder, testWorkerConfig)
+	ctx := context.Background()
+	if err := NewEmbeddingQueue(w.rdb).Enqueue(ctx, "u1"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	waitFor(t, "dead letter", func() bool {
+		n, _ := w.rdb.XLen(ctx, EmbeddingDeadLetterStream).Result()
+		return n == 1
+	})
+	waitFor(t, "job acked", func() bool { return pendingCount(t, w) == 0 })
+	if status := users.user("u1").EmbeddingStatus; status != model.EmbeddingStatusFailed {
+		t.Errorf("EmbeddingStatus = %q, want failed", status)
+	}
+	dead, _ := w.rdb.XRange(ctx, EmbeddingDeadLetterStream, "-", "+").Result()
+	if dead[0].Values["user_id"] != "u1" {
+		t.Errorf("dead letter = %v", dead[0].Values)
+	}
+	// 最多投递 MaxRetries 次
+	if calls := embedder.callCount(); calls != int(testWorkerConfig.MaxRetries) {
+		t.Errorf("EmbedBatch calls = %d, want %d", calls, testWorkerConfig.MaxRetries)
+	}
+}
+
+func TestStartEmbeddingWorker_LeaderOnly(t *testing.T) {
+	users := newFakeUserRepository(&model.User{ID: 1, UserID: "u1", Like: "music", EmbeddingStatus: model.EmbeddingStatusPending})
+	_, rdb := newTestRedis(t)
+	w := &EmbeddingWorker{
+		rdb:      rdb,
+		userRepo: users,
+		embedder: newFakeEmbedder(0),
+		logger:   zap.NewNop(),
+		config:   testWorkerConfig,
+	}
+	conf := viper.New()
+	conf.Set("leader.ttl", 300*time.Millisecond)
+	conf.Set("leader.retry_interval", 10*time.Millisecond)
+	other := pkg.NewLeaderElector(rdb, zap.NewNop(), conf)
+	elector := pkg.NewLeaderElector(rdb, zap.NewNop(), conf)
+	t.Cleanup(func() {
+		_ = other.Stop(context.Background())
+		_ = elector.Stop(context.Background())
+	})
+
+	other.Start()
+	waitFor(t, "other replica elected", other.IsLeader)
+	lc := fxtest.NewLifecycle(t)
+	StartEmbeddingWorker(lc, w, elector)
+	pkg.StartLeaderElector(lc, elector)
+	lc.RequireStart()
+	if err := NewEmbeddingQueue(rdb).Enqueue(context.Background(), "u1"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// 非 leader 副本不消费任务
+	time.Sleep(100 * time.Millisecond)
+	if status := users.user("u1").EmbeddingStatus; status != model.EmbeddingStatusPending {
+		t.Fatalf("EmbeddingStatus = %s on follower, want pending", status)
+	}
+
+	// 接替成为 leader 后开始消费
+	if err := other.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	waitFor(t, "embedding saved by new leader", func() bool { return users.user("u1").EmbeddingStatus == model.EmbeddingStatusReady })
+	lc.RequireStop()
+}
+
+// isRunning 工作协程是否仍在运行
+func isRunning(w *EmbeddingWorker) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.running()
+}
+
+func TestStartEmbeddingWorker_Reelection(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	w := &EmbeddingWorker{
+		rdb:      rdb,
+		userRepo: newFakeUserRepository(),
+		embedder: newFakeEmbedder(0),
+		logger:   zap.NewNop(),
+		config:   testWorkerConfig,
+	}
+	conf := viper.New()
+	conf.Set("leader.ttl", 150*time.Millisecond)
+	conf.Set("leader.retry_interval", 10*time.Millisecond)
+	elector := pkg.NewLeaderElector(rdb, zap.NewNop(), conf)
+	lc := fxtest.NewLifecycle(t)
+	StartEmbeddingWorker(lc, w, elector)
+	pkg.StartLeaderElector(lc, elector)
+	lc.RequireStart()
+
+	waitFor(t, "elected", elector.IsLeader)
+	waitFor(t, "worker started", func() bool { return isRunning(w) })
+
+	// 领导权被抢占后，任期 ctx 结束，消费随之停止
+	mr.Set("lock:leader", "other")
+	waitFor(t, "leadership revoked", func() bool { return !elector.IsLeader() })
+	waitFor(t, "worker stopped", func() bool { return !isRunning(w) })
+
+	// 重新当选后再次开始消费
+	mr.Del("lock:leader")
+	waitFor(t, "re-elected", elector.IsLeader)
+	waitFor(t, "worker restarted", func() bool { return isRunning(w) })
+
+	// 失去领导权与应用停止并发发生
+	mr.Set("lock:leader", "other")
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for _, stop := range []func(ctx context.Context) error{lc.Stop, w.Stop, w.Stop} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- stop(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("stop error = %v", err)
+		}
+	}
+	if isRunning(w) {
+		t.Error("worker still running after stop")
+	}
+}
@@ -0,0 +1,233 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"tx-demo/model"
+	"tx-demo/pkg"
+	"tx-demo/repository"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, rdb
+}
+
+// fakeUserRepository 内存实现的 UserRepository，只实现任务用到的方法
+type fakeUserRepository struct {
+	repository.UserRepository
+
+	mu    sync.Mutex
+	users map[string]*model.User
+}
+
+func newFakeUserRepository(users ...*model.User) *fakeUserRepository {
+	f := &fakeUserRepository{users: make(map[string]*model.User)}
+	for _, user := range users {
+		f.users[user.UserID] = user
+	}
+	return f
+}
+
+func (f *fakeUserRepository) user(userID string) model.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *f.users[userID]
+}
+
+func (f *fakeUserRepository) FindByUserIDs(ctx context.Context, userIDs []string) ([]*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var users []*model.User
+	for _, id := range userIDs {
+		if user, ok := f.users[id]; ok {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeUserRepository) UpdateLikeEmbedding(ctx context.Context, userID string, embedding pkg.Vector) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return errors.New("user not found")
+	}
+	user.LikeEmbedding = embedding
+	user.EmbeddingStatus = model.EmbeddingStatusReady
+	return nil
+}
+
+func (f *fakeUserRepository) UpdateEmbeddingStatus(ctx context.Context, userID string, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[userID]; ok {
+		user.EmbeddingStatus = status
+	}
+	return nil
+}
+
+func (f *fakeUserRepository) sorted(afterID int64, statuses []string) []*model.User {
+	var users []*model.User
+	for _, user := range f.users {
+		if user.ID <= afterID {
+			continue
+		}
+		if len(statuses) > 0 {
+			matched := false
+			for _, status := range statuses {
+				matched = matched || user.EmbeddingStatus == status
+			}
+			if !matched {
+				continue
+			}
+		}
+		copied := *user
+		users = append(users, &copied)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+func (f *fakeUserRepository) ListUsersAfterID(ctx context.Context, afterID int64, limit int, statuses []string) ([]*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := f.sorted(afterID, statuses)
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (f *fakeUserRepository) CountUsersAfterID(ctx context.Context, afterID int64, statuses []string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return int64(len(f.sorted(afterID, statuses))), nil
+}
+
+// fakeEmbedder 前 failures 次 EmbedBatch 返回错误，之后使用本地哈希向量，包含空文本时返回错误
+type fakeEmbedder struct {
+	*pkg.LocalEmbedder
+
+	mu       sync.Mutex
+	failures int
+	calls    int
+	texts    []string
+}
+
+func newFakeEmbedder(failures int) *fakeEmbedder {
+	return &fakeEmbedder{LocalEmbedder: pkg.NewLocalEmbedder(8), failures: failures}
+}
+
+func (e *fakeEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	e.mu.Lock()
+	e.calls++
+	if e.calls <= e.failures {
+		e.mu.Unlock()
+		return nil, errors.New("provider unavailable")
+	}
+	// 与真实的提供者一致，空输入使整批请求失败
+	for _, text := range texts {
+		if strings.TrimSpace(text) == "" {
+			e.mu.Unlock()
+			return nil, errors.New("empty input")
+		}
+	}
+	e.texts = append(e.texts, texts...)
+	e.mu.Unlock()
+	return e.LocalEmbedder.EmbedBatch(ctx, texts)
+}
+
+func (e *fakeEmbedder) callCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls
+}
+
+// fakeInterestRepository 内存实现的 InterestRepository，failUserID 的兴趣写入时返回错误
+type fakeInterestRepository struct {
+	repository.InterestRepository
+
+	mu         sync.Mutex
+	interests  []*model.UserInterest
+	failUserID string
+}
+
+func (f *fakeInterestRepository) ListByUserIDs(ctx context.Context, userIDs []string) ([]*model.UserInterest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var interests []*model.UserInterest
+	for _, interest := range f.interests {
+		for _, id := range userIDs {
+			if interest.UserID == id {
+				copied := *interest
+				interests = append(interests, &copied)
+			}
+		}
+	}
+	return interests, nil
+}
+
+func (f *fakeInterestRepository) UpdateEmbedding(ctx context.Context, interest *model.UserInterest, embedding pkg.Vector) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if interest.UserID == f.failUserID {
+		return errors.New("write failed")
+	}
+	for _, stored := range f.interests {
+		if stored.ID == interest.ID {
+			stored.Embedding = embedding
+		}
+	}
+	return nil
+}
+
+// fakeTransaction 在 fn 返回错误时把两个内存仓库恢复到事务开始前的状态
+type fakeTransaction struct {
+	users     *fakeUserRepository
+	interests *fakeInterestRepository
+	calls     int
+}
+
+func (f *fakeTransaction) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	f.calls++
+	users := make(map[string]model.User)
+	f.users.mu.Lock()
+	for id, user := range f.users.users {
+		users[id] = *user
+	}
+	f.users.mu.Unlock()
+	f.interests.mu.Lock()
+	interests := make([]model.UserInterest, len(f.interests.interests))
+	for i, interest := range f.interests.interests {
+		interests[i] = *interest
+	}
+	f.interests.mu.Unlock()
+
+	err := fn(ctx)
+	if err != nil {
+		f.users.mu.Lock()
+		for id, user := range users {
+			*f.users.users[id] = user
+		}
+		f.users.mu.Unlock()
+		f.interests.mu.Lock()
+		for i := range interests {
+			*f.interests.interests[i] = interests[i]
+		}
+		f.interests.mu.Unlock()
+	}
+	return err
+}